@@ -0,0 +1,49 @@
+package root
+
+import (
+	"fmt"
+
+	"github.com/inercia/MCPShell/pkg/audit"
+	"github.com/spf13/cobra"
+)
+
+// auditCommand groups the commands for the audit log
+var auditCommand = &cobra.Command{
+	Use:   "audit",
+	Short: "Manage the audit log of tool invocations",
+	Long: `Manage the audit log of tool invocations (see the --audit-log flag of the mcp command).
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+// auditVerifyCommand verifies the hash chain of an audit log
+var auditVerifyCommand = &cobra.Command{
+	Use:   "verify FILE",
+	Short: "Verify the hash chain of an audit log",
+	Long: `Verify the hash chain of an audit log written with --audit-hash-chain.
+
+The rotated files of the log (FILE.1, FILE.2, ...) are also verified, from the
+oldest to the most recent one, starting from the hash saved in FILE.anchor when old
+rotated files have been removed. The command fails if some record has been modified,
+removed or reordered. The hashes are not keyed, so this detects damaged or truncated
+logs, not a log rewritten on purpose by someone with write access.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		count, err := audit.Verify(args[0])
+		if err != nil {
+			return fmt.Errorf("audit log verification failed after %d valid records: %w", count, err)
+		}
+
+		fmt.Printf("OK: %d records verified\n", count)
+		return nil
+	},
+}
+
+// init adds the audit commands to the root command
+func init() {
+	auditCommand.AddCommand(auditVerifyCommand)
+	rootCmd.AddCommand(auditCommand)
+}
@@ -119,7 +119,7 @@ will be reported.
 			}
 
 			// Convert parameter value to appropriate type based on parameter config
-			typedValue, err := common.ConvertStringToType(paramValue, paramConfig.Type)
+			typedValue, err := common.ConvertStringToParam(paramValue, paramConfig)
 			if err != nil {
 				logger.Error("Failed to convert parameter value: %v", err)
 				return fmt.Errorf("failed to convert parameter value: %w", err)
@@ -170,6 +170,7 @@ will be reported.
 			logger.Error("Failed to create command handler: %v", err)
 			return fmt.Errorf("failed to create command handler: %w", err)
 		}
+		handler.SetTemplateFunctions(cfg.MCP.Run.TemplateFunctions)
 
 		// Execute the command directly
 		result, err := handler.ExecuteCommand(params)
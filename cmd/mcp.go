@@ -1,11 +1,14 @@
 package root
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/inercia/MCPShell/pkg/audit"
 	"github.com/inercia/MCPShell/pkg/common"
 	"github.com/inercia/MCPShell/pkg/config"
 	"github.com/inercia/MCPShell/pkg/server"
@@ -14,9 +17,23 @@ import (
 )
 
 var (
-	useHTTP  bool
-	httpPort int
-	daemon   bool
+	useHTTP       bool
+	httpPort      int
+	httpBind      string
+	httpPath      string
+	keepAlive     time.Duration
+	authTokens    string
+	tlsCert       string
+	tlsKey        string
+	tlsClientCA   string
+	daemon        bool
+	watchConfig   bool
+	watchInterval time.Duration
+
+	auditLog        string
+	auditMaxSize    int
+	auditMaxBackups int
+	auditHashChain  bool
 )
 
 // mcpCommand represents the run command which starts the MCP server
@@ -32,8 +49,15 @@ and expooses the tools defined in a MCP configuration file.
 The server loads tool definitions from a MCP configuration file and makes them
 available to AI applications via the MCP protocol.
 
-When using --http mode, you can also use --daemon to run the server in the background
+When using --http mode, the server provides the Streamable HTTP transport (at /mcp by
+default) and the legacy SSE transport (at /sse) for older clients. Use --auth-tokens
+for requiring clients to authenticate with a token, and --tls-cert/--tls-key for serving
+HTTPS (with --tls-client-ca for requiring client certificates). You can also use --daemon to run the server in the background
 and ignore SIGHUP signals.
+
+With --watch, the tools configuration files (and directories) are watched for changes
+and reloaded without restarting the server. Clients are notified of the new tools, and
+invalid configurations are ignored (keeping the previous tools).
 `,
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		// Initialize logger
@@ -58,6 +82,26 @@ and ignore SIGHUP signals.
 			return fmt.Errorf("failed to ensure tools directory: %w", err)
 		}
 
+		// Authentication is only supported with HTTP mode
+		if authTokens != "" && !useHTTP {
+			logger.Error("Authentication tokens are only supported with HTTP mode")
+			return fmt.Errorf("authentication tokens are only supported with HTTP mode (use --http flag)")
+		}
+
+		// TLS is only supported with HTTP mode, and requires both the certificate and the key
+		if (tlsCert != "" || tlsKey != "" || tlsClientCA != "") && !useHTTP {
+			logger.Error("TLS is only supported with HTTP mode")
+			return fmt.Errorf("TLS is only supported with HTTP mode (use --http flag)")
+		}
+		if (tlsCert == "") != (tlsKey == "") {
+			logger.Error("Both the TLS certificate and key are required")
+			return fmt.Errorf("both --tls-cert and --tls-key are required for TLS")
+		}
+		if tlsClientCA != "" && tlsCert == "" {
+			logger.Error("Client certificates require TLS")
+			return fmt.Errorf("--tls-client-ca requires --tls-cert and --tls-key")
+		}
+
 		// Daemon mode is only supported with HTTP mode
 		if daemon && !useHTTP {
 			logger.Error("Daemon mode is only supported with HTTP mode")
@@ -89,6 +133,25 @@ and ignore SIGHUP signals.
 		// Ensure temporary files are cleaned up
 		defer cleanup()
 
+		// Open the audit log, if enabled
+		var auditLogger *audit.Logger
+		if auditLog != "" {
+			auditLogger, err = audit.NewLogger(audit.Config{
+				Path:       auditLog,
+				MaxSize:    int64(auditMaxSize) * 1024 * 1024,
+				MaxBackups: auditMaxBackups,
+				HashChain:  auditHashChain,
+			})
+			if err != nil {
+				logger.Error("Failed to open audit log: %v", err)
+				return fmt.Errorf("failed to open audit log: %w", err)
+			}
+			defer func() {
+				_ = auditLogger.Close()
+			}()
+			logger.Info("Recording tool invocations in audit log: %s", auditLog)
+		}
+
 		// Create and start the server
 		srv := server.New(server.Config{
 			ConfigFile:          localConfigPath,
@@ -97,14 +160,33 @@ and ignore SIGHUP signals.
 			Descriptions:        description,
 			DescriptionFiles:    descriptionFile,
 			DescriptionOverride: descriptionOverride,
+			AuditLogger:         auditLogger,
 		})
 
+		// Reload the tools when the configuration changes
+		if watchConfig {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go srv.Watch(ctx, toolsFiles, watchInterval)
+		}
+
 		if useHTTP {
 			// Set up SIGHUP handling for daemon mode
 			if daemon {
 				setupSIGHUPHandler(logger)
 			}
-			return srv.StartHTTP(httpPort)
+			return srv.StartHTTP(server.HTTPConfig{
+				Address:   httpBind,
+				Port:      httpPort,
+				Path:      httpPath,
+				KeepAlive: keepAlive,
+
+				AuthTokensFile: authTokens,
+
+				TLSCertFile:     tlsCert,
+				TLSKeyFile:      tlsKey,
+				TLSClientCAFile: tlsClientCA,
+			})
 		}
 		return srv.Start()
 	},
@@ -135,10 +217,27 @@ func init() {
 	mcpCommand.Flags().BoolVarP(&descriptionOverride, "description-override", "", false, "Override the description found in the config file")
 
 	// Add HTTP server flags
-	mcpCommand.Flags().BoolVar(&useHTTP, "http", false, "Enable HTTP server mode (serve MCP over HTTP/SSE instead of stdio)")
+	mcpCommand.Flags().BoolVar(&useHTTP, "http", false, "Enable HTTP server mode (serve MCP over Streamable HTTP and SSE instead of stdio)")
 	mcpCommand.Flags().IntVar(&httpPort, "port", 8080, "Port for HTTP server (default: 8080, only used with --http)")
+	mcpCommand.Flags().StringVar(&httpBind, "bind", "", "Address to bind the HTTP server to (default: all interfaces, only used with --http)")
+	mcpCommand.Flags().StringVar(&httpPath, "http-path", server.DefaultHTTPPath, "Path of the Streamable HTTP endpoint (only used with --http)")
+	mcpCommand.Flags().DurationVar(&keepAlive, "keep-alive", server.DefaultKeepAliveInterval, "Interval between keep-alive pings for HTTP/SSE clients, 0 disables them (only used with --http)")
+	mcpCommand.Flags().StringVar(&authTokens, "auth-tokens", "", "File with the tokens accepted by the HTTP server, and the tools allowed for each token (only used with --http)")
+	mcpCommand.Flags().StringVar(&tlsCert, "tls-cert", "", "TLS certificate file for serving HTTPS (only used with --http)")
+	mcpCommand.Flags().StringVar(&tlsKey, "tls-key", "", "TLS private key file for serving HTTPS (only used with --http)")
+	mcpCommand.Flags().StringVar(&tlsClientCA, "tls-client-ca", "", "CA certificates file for requiring and verifying client certificates (only used with --tls-cert)")
 	mcpCommand.Flags().BoolVar(&daemon, "daemon", false, "Run in daemon mode (background process, ignores SIGHUP, only works with --http)")
 
+	// Add configuration reload flags
+	mcpCommand.Flags().BoolVar(&watchConfig, "watch", false, "Watch the tools configuration files and reload them when they change")
+	mcpCommand.Flags().DurationVar(&watchInterval, "watch-interval", config.DefaultWatchInterval, "Interval for checking the tools configuration files for changes (only used with --watch)")
+
+	// Add audit log flags
+	mcpCommand.Flags().StringVar(&auditLog, "audit-log", "", "File for recording all the tool invocations as JSON lines (optional)")
+	mcpCommand.Flags().IntVar(&auditMaxSize, "audit-max-size", 100, "Maximum size (in MB) of the audit log before rotating it, 0 disables rotation")
+	mcpCommand.Flags().IntVar(&auditMaxBackups, "audit-max-backups", 5, "Maximum number of rotated audit logs to keep, 0 keeps all of them")
+	mcpCommand.Flags().BoolVar(&auditHashChain, "audit-hash-chain", false, "Link the audit records with a hash chain, for detecting modified or removed records with 'audit verify'")
+
 	// Mark required flags
 	_ = mcpCommand.MarkFlagRequired("tools")
 }
@@ -0,0 +1,47 @@
+package root
+
+import (
+	"fmt"
+
+	"github.com/inercia/MCPShell/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+// hashTokenCommand represents the command for generating the hashes of authentication tokens
+var hashTokenCommand = &cobra.Command{
+	Use:   "hash-token [TOKEN]",
+	Short: "Hash a token for the authentication tokens file",
+	Long: `Hash a token for the authentication tokens file (see the --auth-tokens flag
+of the mcp command).
+
+When no token is provided, a new random token is generated. The token must be given
+to the client, while only its hash is stored in the tokens file:
+
+  tokens:
+    - name: ci-agent
+      hash: "sha256:..."
+      tools: ["get_pods", "get_logs"]
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var token string
+		if len(args) > 0 {
+			token = args[0]
+		} else {
+			generated, err := server.GenerateToken()
+			if err != nil {
+				return err
+			}
+			token = generated
+			fmt.Printf("token: %s\n", token)
+		}
+
+		fmt.Printf("hash: %s\n", server.HashToken(token))
+		return nil
+	},
+}
+
+// init adds the hash-token command to the root command
+func init() {
+	rootCmd.AddCommand(hashTokenCommand)
+}
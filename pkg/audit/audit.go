@@ -0,0 +1,365 @@
+// Package audit provides a structured audit log of the tool invocations.
+//
+// Every invocation is recorded as a JSON line, with the identity of the client, the
+// arguments, the command executed and its outcome. Log files are rotated when they
+// reach a maximum size and, optionally, records are linked with a hash chain so any
+// modification (or removal) of records can be detected with Verify. The chain is not
+// keyed, so it detects accidental damage (like a truncated or edited file), not
+// deliberate tampering by someone who can rewrite the whole log.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status values of the records
+const (
+	StatusSuccess          = "success"           // the command was executed successfully
+	StatusInvalidArguments = "invalid_arguments" // the arguments did not match the parameters
+	StatusBlocked          = "blocked"           // some constraint was not satisfied
+	StatusError            = "error"             // the command could not be executed, or it failed
+	StatusCancelled        = "cancelled"         // the execution was cancelled (ie, by the client)
+	StatusBusy             = "busy"              // the command was not run because of the concurrency limits (or a lock)
+	StatusLimited          = "limited"           // the call exceeded a rate limit or a quota
+)
+
+// Outcomes of the constraints evaluation
+const (
+	ConstraintsNone   = "none"   // the tool has no constraints (or they were not evaluated)
+	ConstraintsPassed = "passed" // all the constraints were satisfied
+	ConstraintsFailed = "failed" // some constraint was not satisfied
+)
+
+// Record is an entry in the audit log
+type Record struct {
+	Time              time.Time              `json:"time"`
+	Client            string                 `json:"client,omitempty"`         // name of the client (token or certificate name)
+	ClientSubject     string                 `json:"client_subject,omitempty"` // subject of the client certificate
+	Session           string                 `json:"session,omitempty"`        // MCP session ID
+	Tool              string                 `json:"tool"`
+	Arguments         map[string]interface{} `json:"arguments,omitempty"`
+	Command           string                 `json:"command,omitempty"` // the rendered command
+	Runner            string                 `json:"runner,omitempty"`
+	Constraints       string                 `json:"constraints"`
+	FailedConstraints []string               `json:"failed_constraints,omitempty"`
+	Status            string                 `json:"status"`
+	ExitCode          *int                   `json:"exit_code,omitempty"`
+	Error             string                 `json:"error,omitempty"`
+	DurationMs        int64                  `json:"duration_ms"`
+	OutputSize        int                    `json:"output_size"`
+	Cached            bool                   `json:"cached,omitempty"` // whether the result was returned from the cache
+
+	// PrevHash and Hash link the records when the hash chain is enabled.
+	// Hash must be the last field, as it is computed over the rest of the record.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Config contains the configuration of the audit log
+type Config struct {
+	Path       string // Path to the log file
+	MaxSize    int64  // Maximum size of the log file in bytes before rotating it (0 disables rotation)
+	MaxBackups int    // Maximum number of rotated files to keep (0 keeps all of them)
+	HashChain  bool   // Whether to link the records with a hash chain
+}
+
+// Logger writes records to the audit log. It is safe for concurrent use.
+type Logger struct {
+	config Config
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	lastHash string
+}
+
+// NewLogger opens (or creates) the audit log. When the hash chain is enabled and the
+// log already has records, the chain continues from the last record.
+//
+// Parameters:
+//   - config: The configuration of the audit log
+//
+// Returns:
+//   - A new Logger
+//   - An error if the log file cannot be opened
+func NewLogger(config Config) (*Logger, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("no audit log file provided")
+	}
+
+	l := &Logger{config: config}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	if config.HashChain {
+		lastHash, err := findLastHash(config.Path)
+		if err != nil {
+			_ = l.file.Close()
+			return nil, err
+		}
+		l.lastHash = lastHash
+	}
+
+	return l, nil
+}
+
+// open opens the log file for appending
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", l.config.Path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to access audit log %s: %w", l.config.Path, err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Log writes a record to the audit log, rotating the file if needed.
+//
+// Parameters:
+//   - record: The record to write (the time is set when empty)
+//
+// Returns:
+//   - An error if the record cannot be written
+func (l *Logger) Log(record Record) error {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Time = record.Time.UTC()
+	record.PrevHash = ""
+	record.Hash = ""
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+
+	if l.config.HashChain {
+		record.PrevHash = l.lastHash
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	var line []byte
+	hash := ""
+	if l.config.HashChain {
+		hash = hashRecord(data)
+		line = appendHash(data, hash)
+	} else {
+		line = data
+	}
+	line = append(line, '\n')
+
+	if l.config.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.config.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	if hash != "" {
+		l.lastHash = hash
+	}
+	return nil
+}
+
+// Close closes the audit log
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// rotate renames the current file to <path>.1 (shifting the previous backups) and opens a new one
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	l.file = nil
+
+	backups := countBackups(l.config.Path)
+	if l.config.MaxBackups > 0 {
+		for backups >= l.config.MaxBackups {
+			// the first record of the oldest backup kept is linked to the last one removed
+			if l.config.HashChain {
+				if err := saveAnchor(l.config.Path, backupPath(l.config.Path, backups)); err != nil {
+					return err
+				}
+			}
+			if err := os.Remove(backupPath(l.config.Path, backups)); err != nil {
+				return fmt.Errorf("failed to remove old audit log: %w", err)
+			}
+			backups--
+		}
+	}
+	for i := backups; i >= 1; i-- {
+		if err := os.Rename(backupPath(l.config.Path, i), backupPath(l.config.Path, i+1)); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+	if err := os.Rename(l.config.Path, backupPath(l.config.Path, 1)); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	return l.open()
+}
+
+// backupPath returns the path of the n-th rotated file (1 is the most recent one)
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// anchorPath returns the path of the file with the hash of the last record removed
+// from the log by the rotation (the value the first record of the chain is linked to)
+func anchorPath(path string) string {
+	return path + ".anchor"
+}
+
+// saveAnchor records the hash of the last record of a rotated file before removing it
+func saveAnchor(path string, file string) error {
+	hash, err := lastRecordHash(file)
+	if err != nil {
+		return err
+	}
+	if hash == "" {
+		return nil
+	}
+	if err := os.WriteFile(anchorPath(path), []byte(hash+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write audit log anchor: %w", err)
+	}
+	return nil
+}
+
+// readAnchor returns the hash the first record of the log must be linked to (an empty
+// string when no records have been removed by the rotation)
+func readAnchor(path string) (string, error) {
+	data, err := os.ReadFile(anchorPath(path))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read audit log anchor: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// countBackups returns the number of rotated files of the log
+func countBackups(path string) int {
+	n := 0
+	for {
+		if _, err := os.Stat(backupPath(path, n+1)); err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+// hashRecord returns the hash of an encoded record (without its hash)
+func hashRecord(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashSuffix returns the suffix added to an encoded record for including its hash
+func hashSuffix(hash string) string {
+	return `,"hash":"` + hash + `"}`
+}
+
+// appendHash adds the hash as the last field of an encoded record
+func appendHash(data []byte, hash string) []byte {
+	line := make([]byte, 0, len(data)+len(hash)+10)
+	line = append(line, data[:len(data)-1]...)
+	return append(line, hashSuffix(hash)...)
+}
+
+// findLastHash returns the hash of the last record in the log (or in its most recent
+// backup when the log is empty), or an empty string when there are no records.
+func findLastHash(path string) (string, error) {
+	for _, file := range []string{path, backupPath(path, 1)} {
+		hash, err := lastRecordHash(file)
+		if err != nil || hash != "" {
+			return hash, err
+		}
+	}
+	return "", nil
+}
+
+// lastRecordHash returns the hash of the last record of a file, or an empty string
+// when it has no records
+func lastRecordHash(file string) (string, error) {
+	line, err := readLastLine(file)
+	if err != nil || line == "" {
+		return "", err
+	}
+
+	var record Record
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return "", fmt.Errorf("failed to parse the last record of %s: %w", file, err)
+	}
+	return record.Hash, nil
+}
+
+// readLastLine returns the last non-empty line of a file (or an empty string if the
+// file does not exist or is empty), reading the file backwards.
+func readLastLine(path string) (string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to access %s: %w", path, err)
+	}
+
+	for chunk := int64(64 * 1024); ; chunk *= 2 {
+		offset := max(info.Size()-chunk, 0)
+		data := make([]byte, info.Size()-offset)
+		if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		content := strings.TrimRight(string(data), "\n")
+		if i := strings.LastIndexByte(content, '\n'); i >= 0 {
+			return content[i+1:], nil
+		}
+		if offset == 0 {
+			return content, nil
+		}
+	}
+}
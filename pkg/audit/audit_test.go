@@ -0,0 +1,228 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readRecords reads all the records in a log file
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Failed to parse record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogger_Log(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := NewLogger(Config{Path: path})
+	if err != nil {
+		t.Fatalf("NewLogger() unexpected error: %v", err)
+	}
+	exitCode := 0
+	err = l.Log(Record{
+		Tool:        "hello",
+		Client:      "ci-agent",
+		Arguments:   map[string]interface{}{"name": "world"},
+		Command:     "echo hello world",
+		Runner:      "exec",
+		Constraints: ConstraintsPassed,
+		Status:      StatusSuccess,
+		ExitCode:    &exitCode,
+		OutputSize:  12,
+	})
+	if err != nil {
+		t.Fatalf("Log() unexpected error: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+	if err := l.Log(Record{Tool: "hello"}); err == nil {
+		t.Error("Expected an error when logging to a closed log")
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	record := records[0]
+	if record.Tool != "hello" || record.Client != "ci-agent" || record.Command != "echo hello world" ||
+		record.Status != StatusSuccess || record.ExitCode == nil || *record.ExitCode != 0 || record.Time.IsZero() {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+	if record.Hash != "" || record.PrevHash != "" {
+		t.Errorf("Expected no hashes without the hash chain, got %+v", record)
+	}
+
+	if _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "record without hash") {
+		t.Errorf("Verify() error = %v, want error about records without hash", err)
+	}
+}
+
+func TestLogger_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := NewLogger(Config{Path: path, MaxSize: 400, MaxBackups: 2, HashChain: true})
+	if err != nil {
+		t.Fatalf("NewLogger() unexpected error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := l.Log(Record{Tool: "hello", Status: StatusSuccess}); err != nil {
+			t.Fatalf("Log() unexpected error: %v", err)
+		}
+	}
+	_ = l.Close()
+
+	if n := countBackups(path); n != 2 {
+		t.Errorf("Expected 2 rotated files, got %d", n)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > 400 {
+		t.Errorf("Expected the log to be smaller than the maximum size, got %v (%v)", info.Size(), err)
+	}
+
+	// the chain continues across rotated files, but the first ones have been removed
+	count, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if count == 0 || count >= 10 {
+		t.Errorf("Expected some (but not all) records to be verified, got %d", count)
+	}
+
+	// removing the oldest rotated file breaks the chain
+	if err := os.Remove(backupPath(path, 2)); err != nil {
+		t.Fatalf("Failed to remove rotated file: %v", err)
+	}
+	if _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "audit.log.1:1: record not linked") {
+		t.Errorf("Verify() error = %v, want error for the first record of the rotated file", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	writeLog := func() {
+		_ = os.Remove(path)
+		l, err := NewLogger(Config{Path: path, HashChain: true})
+		if err != nil {
+			t.Fatalf("NewLogger() unexpected error: %v", err)
+		}
+		for _, tool := range []string{"one", "two"} {
+			if err := l.Log(Record{Tool: tool, Status: StatusSuccess}); err != nil {
+				t.Fatalf("Log() unexpected error: %v", err)
+			}
+		}
+		_ = l.Close()
+
+		// the chain continues when the log is opened again
+		l, err = NewLogger(Config{Path: path, HashChain: true})
+		if err != nil {
+			t.Fatalf("NewLogger() unexpected error: %v", err)
+		}
+		if err := l.Log(Record{Tool: "three", Status: StatusSuccess}); err != nil {
+			t.Fatalf("Log() unexpected error: %v", err)
+		}
+		_ = l.Close()
+	}
+
+	readLines := func() []string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	writeLines := func(lines []string) {
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+			t.Fatalf("Failed to write log: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func()
+		want    int
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			tamper: func() {},
+			want:   3,
+		},
+		{
+			name: "modified record",
+			tamper: func() {
+				lines := readLines()
+				lines[1] = strings.Replace(lines[1], `"tool":"two"`, `"tool":"evil"`, 1)
+				writeLines(lines)
+			},
+			wantErr: "audit.log:2: hash mismatch",
+		},
+		{
+			name: "removed record",
+			tamper: func() {
+				lines := readLines()
+				writeLines([]string{lines[0], lines[2]})
+			},
+			wantErr: "audit.log:2: record not linked to the previous one",
+		},
+		{
+			name: "reordered records",
+			tamper: func() {
+				lines := readLines()
+				writeLines([]string{lines[0], lines[2], lines[1]})
+			},
+			wantErr: "audit.log:2: record not linked to the previous one",
+		},
+		{
+			name: "removed first record",
+			tamper: func() {
+				lines := readLines()
+				writeLines(lines[1:])
+			},
+			wantErr: "audit.log:1: record is not the first one of the chain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeLog()
+			tt.tamper()
+
+			count, err := Verify(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Verify() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() unexpected error: %v", err)
+			}
+			if count != tt.want {
+				t.Errorf("Verify() = %d records, want %d", count, tt.want)
+			}
+		})
+	}
+}
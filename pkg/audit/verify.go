@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxRecordSize is the maximum size of a record when reading the audit log
+const maxRecordSize = 16 * 1024 * 1024
+
+// Verify checks the hash chain of an audit log, including its rotated files
+// (from the oldest to the most recent one). Every record must have a valid hash
+// and must be linked to the previous record.
+//
+// The first record must be the first one of the chain or, when old rotated files have
+// been removed, be linked to the last record removed (saved by the rotation in
+// <path>.anchor). The hashes are not keyed, so this detects truncated or modified logs,
+// but not someone rewriting the log (and recomputing the hashes) on purpose.
+//
+// Parameters:
+//   - path: Path to the audit log
+//
+// Returns:
+//   - The number of records verified
+//   - An error describing the first problem found
+func Verify(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("failed to access audit log %s: %w", path, err)
+	}
+
+	var files []string
+	for i := countBackups(path); i >= 1; i-- {
+		files = append(files, backupPath(path, i))
+	}
+	files = append(files, path)
+
+	prevHash, err := readAnchor(path)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		n, lastHash, err := verifyFile(file, prevHash)
+		count += n
+		if err != nil {
+			return count, err
+		}
+		prevHash = lastHash
+	}
+
+	return count, nil
+}
+
+// verifyFile checks the records of a file, returning the number of records and the
+// hash of the last one. The first record of the file must be linked to prevHash.
+func verifyFile(path string, prevHash string) (int, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return count, prevHash, fmt.Errorf("%s:%d: invalid record: %w", path, lineNum, err)
+		}
+		if record.Hash == "" {
+			return count, prevHash, fmt.Errorf("%s:%d: record without hash (is the hash chain enabled?)", path, lineNum)
+		}
+		if record.PrevHash != prevHash {
+			if count == 0 && prevHash == "" {
+				return count, prevHash, fmt.Errorf("%s:%d: record is not the first one of the chain (records or files removed)", path, lineNum)
+			}
+			return count, prevHash, fmt.Errorf("%s:%d: record not linked to the previous one (records removed or reordered)", path, lineNum)
+		}
+
+		suffix := hashSuffix(record.Hash)
+		if !strings.HasSuffix(line, suffix) {
+			return count, prevHash, fmt.Errorf("%s:%d: hash is not the last field of the record", path, lineNum)
+		}
+		if hashRecord([]byte(line[:len(line)-len(suffix)]+"}")) != record.Hash {
+			return count, prevHash, fmt.Errorf("%s:%d: hash mismatch (record modified)", path, lineNum)
+		}
+
+		prevHash = record.Hash
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, prevHash, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+
+	return count, prevHash, nil
+}
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// cacheMaxEntries is the maximum number of results cached for a tool
+const cacheMaxEntries = 100
+
+// cachedResult is a result in the cache
+type cachedResult struct {
+	result   commandResult
+	storedAt time.Time
+}
+
+// resultCache caches the results of a tool for some time, indexed by a key built
+// from the arguments and the environment of the command (see resultCacheKey).
+type resultCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time // the current time (replaceable in tests)
+
+	mu      sync.Mutex
+	entries map[string]cachedResult
+}
+
+// newResultCache creates a cache for the results of a tool, keeping them for the given time.
+func newResultCache(ttl time.Duration) *resultCache {
+	return &resultCache{
+		ttl:        ttl,
+		maxEntries: cacheMaxEntries,
+		now:        time.Now,
+		entries:    map[string]cachedResult{},
+	}
+}
+
+// get returns a copy of the result cached for a key (marked as cached, with its age),
+// or false if there is no result or it has expired
+func (c *resultCache) get(key string) (*commandResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	age := c.now().Sub(entry.storedAt)
+	if age >= c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	result := entry.result
+	result.Cached = true
+	result.CacheAge = age
+	return &result, true
+}
+
+// put stores a copy of a result for a key, removing the expired results (and the
+// oldest one when the cache is full)
+func (c *resultCache) put(key string, result *commandResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	oldestKey, oldest := "", now
+	for k, entry := range c.entries {
+		if now.Sub(entry.storedAt) >= c.ttl {
+			delete(c.entries, k)
+		} else if entry.storedAt.Before(oldest) {
+			oldestKey, oldest = k, entry.storedAt
+		}
+	}
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		delete(c.entries, oldestKey)
+	}
+
+	c.entries[key] = cachedResult{result: *result, storedAt: now}
+}
+
+// resultCacheKey returns the key of the result of a tool call: a hash of the name of
+// the tool, the arguments (normalized, with the keys sorted) and the rendered environment.
+//
+// Returns:
+//   - The key
+//   - An error if the arguments cannot be serialized
+func resultCacheKey(tool string, params map[string]interface{}, env []string) (string, error) {
+	args, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, part := range append([]string{tool, string(args)}, env...) {
+		hash.Write([]byte(strconv.Itoa(len(part)) + ":" + part))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// noCacheRequested removes the reserved argument for bypassing the cache from the
+// arguments, returning true if the client has set it
+func noCacheRequested(params map[string]interface{}) bool {
+	value, exists := params[common.NoCacheArgument]
+	if !exists {
+		return false
+	}
+	delete(params, common.NoCacheArgument)
+
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		noCache, _ := strconv.ParseBool(v)
+		return noCache
+	default:
+		return false
+	}
+}
@@ -7,13 +7,20 @@ package command
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 
+	"github.com/inercia/MCPShell/pkg/audit"
 	"github.com/inercia/MCPShell/pkg/common"
 	"github.com/inercia/MCPShell/pkg/config"
 	"github.com/inercia/go-restricted-runner/pkg/runner"
@@ -22,17 +29,33 @@ import (
 // CommandHandler encapsulates the configuration and behavior needed to handle tool commands.
 type CommandHandler struct {
 	cmd                 string                        // the command to execute
+	argv                []string                      // ... or the arguments of the command to execute without a shell
 	output              common.OutputConfig           // the output configuration
+	outputProcessor     *common.OutputProcessor       // ... and the processor of the output
 	constraints         []string                      // the constraints to evaluate
 	constraintsCompiled *common.CompiledConstraints   // ... and the compiled versions
 	params              map[string]common.ParamConfig // the parameter configurations
+	validator           *common.ParamValidator        // the declarative validations of the parameters
 	envVars             []string                      // the environment variables passed to the command
-	timeout             string                        // the timeout for command execution (e.g., "30s", "5m")
+	paramsEnv           map[string]string             // the environment variables where arguments are exported (by param name)
+	timeout             time.Duration                 // the timeout for command execution (zero for no timeout)
+	killAfter           time.Duration                 // the time before killing the command after the timeout (or a cancellation)
+	successCodes        []int                         // the exit codes that are not failures
 	shell               string                        // the shell to use
 	toolName            string                        // the name of the tool
-	runnerType          string                        // the type of runner to use
+	runnerType          runner.Type                   // the type of runner to use
 	runnerOpts          runner.Options                // the options for the runner
+	runnerMu            sync.Mutex                    // protects the runner
+	runner              processRunner                 // the runner, created on the first call (nil until then)
+	templateFuncs       []string                      // the restricted template functions allowed
+	limiters            []*ConcurrencyLimiter         // the concurrency limits for running the command
+	lock                string                        // the template of the name of the lock held while the command runs
+	lockTimeout         time.Duration                 // the maximum time waiting for the lock
+	locks               *LockManager                  // the manager of the locks
+	usageLimiters       []*UsageLimiter               // the rate limits and quotas of the calls
+	cache               *resultCache                  // the cache of the results (nil when disabled)
 
+	audit  *audit.Logger // the audit log (optional)
 	logger *common.Logger
 }
 
@@ -72,12 +95,62 @@ func NewCommandHandler(tool config.Tool, params map[string]common.ParamConfig, s
 		logger.Debug("Successfully compiled constraints for tool '%s'", tool.MCPTool.Name)
 	}
 
+	// Compile the declarative validations (enum, pattern, etc) of the parameters
+	validator, err := common.NewParamValidator(params)
+	if err != nil {
+		logger.Error("Failed to compile parameter validations for tool %s: %v", tool.MCPTool.Name, err)
+		return nil, fmt.Errorf("parameter validation error: %w", err)
+	}
+
+	// Compile the output processing pipeline (ie, filters)
+	outputProcessor, err := common.NewOutputProcessor(tool.Config.Output)
+	if err != nil {
+		logger.Error("Invalid output configuration for tool %s: %v", tool.MCPTool.Name, err)
+		return nil, fmt.Errorf("output configuration error: %w", err)
+	}
+
+	// Parse the timeouts of the command
+	timeout, killAfter, err := tool.Config.Run.GetTimeouts()
+	if err != nil {
+		logger.Error("Invalid timeout for tool %s: %v", tool.MCPTool.Name, err)
+		return nil, fmt.Errorf("timeout configuration error: %w", err)
+	}
+	if killAfter == 0 {
+		killAfter = killGracePeriod
+	}
+
+	// Parse the time waiting for the lock of the command
+	lockTimeout, err := tool.Config.Run.GetLockTimeout()
+	if err != nil {
+		logger.Error("Invalid lock configuration for tool %s: %v", tool.MCPTool.Name, err)
+		return nil, fmt.Errorf("lock configuration error: %w", err)
+	}
+
+	// Cache the results of the tool when configured
+	var cache *resultCache
+	if tool.Config.Run.Cache != nil {
+		ttl, err := tool.Config.Run.Cache.GetTTL()
+		if err != nil {
+			logger.Error("Invalid cache configuration for tool %s: %v", tool.MCPTool.Name, err)
+			return nil, fmt.Errorf("cache configuration error: %w", err)
+		}
+		if _, exists := params[common.NoCacheArgument]; exists {
+			logger.Error("Invalid parameters for tool %s: parameter name '%s' is reserved", tool.MCPTool.Name, common.NoCacheArgument)
+			return nil, fmt.Errorf("parameter name '%s' is reserved in tools with a cache", common.NoCacheArgument)
+		}
+		cache = newResultCache(ttl)
+	}
+
 	// Get the effective command, runner type, and options from the tool
 	effectiveCommand := tool.GetEffectiveCommand()
 	effectiveRunnerType := tool.GetEffectiveRunner()
 	effectiveOptions := tool.GetEffectiveOptions()
 
-	logger.Debug("Using command: %s", effectiveCommand)
+	if len(tool.Config.Run.Argv) > 0 {
+		logger.Debug("Using argv: %q", tool.Config.Run.Argv)
+	} else {
+		logger.Debug("Using command: %s", effectiveCommand)
+	}
 	logger.Debug("Using runner type: %s", effectiveRunnerType)
 
 	// Convert the runner options to runner.Options
@@ -89,23 +162,104 @@ func NewCommandHandler(tool config.Tool, params map[string]common.ParamConfig, s
 		logger.Debug("Runner options for tool '%s': %v", tool.MCPTool.Name, runnerOpts)
 	}
 
+	// Determine which runner to use based on the configuration
+	runnerType := runner.TypeExec // default runner
+	switch effectiveRunnerType {
+	case "", string(runner.TypeExec):
+	case string(runner.TypeSandboxExec):
+		runnerType = runner.TypeSandboxExec
+	case string(runner.TypeFirejail):
+		runnerType = runner.TypeFirejail
+	case string(runner.TypeDocker):
+		runnerType = runner.TypeDocker
+	default:
+		logger.Error("Unknown runner type '%s', falling back to default runner", effectiveRunnerType)
+	}
+
+	// Only a zero exit code is a success, unless configured otherwise
+	successCodes := tool.Config.Run.SuccessCodes
+	if len(successCodes) == 0 {
+		successCodes = []int{0}
+	}
+
 	// Create and return the handler
 	return &CommandHandler{
 		cmd:                 effectiveCommand,
+		argv:                tool.Config.Run.Argv,
 		output:              tool.Config.Output,
+		outputProcessor:     outputProcessor,
 		constraints:         tool.Config.Constraints,
 		params:              params,
+		validator:           validator,
 		constraintsCompiled: compiled,
 		envVars:             tool.Config.Run.Env,
-		timeout:             tool.Config.Run.Timeout,
+		paramsEnv:           tool.Config.GetParamsEnv(),
+		timeout:             timeout,
+		killAfter:           killAfter,
+		successCodes:        successCodes,
 		shell:               shell,
 		toolName:            tool.MCPTool.Name,
-		runnerType:          effectiveRunnerType,
+		runnerType:          runnerType,
 		runnerOpts:          runnerOpts,
+		lock:                tool.Config.Run.Lock,
+		lockTimeout:         lockTimeout,
+		locks:               NewLockManager(logger),
+		cache:               cache,
 		logger:              logger,
 	}, nil
 }
 
+// SetAuditLogger sets the audit log where all the invocations of the tool are recorded.
+//
+// Parameters:
+//   - auditLogger: The audit log (nil disables auditing)
+func (h *CommandHandler) SetAuditLogger(auditLogger *audit.Logger) {
+	h.audit = auditLogger
+}
+
+// SetTemplateFunctions sets the restricted functions (ie, `env`) that are allowed
+// in the templates of the tool (the command, the environment and the output prefix).
+//
+// Parameters:
+//   - names: The names of the functions allowed
+func (h *CommandHandler) SetTemplateFunctions(names []string) {
+	h.templateFuncs = names
+}
+
+// SetConcurrencyLimiters sets the limiters of the number of commands running at the
+// same time (ie, the limiter of the tool and the limiter of the server). Slots are
+// acquired in the order given.
+//
+// Parameters:
+//   - limiters: The limiters (none for no limits)
+func (h *CommandHandler) SetConcurrencyLimiters(limiters ...*ConcurrencyLimiter) {
+	h.limiters = limiters
+}
+
+// SetLockManager sets the manager of the locks held while the commands run, so the
+// locks are shared with other tools. By default, the locks are only shared by the
+// calls of this tool.
+//
+// Parameters:
+//   - locks: The lock manager
+func (h *CommandHandler) SetLockManager(locks *LockManager) {
+	h.locks = locks
+}
+
+// SetUsageLimiters sets the rate limits and quotas of the calls (ie, the limiter of
+// the tool and the limiter of the server).
+//
+// Parameters:
+//   - limiters: The limiters (none for no limits)
+func (h *CommandHandler) SetUsageLimiters(limiters ...*UsageLimiter) {
+	h.usageLimiters = limiters
+}
+
+// processTemplate processes a template of the tool with the given arguments
+func (h *CommandHandler) processTemplate(text string, params map[string]interface{}) (string, error) {
+	return common.ProcessTemplateWithFuncs(text, params, h.templateFuncs)
+}
+
 // GetMCPHandler returns a function that handles MCP tool calls by executing shell commands.
 //
 // This is the function that should be registered with the MCP server.
@@ -129,25 +283,134 @@ func (h *CommandHandler) GetMCPHandler() func(ctx context.Context, request mcp.C
 		// settings) could lead to privilege escalation or arbitrary code execution.
 		// Runner options must be defined server-side in the tool configuration only.
 
-		// Apply timeout if configured
-		executionCtx := ctx
-		if h.timeout != "" {
-			timeout, err := time.ParseDuration(h.timeout)
-			if err != nil {
-				return mcp.NewToolResultError(fmt.Sprintf("invalid timeout format '%s': %v", h.timeout, err)), nil
-			}
-			var cancel context.CancelFunc
-			executionCtx, cancel = context.WithTimeout(ctx, timeout)
-			defer cancel()
+		// Stream the output while the command runs when the client wants progress notifications
+		if reporter := h.newMCPProgressReporter(ctx, request.Params.Meta); reporter != nil {
+			ctx = withProgress(ctx, reporter)
+			reporter.start()
+			defer reporter.stop()
 		}
 
 		// Execute the command using the common implementation
-		output, _, err := h.executeToolCommand(executionCtx, args)
+		result, _, err := h.executeToolCommand(ctx, args)
+
+		var exitErr *ExitCodeError
+		if errors.As(err, &exitErr) && h.output.OnError == common.OnErrorOutput {
+			// the failure is reported in the exit code, but the output is a normal result
+			err = nil
+		}
 		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
+			toolResult := mcp.NewToolResultError(err.Error())
+
+			// Report invalid arguments also in a machine-readable form, so clients can fix all of them at once
+			var argsErr *common.ArgumentsError
+			if errors.As(err, &argsErr) {
+				toolResult.StructuredContent = map[string]interface{}{"problems": argsErr.Problems}
+			}
+
+			// Attach the output of commands that failed (or the output produced until the timeout)
+			var timeoutErr *TimeoutError
+			var limitErr *LimitError
+			switch {
+			case exitErr != nil && result != nil:
+				toolResult.Content = append([]mcp.Content{mcp.NewTextContent(fmt.Sprintf("command failed with exit code %d", result.ExitCode))},
+					outputContents(result)...)
+				toolResult.Meta = resultMeta(result)
+			case errors.As(err, &timeoutErr) && result != nil:
+				toolResult.Content = outputContents(result)
+				toolResult.Meta = resultMeta(result)
+			case errors.As(err, &limitErr) && !limitErr.ResetAt.IsZero():
+				toolResult.Meta = mcp.NewMetaFromMap(map[string]any{"limit_resets_at": limitErr.ResetAt.UTC().Format(time.RFC3339)})
+			}
+			return toolResult, nil
+		}
+
+		toolResult := &mcp.CallToolResult{
+			Result:  mcp.Result{Meta: resultMeta(result)},
+			Content: outputContents(result),
+		}
+		if result.Structured != nil {
+			toolResult.StructuredContent = result.Structured
+		}
+		return toolResult, nil
+	}
+}
+
+// resultMeta returns the metadata of the result of a command: the exit code, whether
+// the command timed out and, when the output has been processed (ie, truncated), the
+// report of the changes.
+func resultMeta(result *commandResult) *mcp.Meta {
+	meta := map[string]any{"exit_code": result.ExitCode}
+	if result.TimedOut {
+		meta["timed_out"] = true
+	}
+	if result.Cached {
+		meta["cached"] = true
+		meta["cache_age_seconds"] = int(result.CacheAge.Seconds())
+	}
+	if result.Report != nil {
+		meta["output"] = result.Report
+	}
+	return mcp.NewMetaFromMap(meta)
+}
+
+// outputContents returns the content blocks for the output of a command: the stdout
+// and, when not empty, the stderr (marked as such, so they can be distinguished).
+func outputContents(result *commandResult) []mcp.Content {
+	var contents []mcp.Content
+	if result.Output != "" || result.Stderr == "" {
+		stdout := mcp.NewTextContent(result.Output)
+		stdout.Meta = mcp.NewMetaFromMap(map[string]any{"stream": "stdout"})
+		contents = append(contents, stdout)
+	}
+	if result.Stderr != "" {
+		stderr := mcp.NewTextContent("stderr:\n" + result.Stderr)
+		stderr.Meta = mcp.NewMetaFromMap(map[string]any{"stream": "stderr"})
+		contents = append(contents, stderr)
+	}
+	return contents
+}
+
+// GetMCPResourceHandler returns a function that handles MCP resource reads by executing
+// shell commands. The variables of the URI template (if any) are used as parameters.
+//
+// Parameters:
+//   - mimeType: The MIME type of the contents of the resource
+//
+// Returns:
+//   - A function that handles MCP resource reads
+func (h *CommandHandler) GetMCPResourceHandler(mimeType string) func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	return func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		// URI template variables are matched as lists of values
+		args := make(map[string]interface{}, len(request.Params.Arguments))
+		for name, value := range request.Params.Arguments {
+			if values, ok := value.([]string); ok {
+				if len(values) == 1 {
+					args[name] = values[0]
+				} else {
+					list := make([]interface{}, len(values))
+					for i, v := range values {
+						list[i] = v
+					}
+					args[name] = list
+				}
+				continue
+			}
+			args[name] = value
+		}
+
+		result, _, err := h.executeToolCommand(ctx, args)
+		var exitErr *ExitCodeError
+		if err != nil && (!errors.As(err, &exitErr) || h.output.OnError != common.OnErrorOutput) {
+			return nil, err
 		}
 
-		return mcp.NewToolResultText(output), nil
+		return []mcp.ResourceContents{
+			mcp.TextResourceContents{
+				URI:      request.Params.URI,
+				MIMEType: mimeType,
+				Text:     result.Output,
+			},
+		}, nil
 	}
 }
 
@@ -157,13 +420,15 @@ func (h *CommandHandler) GetMCPHandler() func(ctx context.Context, request mcp.C
 // * for assignments (ie, ENV_VAR=value), it uses the value directly
 // * for templated assignments (ie, EBV_VAR={{ .param }}), it processes the template with the given params
 //
+// The arguments exported as environment variables (see `params_as_env`) are added too.
+//
 // It returns all the env vars as a list of KEY=VALUE.
 func (h *CommandHandler) getEnvironmentVariables(params map[string]interface{}) []string {
-	if len(h.envVars) == 0 {
+	if len(h.envVars) == 0 && len(h.paramsEnv) == 0 {
 		return nil
 	}
 
-	envVars := make([]string, 0, len(h.envVars))
+	envVars := make([]string, 0, len(h.envVars)+len(h.paramsEnv))
 	for _, name := range h.envVars {
 		comps := strings.Split(name, "=")
 		if len(comps) == 1 {
@@ -173,7 +438,7 @@ func (h *CommandHandler) getEnvironmentVariables(params map[string]interface{})
 				envVars = append(envVars, name+"=")
 			}
 		} else {
-			p, err := common.ProcessTemplate(comps[1], params)
+			p, err := h.processTemplate(comps[1], params)
 			if err != nil {
 				envVars = append(envVars, name)
 			} else {
@@ -182,5 +447,38 @@ func (h *CommandHandler) getEnvironmentVariables(params map[string]interface{})
 		}
 	}
 
+	// Export the arguments (sorted, for a stable environment)
+	names := slices.Sorted(maps.Keys(h.paramsEnv))
+	for _, name := range names {
+		if value, exists := params[name]; exists {
+			envVars = append(envVars, h.paramsEnv[name]+"="+envValue(value))
+		}
+	}
+
 	return envVars
 }
+
+// envValue returns the value of an argument for an environment variable: strings,
+// numbers and booleans as they are, and arrays and objects as JSON.
+func envValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		out, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(out)
+	}
+}
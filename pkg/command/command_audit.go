@@ -0,0 +1,62 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/audit"
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// auditInvocation records an invocation of the tool in the audit log (when enabled),
+// completing the record with the identity of the client and the outcome of the execution.
+func (h *CommandHandler) auditInvocation(ctx context.Context, record audit.Record, start time.Time, result *commandResult, failedConstraints []string, err error) {
+	if h.audit == nil {
+		return
+	}
+
+	record.Time = start
+	record.Tool = h.toolName
+	if caller := common.CallerFromContext(ctx); caller != nil {
+		record.Client = caller.Name
+		record.ClientSubject = caller.Subject
+	}
+	record.Session = sessionID(ctx)
+	record.FailedConstraints = failedConstraints
+	record.DurationMs = time.Since(start).Milliseconds()
+	if result != nil {
+		record.OutputSize = len(result.Output)
+		exitCode := result.ExitCode
+		record.ExitCode = &exitCode
+	}
+
+	var argsErr *common.ArgumentsError
+	var busyErr *BusyError
+	var lockErr *LockError
+	var limitErr *LimitError
+	switch {
+	case err == nil:
+		record.Status = audit.StatusSuccess
+	case errors.As(err, &argsErr):
+		record.Status = audit.StatusInvalidArguments
+	case len(failedConstraints) > 0:
+		record.Status = audit.StatusBlocked
+	case errors.Is(err, ErrCancelled):
+		record.Status = audit.StatusCancelled
+	case errors.As(err, &busyErr), errors.As(err, &lockErr):
+		record.Status = audit.StatusBusy
+	case errors.As(err, &limitErr):
+		record.Status = audit.StatusLimited
+	default:
+		record.Status = audit.StatusError
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	if err := h.audit.Log(record); err != nil {
+		h.logger.Error("Failed to write the audit record for tool '%s': %v", h.toolName, err)
+	}
+}
@@ -3,15 +3,64 @@ package command
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/inercia/MCPShell/pkg/audit"
 	"github.com/inercia/MCPShell/pkg/common"
-	runnercommon "github.com/inercia/go-restricted-runner/pkg/common"
-	"github.com/inercia/go-restricted-runner/pkg/runner"
 )
 
+// commandResult is the result of executing a tool command
+type commandResult struct {
+	Output   string               // the stdout of the command (processed, and with the output prefix)
+	Stderr   string               // the stderr of the command
+	ExitCode int                  // the exit code of the command
+	Report   *common.OutputReport // the changes made by the output processing (nil when unmodified)
+	TimedOut bool                 // whether the command was terminated because of the timeout
+	Cached   bool                 // whether the result comes from the cache
+	CacheAge time.Duration        // ... and how long ago it was cached
+
+	// Structured is the structured content extracted from the JSON output (nil when not available)
+	Structured map[string]interface{}
+}
+
+// ErrCancelled is the error returned when the execution of a command is cancelled
+// (ie, by the client, or because the client has disconnected)
+var ErrCancelled = errors.New("command cancelled")
+
+// ExitCodeError is the error returned when a command exits with a code that is
+// not one of the success codes of the tool.
+type ExitCodeError struct {
+	ExitCode int    // the exit code of the command
+	Stderr   string // the stderr of the command
+}
+
+// Error returns the error message, with the stderr of the command
+func (e *ExitCodeError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("command failed with exit code %d", e.ExitCode)
+	}
+	return fmt.Sprintf("command failed with exit code %d: %s", e.ExitCode, e.Stderr)
+}
+
+// TimeoutError is the error returned when a command is terminated because it has
+// exceeded the timeout of the tool.
+type TimeoutError struct {
+	Timeout time.Duration // the timeout of the tool
+}
+
+// Error returns the error message
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("command timed out after %s", e.Timeout)
+}
+
 // executeToolCommand handles the core logic of executing a command with the given parameters.
 // This is a common implementation used by both direct execution and MCP handler.
 //
@@ -20,47 +69,69 @@ import (
 //   - params: Map of parameter names to their values
 //
 // Returns:
-//   - The command output as a string
+//   - The result of the command (also when it fails with an exit code that is not a success code)
 //   - A slice of failed constraint messages
-//   - An error if command execution fails
+//   - An error if command execution fails (an *ExitCodeError for exit codes that are not success codes)
 //
 // Security note: Runner options are only taken from the server-side tool configuration.
 // External callers (MCP clients, CLI users) cannot override runner options to prevent
 // privilege escalation attacks (e.g., specifying a different Docker image or user).
-func (h *CommandHandler) executeToolCommand(ctx context.Context, params map[string]interface{}) (string, []string, error) {
+func (h *CommandHandler) executeToolCommand(ctx context.Context, params map[string]interface{}) (result *commandResult, failedConstraints []string, err error) {
 	// Log the tool execution
-	h.logger.Debug("Tool execution requested for '%s'", h.toolName)
+	caller := common.CallerFromContext(ctx)
+	h.logger.Debug("Tool execution requested for '%s' by %s", h.toolName, caller)
 	h.logger.Debug("Arguments: %v", params)
 
-	// Apply default values for parameters that aren't provided but have defaults
+	// Record the invocation in the audit log, with the arguments received
+	start := time.Now()
+	record := audit.Record{Arguments: maps.Clone(params), Constraints: audit.ConstraintsNone}
+	defer func() {
+		h.auditInvocation(ctx, record, start, result, failedConstraints, err)
+	}()
+
+	// Work on a copy of the arguments, so the map of the caller is not modified
+	args := make(map[string]interface{}, len(params))
+	maps.Copy(args, params)
+	params = args
+
+	// The client can ask for running the command again instead of returning a cached result
+	noCache := false
+	if h.cache != nil {
+		noCache = noCacheRequested(params)
+	}
+
+	// Apply default values for parameters that aren't provided (or are null) but have defaults
 	for paramName, paramConfig := range h.params {
-		if _, exists := params[paramName]; !exists && paramConfig.Default != nil {
+		if params[paramName] == nil && paramConfig.Default != nil {
 			h.logger.Debug("Using default value for parameter '%s': %v", paramName, paramConfig.Default)
 			params[paramName] = paramConfig.Default
 		}
 	}
 
-	// Check for required parameters that weren't provided and don't have defaults
-	for paramName, paramConfig := range h.params {
-		if paramConfig.Required {
-			if _, exists := params[paramName]; !exists {
-				h.logger.Error("Required parameter missing: %s", paramName)
-				return "", nil, fmt.Errorf("required parameter missing: %s", paramName)
-			}
-		}
+	// Check the arguments against the parameters declaration: unknown parameters,
+	// types, required parameters and declarative validations, before the constraints
+	params, err = h.validator.ValidateArguments(params)
+	if err != nil {
+		h.logger.Info("Invalid arguments, blocking execution: %v", err)
+		return nil, nil, err
 	}
 
 	// Validate constraints before executing command
-	var failedConstraints []string
 	if h.constraintsCompiled != nil {
 		h.logger.Debug("Checking %d constraints", len(h.constraints))
-		satisfied, failed, err := h.constraintsCompiled.Evaluate(params, h.params)
+		// Constraints can also check the identity of the caller
+		constraintArgs := make(map[string]interface{}, len(params)+1)
+		maps.Copy(constraintArgs, params)
+		constraintArgs[common.CallerVariable] = caller.Variables()
+
+		satisfied, failed, err := h.constraintsCompiled.Evaluate(constraintArgs, h.params)
 		if err != nil {
 			h.logger.Error("Error evaluating constraints: %v", err)
-			return "", nil, fmt.Errorf("error evaluating constraints: %v", err)
+			return nil, nil, fmt.Errorf("error evaluating constraints: %v", err)
 		}
 		if !satisfied {
 			h.logger.Info("Constraints not satisfied, blocking execution")
+			record.Constraints = audit.ConstraintsFailed
 			failedConstraints = failed
 			errorMsg := "command execution blocked by constraints"
 
@@ -75,51 +146,31 @@ func (h *CommandHandler) executeToolCommand(ctx context.Context, params map[stri
 				}
 			}
 
-			return "", failedConstraints, fmt.Errorf("%s", errorMsg)
+			return nil, failedConstraints, fmt.Errorf("%s", errorMsg)
 		}
 		h.logger.Debug("All constraints satisfied")
+		record.Constraints = audit.ConstraintsPassed
 	}
 
-	// Process the command template with the tool arguments
+	// Process the command template (or the argv templates) with the tool arguments
 	// h.logger.Debug("Processing command template:\n%s", h.cmd)
 
-	cmd, err := common.ProcessTemplate(h.cmd, params)
-	if err != nil {
-		h.logger.Error("Error processing command template: %v", err)
-		return "", nil, fmt.Errorf("error processing command template: %v", err)
-	}
-
-	// Wrap command with timeout if configured and timeout command is available
-	if h.timeout != "" {
-		timeoutDuration, err := time.ParseDuration(h.timeout)
+	var cmd string
+	var argv []string
+	if len(h.argv) > 0 {
+		argv, err = h.renderArgv(params)
 		if err != nil {
-			h.logger.Error("Invalid timeout format '%s': %v", h.timeout, err)
-			return "", nil, fmt.Errorf("invalid timeout format '%s': %v", h.timeout, err)
+			return nil, nil, err
 		}
-
-		// Convert to seconds for the timeout command
-		timeoutSeconds := int(timeoutDuration.Seconds())
-		if timeoutSeconds < 1 {
-			timeoutSeconds = 1 // Minimum 1 second
-		}
-
-		// Escape single quotes in the command for shell
-		escapedCmd := strings.ReplaceAll(cmd, "'", "'\"'\"'")
-
-		// On Unix systems, try to use the 'timeout' command if available, otherwise use context-based timeout
-		// On Windows, always use context-based timeout as 'timeout' command doesn't limit execution time
-		if runner.ShouldUseUnixTimeoutCommand() {
-			// On Unix/Linux/macOS systems, use timeout command with Unix syntax
-			cmd = fmt.Sprintf("timeout --kill-after=5s %ds sh -c '%s'", timeoutSeconds, escapedCmd)
-			h.logger.Debug("Wrapped command with Unix timeout: %ds", timeoutSeconds)
-		} else {
-			// timeout command not available on this platform or this is Windows
-			// Fall back to context-based timeout (less reliable for child processes)
-			h.logger.Debug("Timeout command not available, using context-based timeout: %s", h.timeout)
+		cmd = shellJoin(argv)
+	} else {
+		cmd, err = h.processTemplate(h.cmd, params)
+		if err != nil {
+			h.logger.Error("Error processing command template: %v", err)
+			return nil, nil, fmt.Errorf("error processing command template: %v", err)
 		}
 	}
-
-	// h.logger.Debug("Processed command: %s", cmd)
+	record.Command = cmd
 
 	// Prepare environment variables
 	env := h.getEnvironmentVariables(params)
@@ -127,75 +178,189 @@ func (h *CommandHandler) executeToolCommand(ctx context.Context, params map[stri
 	h.logger.Debug("Executing command:")
 	h.logger.Debug("\n------------------------------------------------------\n%s\n------------------------------------------------------\n", cmd)
 
-	// Determine which runner to use based on the configuration
-	runnerType := runner.TypeExec // default runner
-	if h.runnerType != "" {
-		h.logger.Debug("Using configured runner type: %s", h.runnerType)
-		switch h.runnerType {
-		case string(runner.TypeExec):
-			runnerType = runner.TypeExec
-		case string(runner.TypeSandboxExec):
-			runnerType = runner.TypeSandboxExec
-		case string(runner.TypeFirejail):
-			runnerType = runner.TypeFirejail
-		case string(runner.TypeDocker):
-			runnerType = runner.TypeDocker
+	// Return the cached result of a previous call with the same arguments and environment
+	var cacheKey string
+	if h.cache != nil {
+		key, err := resultCacheKey(h.toolName, params, env)
+		switch {
+		case err != nil:
+			h.logger.Debug("Cannot cache the result of tool '%s': %v", h.toolName, err)
+		case noCache:
+			h.logger.Debug("Cache bypassed for tool '%s'", h.toolName)
+			cacheKey = key
 		default:
-			h.logger.Error("Unknown runner type '%s', falling back to default runner", h.runnerType)
+			if cached, ok := h.cache.get(key); ok {
+				h.logger.Info("Returning the result of tool '%s' cached %s ago", h.toolName, cached.CacheAge.Round(time.Millisecond))
+				record.Cached = true
+				return cached, nil, nil
+			}
+			cacheKey = key
 		}
 	}
 
-	// Use the configured runner options from the tool definition only
-	// (external callers cannot override these for security reasons)
-	runnerOptions := runner.Options{}
-	for k, v := range h.runnerOpts {
-		runnerOptions[k] = v
+	// Check the rate limits and the quotas (of the tool and of the server) before running the
+	// command. The calls are refunded when the command does not run (ie, when it is rejected
+	// by another limit, or by the lock).
+	var usage []*UsageReservation
+	ran := false
+	defer func() {
+		if !ran {
+			for _, reservation := range usage {
+				reservation.Cancel()
+			}
+		}
+	}()
+	session := sessionID(ctx)
+	for _, limiter := range h.usageLimiters {
+		reservation, err := limiter.Reserve(session)
+		if err != nil {
+			return nil, nil, err
+		}
+		usage = append(usage, reservation)
 	}
 
-	// Create the appropriate runner with options
-	h.logger.Debug("Creating runner of type %s and checking implicit requirements", runnerType)
+	record.Runner = string(h.runnerType)
 
-	// Create a runner-compatible logger
-	runnerLogger, err := runnercommon.NewLogger("", "", runnercommon.LogLevel(h.logger.Level()), false)
-	if err != nil {
-		h.logger.Error("Error creating runner logger: %v", err)
-		return "", nil, fmt.Errorf("error creating runner logger: %v", err)
+	// Wait for the lock of the command, so commands with the same lock do not run at the same time
+	if h.lock != "" {
+		lockName, err := h.processTemplate(h.lock, params)
+		if err != nil {
+			h.logger.Error("Error processing lock template: %v", err)
+			return nil, nil, fmt.Errorf("error processing lock template: %v", err)
+		}
+		if lockName = strings.TrimSpace(lockName); lockName != "" {
+			h.logger.Debug("Acquiring lock '%s'", lockName)
+			release, err := h.locks.Acquire(ctx, lockName, h.toolName, h.lockTimeout)
+			if err != nil {
+				return nil, nil, err
+			}
+			defer release()
+		}
 	}
 
-	r, err := runner.New(runnerType, runnerOptions, runnerLogger)
-	if err != nil {
-		h.logger.Error("Error creating runner: %v", err)
-		return "", nil, fmt.Errorf("error creating runner: %v", err)
+	// Wait for a slot when too many commands are running (in the tool or in the server)
+	for _, limiter := range h.limiters {
+		release, err := limiter.Acquire(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer release()
 	}
 
-	// Execute the command (timeout is handled by the context passed in from caller)
-	commandOutput, err := r.Run(ctx, h.shell, cmd, env, params, true)
+	// Execute the command (terminating it when the timeout is exceeded)
+	h.logger.Debug("Running command with runner of type %s", h.runnerType)
+	runStart := time.Now()
+	ran = true
+	processOut, err := h.runCommand(ctx, cmd, argv, env, params)
+	for _, reservation := range usage {
+		reservation.Commit(time.Since(runStart))
+	}
+	if errors.Is(err, context.Canceled) {
+		h.logger.Info("Execution of tool '%s' cancelled after %s", h.toolName, time.Since(start).Round(time.Millisecond))
+		return nil, nil, ErrCancelled
+	}
 	if err != nil {
 		h.logger.Error("Error executing command: %v", err)
-		return "", nil, err
+		return nil, nil, err
 	}
 
-	// Process the output
-	finalOutput := commandOutput
+	result = &commandResult{
+		ExitCode: processOut.ExitCode,
+		TimedOut: processOut.TimedOut,
+	}
+
+	// The stderr is returned separately, but it must not exceed the limits of the output either
+	stderr, stderrReport := h.outputProcessor.Limit(processOut.Stderr)
+	if stderrReport.Truncated {
+		h.logger.Debug("Stderr truncated: %d bytes (%d lines) returned from %d bytes (%d lines)",
+			stderrReport.Bytes, stderrReport.Lines, stderrReport.OriginalBytes, stderrReport.OriginalLines)
+	}
+	result.Stderr = stderr
+
+	// Extract the JSON output (when a schema or a selection has been provided),
+	// falling back to the text output when it cannot be extracted
+	var output string
+	var report common.OutputReport
+	extracted := false
+	if h.outputProcessor.ExtractsJSON() {
+		text, structured, extractReport, err := h.outputProcessor.ExtractJSON(processOut.Stdout)
+		if err != nil {
+			h.logger.Info("Could not extract the JSON output, returning it as text: %v", err)
+		} else {
+			// only the text is truncated, the structured content is returned complete
+			output, report = text, extractReport
+			result.Structured = structured
+			extracted = true
+		}
+	}
+
+	// Process the text output (ie, filters and truncation)
+	if !extracted {
+		output, report = h.outputProcessor.Process(processOut.Stdout)
+	}
+	result.Output = output
+	if report.Modified() {
+		h.logger.Debug("Output processed: %d bytes (%d lines) returned from %d bytes (%d lines)",
+			report.Bytes, report.Lines, report.OriginalBytes, report.OriginalLines)
+		result.Report = &report
+	}
 
 	// Apply prefix if provided
 	if h.output.Prefix != "" {
 		h.logger.Debug("Applying output prefix template: %s", h.output.Prefix)
 
 		// Process the prefix template with the tool arguments
-		prefix, err := common.ProcessTemplate(h.output.Prefix, params)
+		prefix, err := h.processTemplate(h.output.Prefix, params)
 		if err != nil {
 			h.logger.Error("Error processing output prefix template: %v", err)
-			return "", nil, fmt.Errorf("error processing output prefix template: %v", err)
+			return nil, nil, fmt.Errorf("error processing output prefix template: %v", err)
 		}
 
 		// Combine prefix and command output
-		finalOutput = strings.TrimSpace(prefix) + "\n\n" + finalOutput
-		h.logger.Debug("Final output with prefix:\n--------------------------------\n%s\n--------------------------------", finalOutput)
+		result.Output = strings.TrimSpace(prefix) + "\n\n" + result.Output
+		h.logger.Debug("Final output with prefix:\n--------------------------------\n%s\n--------------------------------", result.Output)
+	}
+
+	// Commands that time out are failures, but the output produced until then is returned
+	if result.TimedOut {
+		h.logger.Info("Tool '%s' timed out after %s", h.toolName, h.timeout)
+		result.Output = strings.TrimSpace(result.Output + fmt.Sprintf("\n... [timed out after %s] ...", h.timeout))
+		return result, nil, &TimeoutError{Timeout: h.timeout}
+	}
+
+	// Exit codes that are not success codes are failures, but the output is still returned
+	if !slices.Contains(h.successCodes, result.ExitCode) {
+		h.logger.Info("Command failed with exit code %d", result.ExitCode)
+		return result, nil, &ExitCodeError{ExitCode: result.ExitCode, Stderr: result.Stderr}
 	}
 
-	h.logger.Debug("Tool execution completed successfully")
-	return finalOutput, nil, nil
+	h.logger.Debug("Tool execution completed successfully (exit code %d)", result.ExitCode)
+	if cacheKey != "" {
+		h.cache.put(cacheKey, result)
+	}
+	return result, nil, nil
+}
+
+// renderArgv processes the argv templates with the tool arguments. Each element
+// is rendered separately, and elements that render to an empty string are removed
+// (ie, optional flags like `{{ if .all }}--all{{ end }}`).
+func (h *CommandHandler) renderArgv(params map[string]interface{}) ([]string, error) {
+	argv := make([]string, 0, len(h.argv))
+	for i, tmpl := range h.argv {
+		arg, err := h.processTemplate(tmpl, params)
+		if err != nil {
+			h.logger.Error("Error processing argv template #%d: %v", i, err)
+			return nil, fmt.Errorf("error processing argv template #%d: %v", i, err)
+		}
+		if arg == "" {
+			continue
+		}
+		argv = append(argv, arg)
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("empty argv after processing the templates")
+	}
+	return argv, nil
 }
 
 // ExecuteCommand handles the direct execution of a command without going through the MCP server.
@@ -213,26 +378,24 @@ func (h *CommandHandler) ExecuteCommand(params map[string]interface{}) (string,
 	// This prevents users from overriding security-sensitive settings like
 	// Docker image, user, or network configuration through command-line parameters.
 
-	// Create context with timeout for command execution
-	// Use configured timeout if available, otherwise use a default of 60 seconds
-	timeout := 60 * time.Second
-	if h.timeout != "" {
-		parsedTimeout, err := time.ParseDuration(h.timeout)
-		if err != nil {
-			return "", fmt.Errorf("invalid timeout format '%s': %v", h.timeout, err)
-		}
-		timeout = parsedTimeout
+	// Commands are terminated when they exceed the configured timeout. When there is
+	// no timeout, use a default of 60 seconds
+	ctx := context.Background()
+	if h.timeout == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 60*time.Second)
+		defer cancel()
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
 
-	// Use the common implementation
-	output, failedConstraints, err := h.executeToolCommand(ctx, params)
+	// Commands run in their own process group, so they must be terminated on Ctrl-C
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// If constraints failed, format the error message
-	if err != nil && len(failedConstraints) > 0 {
+	// Use the common implementation
+	result, _, err := h.executeToolCommand(ctx, params)
+	if err != nil {
 		return "", err
 	}
 
-	return output, err
+	return result.Output, nil
 }
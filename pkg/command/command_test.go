@@ -2,13 +2,24 @@ package command
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 
+	"github.com/inercia/MCPShell/pkg/audit"
 	"github.com/inercia/MCPShell/pkg/common"
 	"github.com/inercia/MCPShell/pkg/config"
+	"github.com/inercia/go-restricted-runner/pkg/runner"
 )
 
 // Create a test logger that discards output to keep test output clean
@@ -151,6 +162,62 @@ func TestCommandHandler(t *testing.T) {
 			wantError: true,
 			errorMsg:  "constraint compilation error",
 		},
+		{
+			name:        "Declarative validation - passed",
+			cmdTemplate: "echo 'Hello, {{ .name }}'",
+			output:      common.OutputConfig{},
+			paramTypes: map[string]common.ParamConfig{
+				"name": {Type: "string", Description: "User name", Enum: []interface{}{"Alice", "Bob"}},
+			},
+			args:      map[string]interface{}{"name": "Alice"},
+			wantError: false,
+		},
+		{
+			name:        "Declarative validation - failed",
+			cmdTemplate: "echo 'Hello, {{ .name }}'",
+			output:      common.OutputConfig{},
+			constraints: []string{"name.size() <= 100"},
+			paramTypes: map[string]common.ParamConfig{
+				"name": {Type: "string", Description: "User name", Pattern: "^[A-Za-z]+$"},
+			},
+			args:      map[string]interface{}{"name": "Alice; rm -rf /"},
+			wantError: true,
+			errorMsg:  "parameter 'name': value \"Alice; rm -rf /\" does not match the pattern",
+		},
+		{
+			name:        "Unknown parameter",
+			cmdTemplate: "echo 'Hello, {{ .name }}'",
+			output:      common.OutputConfig{},
+			paramTypes: map[string]common.ParamConfig{
+				"name": {Type: "string", Description: "User name"},
+			},
+			args:      map[string]interface{}{"name": "Alice", "user": "root"},
+			wantError: true,
+			errorMsg:  "parameter 'user': unknown parameter",
+		},
+		{
+			name:        "Coerced integer with constraint - passed",
+			cmdTemplate: "echo 'Scaling to {{ .replicas }}'",
+			output:      common.OutputConfig{},
+			constraints: []string{"replicas % 2 == 0"},
+			paramTypes: map[string]common.ParamConfig{
+				"replicas": {Type: "integer", Description: "Replicas"},
+			},
+			args:      map[string]interface{}{"replicas": "4"},
+			wantError: false,
+		},
+		{
+			name:        "Mistyped integer",
+			cmdTemplate: "echo 'Scaling to {{ .replicas }}'",
+			output:      common.OutputConfig{},
+			paramTypes: map[string]common.ParamConfig{
+				"replicas": {Type: "integer", Description: "Replicas", Required: true},
+				"name":     {Type: "string", Description: "Name", Required: true},
+			},
+			args:      map[string]interface{}{"replicas": 2.5},
+			wantError: true,
+			errorMsg:  "invalid arguments:\n- parameter 'name': required parameter missing\n- parameter 'replicas': expected an integer, got number 2.5",
+		},
 		{
 			name:        "Output with prefix - passed constraint",
 			cmdTemplate: "echo 'World'",
@@ -365,3 +432,1306 @@ func TestCommandHandlerDefaults(t *testing.T) {
 		})
 	}
 }
+
+// TestCommandHandlerCollections tests that array and object parameters can be used in templates
+func TestCommandHandlerCollections(t *testing.T) {
+	params := map[string]common.ParamConfig{
+		"names": {
+			Type:        "array",
+			Description: "Names to greet",
+			Required:    true,
+		},
+		"labels": {
+			Type:        "object",
+			Description: "Labels",
+			Default:     map[string]interface{}{"app": "web"},
+		},
+	}
+
+	tool := config.Tool{
+		MCPTool: mcp.Tool{
+			Name: "test-tool",
+		},
+		Config: config.MCPToolConfig{
+			Name:        "test-tool",
+			Description: "Test tool",
+			Constraints: []string{"names.all(n, n.matches('^[a-z]+$'))"},
+			Run: config.MCPToolRunConfig{
+				Command: `echo "{{ range $i, $n := .names }}{{ if $i }},{{ end }}hello-{{ $n }}{{ end }} app={{ .labels.app }}"`,
+			},
+		},
+	}
+
+	handler, err := NewCommandHandler(tool, params, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+
+	output, err := handler.ExecuteCommand(map[string]interface{}{
+		"names": []interface{}{"alice", "bob"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.TrimSpace(output) != "hello-alice,hello-bob app=web" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+
+	_, err = handler.ExecuteCommand(map[string]interface{}{
+		"names": []interface{}{"alice", "bob; rm -rf /"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "command execution blocked by constraints") {
+		t.Errorf("Expected constraint failure, got %v", err)
+	}
+}
+
+func TestCommandHandlerAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLogger, err := audit.NewLogger(audit.Config{Path: path, HashChain: true})
+	if err != nil {
+		t.Fatalf("Failed to create audit logger: %v", err)
+	}
+
+	tool := config.Tool{
+		MCPTool: mcp.Tool{Name: "greet"},
+		Config: config.MCPToolConfig{
+			Name:        "greet",
+			Constraints: []string{"name != 'root'"},
+			Run:         config.MCPToolRunConfig{Command: "echo hello {{ .name }}"},
+		},
+	}
+	params := map[string]common.ParamConfig{"name": {Type: "string", Required: true}}
+
+	handler, err := NewCommandHandler(tool, params, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+	handler.SetAuditLogger(auditLogger)
+
+	ctx := common.WithCaller(context.Background(), &common.Caller{Name: "ci-agent"})
+	invocations := []map[string]interface{}{
+		{"name": "alice"},
+		{"name": "root"},
+		{"other": "value"},
+	}
+	for _, args := range invocations {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		if _, err := handler.GetMCPHandler()(ctx, request); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	_ = auditLogger.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(invocations) {
+		t.Fatalf("Expected %d audit records, got %d", len(invocations), len(lines))
+	}
+
+	want := []struct {
+		status      string
+		constraints string
+		command     string
+	}{
+		{audit.StatusSuccess, audit.ConstraintsPassed, "echo hello alice"},
+		{audit.StatusBlocked, audit.ConstraintsFailed, ""},
+		{audit.StatusInvalidArguments, audit.ConstraintsNone, ""},
+	}
+	for i, line := range lines {
+		var record audit.Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Failed to parse audit record: %v", err)
+		}
+		if record.Tool != "greet" || record.Client != "ci-agent" || record.Status != want[i].status ||
+			record.Constraints != want[i].constraints || record.Command != want[i].command {
+			t.Errorf("Record %d = %+v, want %+v", i, record, want[i])
+		}
+	}
+
+	if count, err := audit.Verify(path); err != nil || count != len(invocations) {
+		t.Errorf("Verify() = %d, %v", count, err)
+	}
+}
+
+func TestCommandHandlerExitCodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		command      string
+		successCodes []int
+		onError      string
+		wantIsError  bool
+		wantExitCode int
+		wantTexts    []string
+	}{
+		{
+			name:         "success",
+			command:      "echo out",
+			wantExitCode: 0,
+			wantTexts:    []string{"out"},
+		},
+		{
+			name:         "success with stderr",
+			command:      "echo out; echo warning >&2",
+			wantExitCode: 0,
+			wantTexts:    []string{"out", "stderr:\nwarning"},
+		},
+		{
+			name:         "failure",
+			command:      "echo out; echo err >&2; exit 2",
+			wantIsError:  true,
+			wantExitCode: 2,
+			wantTexts:    []string{"command failed with exit code 2", "out", "stderr:\nerr"},
+		},
+		{
+			name:         "failure without output",
+			command:      "exit 3",
+			wantIsError:  true,
+			wantExitCode: 3,
+			wantTexts:    []string{"command failed with exit code 3", ""},
+		},
+		{
+			name:         "exit code in success codes",
+			command:      "echo nothing found >&2; exit 1",
+			successCodes: []int{0, 1},
+			wantExitCode: 1,
+			wantTexts:    []string{"stderr:\nnothing found"},
+		},
+		{
+			name:         "failure with output policy",
+			command:      "echo out; echo err >&2; exit 2",
+			onError:      common.OnErrorOutput,
+			wantExitCode: 2,
+			wantTexts:    []string{"out", "stderr:\nerr"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := config.Tool{
+				MCPTool: mcp.Tool{Name: "test-tool"},
+				Config: config.MCPToolConfig{
+					Name:   "test-tool",
+					Run:    config.MCPToolRunConfig{Command: tt.command, SuccessCodes: tt.successCodes},
+					Output: common.OutputConfig{OnError: tt.onError},
+				},
+			}
+			handler, err := NewCommandHandler(tool, nil, "sh", testLogger)
+			if err != nil {
+				t.Fatalf("Failed to create command handler: %v", err)
+			}
+
+			result, err := handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.IsError != tt.wantIsError {
+				t.Errorf("IsError = %v, want %v", result.IsError, tt.wantIsError)
+			}
+			if result.Meta == nil || result.Meta.AdditionalFields["exit_code"] != tt.wantExitCode {
+				t.Errorf("Expected exit code %d in _meta, got %+v", tt.wantExitCode, result.Meta)
+			}
+
+			var texts []string
+			for _, content := range result.Content {
+				if text, ok := content.(mcp.TextContent); ok {
+					texts = append(texts, text.Text)
+				}
+			}
+			if strings.Join(texts, "|") != strings.Join(tt.wantTexts, "|") {
+				t.Errorf("Contents = %q, want %q", texts, tt.wantTexts)
+			}
+		})
+	}
+}
+
+func TestCommandHandlerOutputProcessing(t *testing.T) {
+	tool := config.Tool{
+		MCPTool: mcp.Tool{Name: "test-tool"},
+		Config: config.MCPToolConfig{
+			Name: "test-tool",
+			Run:  config.MCPToolRunConfig{Command: "printf '\\033[32mok\\033[0m\\nline 2\\nline 3\\n'; printf '\\033[31mfailed\\033[0m\\nerror 2\\n' >&2"},
+			Output: common.OutputConfig{
+				StripANSI: true,
+				MaxLines:  1,
+			},
+		},
+	}
+	handler, err := NewCommandHandler(tool, nil, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+
+	result, err := handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Content) == 0 {
+		t.Fatalf("Expected some content in the result")
+	}
+	text, _ := result.Content[0].(mcp.TextContent)
+	if text.Text != "ok\n... [2 lines omitted] ..." {
+		t.Errorf("Unexpected output %q", text.Text)
+	}
+
+	// the stderr is returned with the same limits
+	if len(result.Content) != 2 {
+		t.Fatalf("Expected the stdout and the stderr in the result, got %+v", result.Content)
+	}
+	if stderr, _ := result.Content[1].(mcp.TextContent); stderr.Text != "stderr:\nfailed\n... [1 lines omitted] ..." {
+		t.Errorf("Unexpected stderr %q", stderr.Text)
+	}
+
+	report, ok := result.Meta.AdditionalFields["output"].(*common.OutputReport)
+	if !ok {
+		t.Fatalf("Expected an output report in _meta, got %+v", result.Meta)
+	}
+	if !report.Truncated || report.OriginalLines != 3 || report.Lines != 2 {
+		t.Errorf("Unexpected output report: %+v", report)
+	}
+
+	// invalid output configurations are rejected when creating the handler
+	tool.Config.Output = common.OutputConfig{Include: []string{"("}}
+	if _, err := NewCommandHandler(tool, nil, "sh", testLogger); err == nil {
+		t.Errorf("Expected error for an invalid output configuration")
+	}
+}
+
+func TestCommandHandlerStructuredOutput(t *testing.T) {
+	tests := []struct {
+		name           string
+		command        string
+		maxBytes       int
+		wantText       string
+		wantStructured bool
+	}{
+		{
+			name:           "JSON output",
+			command:        `echo '{"metadata": {"name": "web"}, "status": {"phase": "Running"}}'`,
+			wantText:       "{\n  \"phase\": \"Running\"\n}",
+			wantStructured: true,
+		},
+		{
+			name:           "JSON output truncated",
+			command:        `echo '{"metadata": {"name": "web"}, "status": {"phase": "Running"}}'`,
+			maxBytes:       10,
+			wantText:       "{\n  \"phase\n... [14 bytes omitted] ...",
+			wantStructured: true,
+		},
+		{
+			name:     "fall back to text",
+			command:  "echo 'pod not found'",
+			wantText: "pod not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := config.Tool{
+				MCPTool: mcp.Tool{Name: "test-tool"},
+				Config: config.MCPToolConfig{
+					Name: "test-tool",
+					Run:  config.MCPToolRunConfig{Command: tt.command},
+					Output: common.OutputConfig{
+						Select:   `{"phase": output.status.phase}`,
+						Schema:   map[string]interface{}{"type": "object"},
+						MaxBytes: tt.maxBytes,
+					},
+				},
+			}
+			handler, err := NewCommandHandler(tool, nil, "sh", testLogger)
+			if err != nil {
+				t.Fatalf("Failed to create command handler: %v", err)
+			}
+
+			result, err := handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.IsError {
+				t.Fatalf("Unexpected error result: %+v", result.Content)
+			}
+			if text, _ := result.Content[0].(mcp.TextContent); text.Text != tt.wantText {
+				t.Errorf("Expected text %q, got %q", tt.wantText, text.Text)
+			}
+
+			if _, truncated := result.Meta.AdditionalFields["output"]; truncated != (tt.maxBytes > 0) {
+				t.Errorf("Unexpected output report in _meta: %+v", result.Meta)
+			}
+
+			if !tt.wantStructured {
+				if result.StructuredContent != nil {
+					t.Errorf("Expected no structured content, got %v", result.StructuredContent)
+				}
+				return
+			}
+			structured, ok := result.StructuredContent.(map[string]interface{})
+			if !ok || structured["phase"] != "Running" {
+				t.Errorf("Unexpected structured content: %v", result.StructuredContent)
+			}
+		})
+	}
+}
+
+func TestCommandHandlerArgv(t *testing.T) {
+	tests := []struct {
+		name     string
+		argv     []string
+		args     map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "arguments are not interpreted by a shell",
+			argv:     []string{"printf", "%s", "{{ .text }}"},
+			args:     map[string]interface{}{"text": "$(echo injected); echo `id` > /dev/null *"},
+			expected: "$(echo injected); echo `id` > /dev/null *",
+		},
+		{
+			name:     "empty arguments are removed",
+			argv:     []string{"echo", "{{ if .text }}-n{{ end }}", "hello"},
+			args:     map[string]interface{}{},
+			expected: "hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]common.ParamConfig{"text": {Type: "string"}}
+			tool := config.Tool{
+				MCPTool: mcp.Tool{Name: "test-tool"},
+				Config: config.MCPToolConfig{
+					Name: "test-tool",
+					Run:  config.MCPToolRunConfig{Argv: tt.argv},
+				},
+			}
+			handler, err := NewCommandHandler(tool, params, "", testLogger)
+			if err != nil {
+				t.Fatalf("Failed to create command handler: %v", err)
+			}
+
+			output, err := handler.ExecuteCommand(tt.args)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("Expected output %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestCommandHandlerRunnerShell(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "shell of the tool",
+			options:  nil,
+			expected: "sh",
+		},
+		{
+			name:     "shell option of the exec runner",
+			options:  map[string]interface{}{"shell": "bash"},
+			expected: "bash",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execRunner := config.MCPToolRunner{Name: "exec", Options: tt.options}
+			tool := config.Tool{
+				MCPTool: mcp.Tool{Name: "test-tool"},
+				Config: config.MCPToolConfig{
+					Name: "test-tool",
+					Run: config.MCPToolRunConfig{
+						Command: `if [ -n "$BASH_VERSION" ]; then echo bash; else echo sh; fi`,
+						Runners: []config.MCPToolRunner{execRunner},
+					},
+				},
+				SelectedRunner: &execRunner,
+			}
+			handler, err := NewCommandHandler(tool, nil, "/bin/sh", testLogger)
+			if err != nil {
+				t.Fatalf("Failed to create command handler: %v", err)
+			}
+
+			// the runner is created once, and used in all the calls
+			for range 2 {
+				output, err := handler.ExecuteCommand(map[string]interface{}{})
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if output != tt.expected {
+					t.Errorf("Expected output %q, got %q", tt.expected, output)
+				}
+			}
+			if handler.runner == nil {
+				t.Error("Expected the runner to be kept in the handler")
+			}
+		})
+	}
+}
+
+func TestLibraryRunnerArgv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the runner library runs the commands with a Unix shell")
+	}
+
+	runnerLogger, err := newRunnerLogger(testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create runner logger: %v", err)
+	}
+	r := newLibraryRunner(runner.TypeExec, runner.Options{}, runnerLogger, "sh", common.ProcessTemplate, testLogger)
+
+	text := "it's $(echo injected); echo `id` > /dev/null *"
+	output, err := r.run(context.Background(), "", []string{"printf", "%s", text}, nil, nil, processOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output.Stdout != text {
+		t.Errorf("Expected output %q, got %q", text, output.Stdout)
+	}
+}
+
+func TestLibraryRunnerOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  runner.Options
+		args     map[string]interface{}
+		expected []string
+		wantErr  string
+	}{
+		{
+			name:     "templates rendered with the arguments",
+			options:  runner.Options{"allow_read_folders": []interface{}{"/tmp", "{{ .dir }}/logs"}},
+			args:     map[string]interface{}{"dir": "/var"},
+			expected: []string{"/tmp", "/var/logs"},
+		},
+		{
+			name:    "restricted template functions",
+			options: runner.Options{"allow_read_files": []interface{}{`{{ env "HOME" }}/.config`}},
+			wantErr: "error processing runner option 'allow_read_files'",
+		},
+		{
+			name:    "templates in the arguments",
+			options: runner.Options{"allow_write_folders": []string{"{{ .dir }}"}},
+			args:    map[string]interface{}{"dir": `{{ env "SECRET" }}`},
+			wantErr: "cannot contain '{{'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			render := func(text string, params map[string]interface{}) (string, error) {
+				return common.ProcessTemplateWithFuncs(text, params, nil)
+			}
+			r := newLibraryRunner(runner.TypeFirejail, tt.options, nil, "sh", render, testLogger)
+
+			options, err := r.renderOptions(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(options["allow_read_folders"], tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, options["allow_read_folders"])
+			}
+			if _, ok := tt.options["allow_read_folders"].([]interface{}); !ok {
+				t.Errorf("Expected the options of the runner not to be modified, got %v", tt.options)
+			}
+		})
+	}
+}
+
+func TestCommandHandlerParamsAsEnv(t *testing.T) {
+	params := map[string]common.ParamConfig{
+		"text":     {Type: "string"},
+		"count":    {Type: "integer"},
+		"names":    {Type: "array"},
+		"selector": {Type: "string", Env: "SELECTOR"},
+	}
+	tool := config.Tool{
+		MCPTool: mcp.Tool{Name: "test-tool"},
+		Config: config.MCPToolConfig{
+			Name:   "test-tool",
+			Params: params,
+			Run: config.MCPToolRunConfig{
+				Command:     `printf '%s|%s|%s|%s' "$MCP_PARAM_text" "$MCP_PARAM_count" "$MCP_PARAM_names" "$SELECTOR"`,
+				ParamsAsEnv: true,
+			},
+		},
+	}
+	handler, err := NewCommandHandler(tool, params, "", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+
+	output, err := handler.ExecuteCommand(map[string]interface{}{
+		"text":     "it's $(id); *",
+		"count":    3,
+		"names":    []interface{}{"a", "b"},
+		"selector": "app=web",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `it's $(id); *|3|["a","b"]|app=web`
+	if output != expected {
+		t.Errorf("Expected output %q, got %q", expected, output)
+	}
+}
+
+func TestCommandHandlerProgress(t *testing.T) {
+	tool := config.Tool{
+		MCPTool: mcp.Tool{Name: "test-tool"},
+		Config: config.MCPToolConfig{
+			Name:   "test-tool",
+			Run:    config.MCPToolRunConfig{Command: "printf 'step 1\\ndebug\\nstep 2\\nstep 3'"},
+			Output: common.OutputConfig{Exclude: []string{"debug"}, MaxLines: 1},
+		},
+	}
+	handler, err := NewCommandHandler(tool, nil, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+
+	var messages []string
+	var progress []float64
+	reporter := newProgressReporter(func(p float64, message string) error {
+		progress = append(progress, p)
+		messages = append(messages, message)
+		return nil
+	}, handler.outputProcessor.FilterLine, testLogger)
+	reporter.interval = time.Hour // only the final notification
+
+	reporter.start()
+	result, _, err := handler.executeToolCommand(withProgress(context.Background(), reporter), map[string]interface{}{})
+	reporter.stop()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// notifications have the filtered lines, and the result the processed output
+	if len(messages) != 1 || messages[0] != "step 1\nstep 2\nstep 3" || progress[0] != 3 {
+		t.Errorf("Unexpected notifications %q (progress %v)", messages, progress)
+	}
+	if result.Output != "step 1\n... [2 lines omitted] ..." {
+		t.Errorf("Unexpected output %q", result.Output)
+	}
+
+	// without a progress token, no reporter is created
+	if handler.newMCPProgressReporter(context.Background(), nil) != nil {
+		t.Errorf("Expected no progress reporter without a progress token")
+	}
+}
+
+func TestProgressReporterLimits(t *testing.T) {
+	var messages []string
+	reporter := newProgressReporter(func(_ float64, message string) error {
+		messages = append(messages, message)
+		return nil
+	}, nil, testLogger)
+	reporter.maxNotifications = 2
+	reporter.maxMessageBytes = 10
+
+	// lines are split across writes, and batches are cut to the maximum size
+	for _, chunks := range [][]string{{"aaaa\nbb", "bb\ncccc\n"}, {"dddd\n"}, {"eeee\n"}} {
+		for _, chunk := range chunks {
+			if _, err := reporter.Write([]byte(chunk)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		reporter.flush()
+	}
+	reporter.stop()
+
+	expected := []string{
+		"... [1 lines omitted] ...\nbbbb\ncccc",
+		"dddd\n... [no more progress notifications, the full output will be in the result] ...",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %q, got %q", expected, messages)
+	}
+}
+
+func TestCommandHandlerCancellation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are not supported on Windows")
+	}
+
+	// the command starts a process in the background that must be terminated too
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	tool := config.Tool{
+		MCPTool: mcp.Tool{Name: "test-tool"},
+		Config: config.MCPToolConfig{
+			Name: "test-tool",
+			Run:  config.MCPToolRunConfig{Command: "sleep 30 & echo $! > " + pidFile + "; wait"},
+		},
+	}
+	handler, err := NewCommandHandler(tool, nil, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			if _, err := os.Stat(pidFile); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	result, err := handler.GetMCPHandler()(ctx, mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > killGracePeriod {
+		t.Errorf("Expected the command to be terminated on cancellation, it took %s", elapsed)
+	}
+	if !result.IsError || len(result.Content) == 0 {
+		t.Fatalf("Expected an error result, got %+v", result)
+	}
+	if text, _ := result.Content[0].(mcp.TextContent); text.Text != ErrCancelled.Error() {
+		t.Errorf("Expected %q, got %q", ErrCancelled.Error(), text.Text)
+	}
+
+	// the background process must not be running anymore
+	pid, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("Failed to read the pid file: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := exec.Command("kill", "-0", strings.TrimSpace(string(pid))).Run(); err == nil {
+		t.Errorf("Expected process %s to be terminated", strings.TrimSpace(string(pid)))
+	}
+}
+
+func TestCommandHandlerTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are not supported on Windows")
+	}
+
+	tests := []struct {
+		name      string
+		command   string
+		killAfter string
+		wantText  string
+	}{
+		{
+			name:     "terminated",
+			command:  "echo started; sleep 30; echo finished",
+			wantText: "started\n... [timed out after 500ms] ...",
+		},
+		{
+			name:      "killed when it ignores SIGTERM",
+			command:   "trap '' TERM; echo started; while true; do sleep 0.1; done",
+			killAfter: "500ms",
+			wantText:  "started\n... [timed out after 500ms] ...",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := config.Tool{
+				MCPTool: mcp.Tool{Name: "test-tool"},
+				Config: config.MCPToolConfig{
+					Name: "test-tool",
+					Run:  config.MCPToolRunConfig{Command: tt.command, Timeout: "500ms", KillAfter: tt.killAfter},
+				},
+			}
+			handler, err := NewCommandHandler(tool, nil, "sh", testLogger)
+			if err != nil {
+				t.Fatalf("Failed to create command handler: %v", err)
+			}
+
+			start := time.Now()
+			result, err := handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > handler.timeout+handler.killAfter+time.Second {
+				t.Errorf("Expected the command to be terminated after the timeout, it took %s", elapsed)
+			}
+
+			// the output produced until the timeout is returned
+			if !result.IsError || len(result.Content) == 0 {
+				t.Fatalf("Expected an error result, got %+v", result)
+			}
+			if text, _ := result.Content[0].(mcp.TextContent); text.Text != tt.wantText {
+				t.Errorf("Expected %q, got %q", tt.wantText, text.Text)
+			}
+			if result.Meta == nil || result.Meta.AdditionalFields["timed_out"] != true {
+				t.Errorf("Expected timed_out in _meta, got %+v", result.Meta)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxQueued    int
+		queueTimeout time.Duration
+		cancel       bool
+		wantErr      string
+	}{
+		{
+			name:         "queue full",
+			maxQueued:    0,
+			queueTimeout: time.Minute,
+			wantErr:      "server busy: too many commands in the server (1 running and 0 calls waiting), try again later",
+		},
+		{
+			name:         "no waiting",
+			maxQueued:    10,
+			queueTimeout: 0,
+			wantErr:      "server busy: too many commands in the server (1 running and 0 calls waiting), try again later",
+		},
+		{
+			name:         "queue timeout",
+			maxQueued:    10,
+			queueTimeout: 100 * time.Millisecond,
+			wantErr:      "server busy: too many commands in the server (waited 100ms for a running command to finish), try again later",
+		},
+		{
+			name:         "cancelled while waiting",
+			maxQueued:    10,
+			queueTimeout: time.Minute,
+			cancel:       true,
+			wantErr:      ErrCancelled.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewConcurrencyLimiter("in the server", 1, tt.maxQueued, tt.queueTimeout, testLogger)
+			release, err := limiter.Acquire(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+			if _, err := limiter.Acquire(ctx); err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Expected error %q, got %v", tt.wantErr, err)
+			}
+
+			// the slot can be used again once it is released
+			release()
+			release, err = limiter.Acquire(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected error after releasing the slot: %v", err)
+			}
+			release()
+		})
+	}
+}
+
+func TestCommandHandlerConcurrencyLimits(t *testing.T) {
+	// the first call holds the only slot until the file is created
+	dir := t.TempDir()
+	started, finish := filepath.Join(dir, "started"), filepath.Join(dir, "finish")
+	tool := config.Tool{
+		MCPTool: mcp.Tool{Name: "test-tool"},
+		Config: config.MCPToolConfig{
+			Name: "test-tool",
+			Run:  config.MCPToolRunConfig{Command: "touch " + started + "; while [ ! -f " + finish + " ]; do sleep 0.05; done; echo done"},
+		},
+	}
+	handler, err := NewCommandHandler(tool, nil, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+	handler.SetConcurrencyLimiters(NewConcurrencyLimiter("of tool 'test-tool'", 1, 1, 0, testLogger))
+
+	type callResult struct {
+		result *mcp.CallToolResult
+		err    error
+	}
+	first := make(chan callResult, 1)
+	go func() {
+		result, err := handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+		first <- callResult{result, err}
+	}()
+	for {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the second call is rejected without waiting
+	result, err := handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.IsError || len(result.Content) == 0 {
+		t.Fatalf("Expected an error result, got %+v", result)
+	}
+	if text, _ := result.Content[0].(mcp.TextContent); !strings.HasPrefix(text.Text, "server busy: too many commands of tool 'test-tool'") {
+		t.Errorf("Expected a busy error, got %q", text.Text)
+	}
+
+	if err := os.WriteFile(finish, nil, 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	res := <-first
+	if res.err != nil || res.result.IsError {
+		t.Fatalf("Expected the first call to succeed, got %+v (%v)", res.result, res.err)
+	}
+}
+
+func TestLockManager(t *testing.T) {
+	locks := NewLockManager(testLogger)
+	ctx := common.WithCaller(context.Background(), &common.Caller{Name: "ci-agent"})
+	release, err := locks.Acquire(ctx, "terraform-prod", "terraform_apply", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// other locks are independent
+	releaseOther, err := locks.Acquire(context.Background(), "terraform-dev", "terraform_plan", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error for another lock: %v", err)
+	}
+	releaseOther()
+
+	// the callers giving up are told who holds the lock
+	for _, timeout := range []time.Duration{0, 100 * time.Millisecond} {
+		_, err := locks.Acquire(context.Background(), "terraform-prod", "terraform_plan", timeout)
+		var lockErr *LockError
+		if !errors.As(err, &lockErr) {
+			t.Fatalf("Expected a LockError with timeout %s, got %v", timeout, err)
+		}
+		if lockErr.Holder != "terraform_apply" || lockErr.Caller != "ci-agent" || lockErr.Waited != timeout {
+			t.Errorf("Unexpected lock error: %+v", lockErr)
+		}
+		if !strings.Contains(err.Error(), "lock 'terraform-prod' is held by 'terraform_apply' (called by ci-agent") {
+			t.Errorf("Unexpected error message: %v", err)
+		}
+	}
+
+	// calls waiting can be cancelled
+	cancelled, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := locks.Acquire(cancelled, "terraform-prod", "terraform_plan", time.Minute); !errors.Is(err, ErrCancelled) {
+		t.Errorf("Expected %v, got %v", ErrCancelled, err)
+	}
+
+	// calls waiting get the lock when it is released
+	time.AfterFunc(100*time.Millisecond, release)
+	release, err = locks.Acquire(context.Background(), "terraform-prod", "terraform_plan", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected the lock after it was released, got %v", err)
+	}
+	release()
+
+	if len(locks.locks) != 0 {
+		t.Errorf("Expected no locks after releasing them, got %v", locks.locks)
+	}
+}
+
+func TestCommandHandlerLock(t *testing.T) {
+	// the first tool holds the lock of the workspace until the file is created
+	dir := t.TempDir()
+	started, finish := filepath.Join(dir, "started"), filepath.Join(dir, "finish")
+	params := map[string]common.ParamConfig{"workspace": {Type: "string"}}
+	newHandler := func(name, command string) *CommandHandler {
+		tool := config.Tool{
+			MCPTool: mcp.Tool{Name: name},
+			Config: config.MCPToolConfig{
+				Name: name,
+				Run: config.MCPToolRunConfig{
+					Command:     command,
+					Lock:        "terraform-{{ .workspace }}",
+					LockTimeout: "0s",
+				},
+			},
+		}
+		handler, err := NewCommandHandler(tool, params, "sh", testLogger)
+		if err != nil {
+			t.Fatalf("Failed to create command handler: %v", err)
+		}
+		return handler
+	}
+	apply := newHandler("terraform_apply", "touch "+started+"; while [ ! -f "+finish+" ]; do sleep 0.05; done; echo applied")
+	plan := newHandler("terraform_plan", "echo planned")
+	locks := NewLockManager(testLogger)
+	apply.SetLockManager(locks)
+	plan.SetLockManager(locks)
+
+	call := func(handler *CommandHandler, workspace string) *mcp.CallToolResult {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = map[string]interface{}{"workspace": workspace}
+		result, err := handler.GetMCPHandler()(context.Background(), request)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return result
+	}
+	resultText := func(result *mcp.CallToolResult) string {
+		if len(result.Content) == 0 {
+			return ""
+		}
+		text, _ := result.Content[0].(mcp.TextContent)
+		return text.Text
+	}
+
+	applied := make(chan *mcp.CallToolResult, 1)
+	go func() { applied <- call(apply, "prod") }()
+	for {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the same workspace is locked, but other workspaces are not
+	result := call(plan, "prod")
+	if !result.IsError || !strings.HasPrefix(resultText(result), "lock 'terraform-prod' is held by 'terraform_apply'") {
+		t.Errorf("Expected a lock error, got %q", resultText(result))
+	}
+	if result := call(plan, "dev"); result.IsError || resultText(result) != "planned" {
+		t.Errorf("Expected the plan of another workspace to run, got %q", resultText(result))
+	}
+
+	if err := os.WriteFile(finish, nil, 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if result := <-applied; result.IsError || resultText(result) != "applied" {
+		t.Fatalf("Expected the apply to succeed, got %q", resultText(result))
+	}
+	if result := call(plan, "prod"); result.IsError || resultText(result) != "planned" {
+		t.Errorf("Expected the plan to run after the lock is released, got %q", resultText(result))
+	}
+}
+
+func TestUsageLimiter(t *testing.T) {
+	start := time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)
+	type call struct {
+		at      time.Duration // time since the start
+		session string
+		runtime time.Duration
+		cancel  bool   // the command does not run, so the call is refunded
+		wantErr string // empty for calls allowed
+	}
+	tests := []struct {
+		name      string
+		rateLimit string
+		quota     config.MCPQuotaConfig
+		calls     []call
+	}{
+		{
+			name:      "rate limit",
+			rateLimit: "2/min",
+			calls: []call{
+				{at: 0, session: "a"},
+				{at: 0, session: "a"},
+				{at: 10 * time.Second, session: "a", wantErr: "rate limit of tool 'x' (2/min) exceeded: the limit resets at 2026-01-01T23:00:30Z (in 20s)"},
+				{at: 10 * time.Second, session: "b"}, // each session has its own limit
+				{at: 30 * time.Second, session: "a"},
+			},
+		},
+		{
+			name:      "calls cancelled are refunded",
+			rateLimit: "1/min",
+			quota:     config.MCPQuotaConfig{MaxCalls: 1},
+			calls: []call{
+				{at: 0, session: "a", cancel: true},
+				{at: 0, session: "a"},
+				{at: 0, session: "b", cancel: true},
+				{at: time.Minute, session: "b"},
+				{at: 2 * time.Minute, session: "b", wantErr: "quota of tool 'x' (1 calls per session) exceeded: the limit resets with a new session"},
+			},
+		},
+		{
+			name:  "calls per session",
+			quota: config.MCPQuotaConfig{MaxCalls: 1},
+			calls: []call{
+				{at: 0, session: "a"},
+				{at: time.Hour, session: "a", wantErr: "quota of tool 'x' (1 calls per session) exceeded: the limit resets with a new session"},
+				{at: time.Hour, session: "b"},
+			},
+		},
+		{
+			name:  "runtime per day",
+			quota: config.MCPQuotaConfig{MaxRuntime: "1m", Per: config.QuotaPerDay},
+			calls: []call{
+				{at: 0, session: "a", runtime: 40 * time.Second},
+				{at: time.Minute, session: "b", runtime: 40 * time.Second},
+				{at: 2 * time.Minute, session: "c", wantErr: "quota of tool 'x' (1m0s of runtime per day) exceeded: the limit resets at 2026-01-02T00:00:00Z (in 58m0s)"},
+				{at: time.Hour, session: "c"}, // the next day
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := NewUsageLimiter("tool 'x'", tt.rateLimit, tt.quota, testLogger)
+			if err != nil {
+				t.Fatalf("Failed to create limiter: %v", err)
+			}
+			for i, c := range tt.calls {
+				limiter.now = func() time.Time { return start.Add(c.at) }
+				reservation, err := limiter.Reserve(c.session)
+				if c.wantErr == "" {
+					if err != nil {
+						t.Fatalf("Call %d: unexpected error: %v", i, err)
+					}
+					if c.cancel {
+						reservation.Cancel()
+					} else {
+						reservation.Commit(c.runtime)
+					}
+					continue
+				}
+				if err == nil || err.Error() != c.wantErr {
+					t.Errorf("Call %d: expected error %q, got %v", i, c.wantErr, err)
+				}
+			}
+		})
+	}
+
+	// sessions closed are forgotten
+	limiter, err := NewUsageLimiter("tool 'x'", "1/hour", config.MCPQuotaConfig{MaxCalls: 1}, testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+	if _, err := limiter.Reserve("a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	limiter.Forget("a")
+	if len(limiter.buckets) != 0 || len(limiter.usage) != 0 {
+		t.Errorf("Expected the session to be forgotten, got %v and %v", limiter.buckets, limiter.usage)
+	}
+}
+
+func TestCommandHandlerUsageLimits(t *testing.T) {
+	tool := config.Tool{
+		MCPTool: mcp.Tool{Name: "test-tool"},
+		Config: config.MCPToolConfig{
+			Name: "test-tool",
+			Run:  config.MCPToolRunConfig{Command: "echo hello", RateLimit: "1/hour"},
+		},
+	}
+	handler, err := NewCommandHandler(tool, nil, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+	limiter, err := NewUsageLimiter("tool 'test-tool'", tool.Config.Run.RateLimit, tool.Config.Run.Quota, testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+	handler.SetUsageLimiters(limiter)
+
+	result, err := handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+	if err != nil || result.IsError {
+		t.Fatalf("Expected the first call to succeed, got %+v (%v)", result, err)
+	}
+
+	// the second call is rejected, telling when the limit resets
+	result, err = handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.IsError || len(result.Content) == 0 {
+		t.Fatalf("Expected an error result, got %+v", result)
+	}
+	if text, _ := result.Content[0].(mcp.TextContent); !strings.HasPrefix(text.Text, "rate limit of tool 'test-tool' (1/hour) exceeded: the limit resets at ") {
+		t.Errorf("Expected a rate limit error, got %q", text.Text)
+	}
+	if result.Meta == nil || result.Meta.AdditionalFields["limit_resets_at"] == nil {
+		t.Errorf("Expected limit_resets_at in _meta, got %+v", result.Meta)
+	}
+
+	// calls rejected by the server limit (or by a lock) are not counted in the quota of the tool
+	toolLimiter, err := NewUsageLimiter("tool 'test-tool'", "", config.MCPQuotaConfig{MaxCalls: 1}, testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+	serverLimiter, err := NewUsageLimiter("the server", "1/hour", config.MCPQuotaConfig{}, testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+	if _, err := serverLimiter.Reserve(""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	handler.SetUsageLimiters(toolLimiter, serverLimiter)
+
+	result, err = handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if text, _ := result.Content[0].(mcp.TextContent); !result.IsError || !strings.HasPrefix(text.Text, "rate limit of the server (1/hour) exceeded") {
+		t.Fatalf("Expected a rate limit error of the server, got %+v", result)
+	}
+	if usage := toolLimiter.usage[""]; usage == nil || usage.calls != 0 {
+		t.Errorf("Expected the call not to be counted in the quota of the tool, got %+v", usage)
+	}
+
+	locks := NewLockManager(testLogger)
+	release, err := locks.Acquire(context.Background(), "deploy", "other-tool", 0)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	defer release()
+	handler.lock, handler.lockTimeout = "deploy", 0
+	handler.SetLockManager(locks)
+	handler.SetUsageLimiters(toolLimiter)
+
+	result, err = handler.GetMCPHandler()(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if text, _ := result.Content[0].(mcp.TextContent); !result.IsError || !strings.HasPrefix(text.Text, "lock 'deploy' is held by 'other-tool'") {
+		t.Fatalf("Expected a lock error, got %+v", result)
+	}
+	if usage := toolLimiter.usage[""]; usage.calls != 0 {
+		t.Errorf("Expected the call not to be counted in the quota of the tool, got %+v", usage)
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	start := time.Now()
+	cache := newResultCache(30 * time.Second)
+	cache.maxEntries = 2
+	cache.now = func() time.Time { return start }
+
+	// the keys do not depend on the order of the arguments, but on the environment
+	key1, err := resultCacheKey("get_pods", map[string]interface{}{"namespace": "default", "all": true}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	key2, _ := resultCacheKey("get_pods", map[string]interface{}{"all": true, "namespace": "default"}, nil)
+	key3, _ := resultCacheKey("get_pods", map[string]interface{}{"all": true, "namespace": "default"}, []string{"KUBECONFIG=/tmp/other"})
+	key4, _ := resultCacheKey("get_nodes", map[string]interface{}{"all": true, "namespace": "default"}, nil)
+	if key1 != key2 {
+		t.Error("Expected the same key for the same arguments")
+	}
+	if key1 == key3 || key1 == key4 {
+		t.Error("Expected different keys for different environments or tools")
+	}
+
+	cache.put(key1, &commandResult{Output: "pods"})
+	cache.now = func() time.Time { return start.Add(10 * time.Second) }
+	result, ok := cache.get(key1)
+	if !ok || result.Output != "pods" || !result.Cached || result.CacheAge != 10*time.Second {
+		t.Errorf("Expected the cached result, got %+v", result)
+	}
+
+	// the oldest result is removed when the cache is full
+	cache.put(key3, &commandResult{Output: "other pods"})
+	cache.put(key4, &commandResult{Output: "nodes"})
+	if _, ok := cache.get(key1); ok {
+		t.Error("Expected the oldest result to be removed")
+	}
+	if _, ok := cache.get(key3); !ok {
+		t.Error("Expected the result to be cached")
+	}
+
+	// results expire after the TTL
+	cache.now = func() time.Time { return start.Add(40 * time.Second) }
+	if _, ok := cache.get(key3); ok {
+		t.Error("Expected the result to expire")
+	}
+}
+
+func TestCommandHandlerCache(t *testing.T) {
+	// the command returns the number of times it has been run
+	counter := filepath.Join(t.TempDir(), "counter")
+	tool := config.Tool{
+		MCPTool: mcp.Tool{Name: "test-tool"},
+		Config: config.MCPToolConfig{
+			Name: "test-tool",
+			Run: config.MCPToolRunConfig{
+				Command: "echo {{ .name }} >> " + counter + "; wc -l < " + counter + " | tr -d ' '",
+				Cache:   &config.MCPCacheConfig{TTL: "1m"},
+			},
+		},
+	}
+	params := map[string]common.ParamConfig{"name": {Type: "string"}}
+	handler, err := NewCommandHandler(tool, params, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		args       map[string]interface{}
+		wantText   string
+		wantCached bool
+	}{
+		{name: "first call", args: map[string]interface{}{"name": "a"}, wantText: "1"},
+		{name: "same arguments", args: map[string]interface{}{"name": "a"}, wantText: "1", wantCached: true},
+		{name: "other arguments", args: map[string]interface{}{"name": "b"}, wantText: "2"},
+		{name: "cache bypassed", args: map[string]interface{}{"name": "a", "_nocache": true}, wantText: "3"},
+		{name: "refreshed result", args: map[string]interface{}{"name": "a"}, wantText: "3", wantCached: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := maps.Clone(tt.args)
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+			result, err := handler.GetMCPHandler()(context.Background(), request)
+			if err != nil || result.IsError || len(result.Content) == 0 {
+				t.Fatalf("Expected a successful result, got %+v (%v)", result, err)
+			}
+			if text, _ := result.Content[0].(mcp.TextContent); text.Text != tt.wantText {
+				t.Errorf("Expected %q, got %q", tt.wantText, text.Text)
+			}
+			cached := result.Meta != nil && result.Meta.AdditionalFields["cached"] == true
+			if cached != tt.wantCached {
+				t.Errorf("Expected cached=%v, got _meta %+v", tt.wantCached, result.Meta)
+			}
+			if cached && result.Meta.AdditionalFields["cache_age_seconds"] == nil {
+				t.Errorf("Expected the age of the cached result in _meta, got %+v", result.Meta)
+			}
+			if !reflect.DeepEqual(tt.args, args) {
+				t.Errorf("Expected the arguments of the request not to be modified, got %v", tt.args)
+			}
+		})
+	}
+
+	// the argument is not reserved in tools without cache
+	tool.Config.Run.Cache = nil
+	handler, err = NewCommandHandler(tool, params, "sh", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create command handler: %v", err)
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"name": "a", "_nocache": true}
+	result, err := handler.GetMCPHandler()(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if text, _ := result.Content[0].(mcp.TextContent); !result.IsError || !strings.Contains(text.Text, "parameter '_nocache': unknown parameter") {
+		t.Errorf("Expected an unknown parameter error, got %+v", result)
+	}
+
+	// ... so tools without cache can have a parameter with that name, but not cached tools
+	params["_nocache"] = common.ParamConfig{Type: "boolean"}
+	if _, err := NewCommandHandler(tool, params, "sh", testLogger); err != nil {
+		t.Errorf("Unexpected error for the parameter in a tool without cache: %v", err)
+	}
+	tool.Config.Run.Cache = &config.MCPCacheConfig{TTL: "1m"}
+	if _, err := NewCommandHandler(tool, params, "sh", testLogger); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("Expected an error for the reserved parameter name in a cached tool, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// BusyError is the error returned when a command cannot be run because of the
+// concurrency limits: too many calls are waiting, or the call waited too long.
+type BusyError struct {
+	Scope  string // what is limited (ie, "in the server", or "of tool 'x'")
+	Reason string // why the call was rejected
+}
+
+// Error returns the error message
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("server busy: too many commands %s (%s), try again later", e.Scope, e.Reason)
+}
+
+// ConcurrencyLimiter limits the number of commands running at the same time. Calls
+// wait in a bounded queue when the limit is reached, for a limited time.
+type ConcurrencyLimiter struct {
+	scope        string
+	slots        chan struct{}
+	maxQueued    int
+	queueTimeout time.Duration
+	logger       *common.Logger
+
+	mu     sync.Mutex
+	queued int // the calls waiting for a slot
+}
+
+// NewConcurrencyLimiter creates a limiter.
+//
+// Parameters:
+//   - scope: What is limited, for messages (ie, "in the server", or "of tool 'x'")
+//   - maxConcurrent: The maximum number of commands running at the same time (must be positive)
+//   - maxQueued: The maximum number of calls waiting for a slot
+//   - queueTimeout: The maximum time a call waits for a slot (zero for not waiting)
+//   - logger: Logger for reporting the queue depth
+//
+// Returns:
+//   - A new ConcurrencyLimiter
+func NewConcurrencyLimiter(scope string, maxConcurrent, maxQueued int, queueTimeout time.Duration, logger *common.Logger) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		scope:        scope,
+		slots:        make(chan struct{}, maxConcurrent),
+		maxQueued:    maxQueued,
+		queueTimeout: queueTimeout,
+		logger:       logger,
+	}
+}
+
+// Matches returns true if the limiter has the given limits (ie, for reusing it
+// when the configuration is reloaded)
+func (l *ConcurrencyLimiter) Matches(maxConcurrent, maxQueued int, queueTimeout time.Duration) bool {
+	return l != nil && cap(l.slots) == maxConcurrent && l.maxQueued == maxQueued && l.queueTimeout == queueTimeout
+}
+
+// Acquire waits for a slot for running a command.
+//
+// Parameters:
+//   - ctx: The context of the call (the wait stops when it is cancelled)
+//
+// Returns:
+//   - A function for releasing the slot, that must be called when the command finishes
+//   - A *BusyError if the queue is full or the call waited too long, or ErrCancelled
+//     if the context is cancelled while waiting
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	release := func() { <-l.slots }
+
+	// fast path: a slot is available
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queueTimeout == 0 || l.queued >= l.maxQueued {
+		queued := l.queued
+		l.mu.Unlock()
+		l.logger.Info("Too many commands %s, rejecting call: %d running, %d waiting", l.scope, len(l.slots), queued)
+		return nil, &BusyError{Scope: l.scope, Reason: fmt.Sprintf("%d running and %d calls waiting", len(l.slots), queued)}
+	}
+	l.queued++
+	l.logger.Info("Too many commands %s, call queued: %d running, %d waiting", l.scope, len(l.slots), l.queued)
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		l.logger.Info("Too many commands %s, call rejected after waiting %s", l.scope, l.queueTimeout)
+		return nil, &BusyError{Scope: l.scope, Reason: fmt.Sprintf("waited %s for a running command to finish", l.queueTimeout)}
+	case <-ctx.Done():
+		return nil, ErrCancelled
+	}
+}
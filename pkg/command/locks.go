@@ -0,0 +1,142 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// LockError is the error returned when a command cannot be run because its lock
+// is held by another command, and the call gave up waiting for it.
+type LockError struct {
+	Lock   string        // the name of the lock
+	Holder string        // the tool (or resource) holding the lock
+	Caller string        // the caller of the tool holding the lock
+	Held   time.Duration // how long the lock has been held
+	Waited time.Duration // how long the call waited for the lock
+}
+
+// Error returns the error message
+func (e *LockError) Error() string {
+	msg := fmt.Sprintf("lock '%s' is held by '%s' (called by %s, running for %s)",
+		e.Lock, e.Holder, e.Caller, e.Held.Round(100*time.Millisecond))
+	if e.Waited > 0 {
+		msg += fmt.Sprintf(", gave up after waiting %s", e.Waited)
+	}
+	return msg + ", try again later"
+}
+
+// namedLock is a lock of the LockManager
+type namedLock struct {
+	held   chan struct{} // holds a value while the lock is held
+	refs   int           // the calls holding or waiting for the lock
+	holder string        // the tool holding the lock
+	caller string        // ... and its caller
+	since  time.Time     // ... and since when
+}
+
+// LockManager holds named locks, so commands with the same lock (also of different
+// tools) never run at the same time. Locks are only held in this process.
+type LockManager struct {
+	mu     sync.Mutex
+	locks  map[string]*namedLock
+	logger *common.Logger
+}
+
+// NewLockManager creates a lock manager.
+//
+// Parameters:
+//   - logger: Logger for reporting the calls waiting for locks
+//
+// Returns:
+//   - A new LockManager
+func NewLockManager(logger *common.Logger) *LockManager {
+	return &LockManager{
+		locks:  map[string]*namedLock{},
+		logger: logger,
+	}
+}
+
+// Acquire waits for a lock.
+//
+// Parameters:
+//   - ctx: The context of the call (the wait stops when it is cancelled)
+//   - name: The name of the lock
+//   - holder: The tool (or resource) acquiring the lock, for reporting it to other callers
+//   - timeout: The maximum time waiting for the lock (zero for not waiting)
+//
+// Returns:
+//   - A function for releasing the lock, that must be called when the command finishes
+//   - A *LockError if the lock is still held after the timeout, or ErrCancelled if the
+//     context is cancelled while waiting
+func (m *LockManager) Acquire(ctx context.Context, name, holder string, timeout time.Duration) (func(), error) {
+	m.mu.Lock()
+	lock, exists := m.locks[name]
+	if !exists {
+		lock = &namedLock{held: make(chan struct{}, 1)}
+		m.locks[name] = lock
+	}
+	lock.refs++
+	m.mu.Unlock()
+
+	// forget the lock when nobody holds it or waits for it
+	unref := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(m.locks, name)
+		}
+	}
+
+	acquired := func() func() {
+		m.mu.Lock()
+		lock.holder, lock.caller, lock.since = holder, common.CallerFromContext(ctx).String(), time.Now()
+		m.mu.Unlock()
+		return func() {
+			<-lock.held
+			unref()
+		}
+	}
+
+	// lockError returns the error for a call giving up, with the current holder
+	lockError := func(waited time.Duration) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		err := &LockError{Lock: name, Holder: lock.holder, Caller: lock.caller, Held: time.Since(lock.since), Waited: waited}
+		m.logger.Info("Lock '%s' is held by '%s' (called by %s) for %s, rejecting call of '%s'",
+			name, lock.holder, lock.caller, err.Held.Round(time.Millisecond), holder)
+		return err
+	}
+
+	select {
+	case lock.held <- struct{}{}:
+		return acquired(), nil
+	default:
+	}
+
+	if timeout == 0 {
+		err := lockError(0)
+		unref()
+		return nil, err
+	}
+
+	m.logger.Info("Waiting for lock '%s' for '%s'", name, holder)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case lock.held <- struct{}{}:
+		return acquired(), nil
+	case <-timer.C:
+		err := lockError(timeout)
+		unref()
+		return nil, err
+	case <-ctx.Done():
+		unref()
+		return nil, ErrCancelled
+	}
+}
@@ -0,0 +1,232 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
+	runnercommon "github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// killGracePeriod is the default time given to processes for exiting after SIGTERM (when
+// their execution is cancelled or they time out), before killing them with SIGKILL
+const killGracePeriod = 5 * time.Second
+
+// processOutput is the output of a command: its stdout, stderr and exit code
+type processOutput struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool // the command was terminated because of the timeout (the output is partial)
+}
+
+// processOptions are the options for running a process
+type processOptions struct {
+	timeout   time.Duration // the process is terminated after this duration (zero for no timeout)
+	killAfter time.Duration // the time between SIGTERM and SIGKILL when terminating the process
+	onCancel  func()        // called when the process is terminated, before signaling it (optional)
+}
+
+// processOptions returns the options for running the processes of the tool
+func (h *CommandHandler) processOptions(onCancel func()) processOptions {
+	return processOptions{timeout: h.timeout, killAfter: h.killAfter, onCancel: onCancel}
+}
+
+// runCommand runs the (already rendered) command with the runner of the tool.
+//
+// The exec and docker runners capture the stdout, stderr and exit code of the command
+// separately. Other runners are run with the runner library, where stderr is only
+// available when the command fails (and stdout when it succeeds).
+//
+// When argv is provided, the command is executed from that list of arguments, without
+// a shell (or, for the runners that need one, with all the arguments quoted).
+//
+// Commands that exceed the timeout of the tool are terminated, returning the output
+// produced so far marked as TimedOut. With the runner library, only the stderr is
+// available for the commands that fail (including those that time out).
+//
+// Non-zero exit codes are not errors: errors are only returned when the command cannot
+// be started or it is interrupted (ie, cancelled).
+func (h *CommandHandler) runCommand(ctx context.Context, cmd string, argv []string, env []string, params map[string]interface{}) (*processOutput, error) {
+	r, err := h.getRunner()
+	if err != nil {
+		return nil, err
+	}
+	return r.run(ctx, cmd, argv, env, params, h.processOptions(nil))
+}
+
+// getRunner returns the runner of the tool, creating it on the first call (the runner
+// is not kept when it cannot be created, so the next calls try again: ie, when the
+// Docker daemon was not running)
+func (h *CommandHandler) getRunner() (processRunner, error) {
+	h.runnerMu.Lock()
+	defer h.runnerMu.Unlock()
+
+	if h.runner == nil {
+		r, err := newProcessRunner(h.runnerType, h.runnerOpts, h.shell, h.processTemplate, h.logger)
+		if err != nil {
+			h.logger.Error("Error creating runner: %v", err)
+			return nil, fmt.Errorf("error creating runner: %v", err)
+		}
+		h.runner = r
+	}
+	return h.runner, nil
+}
+
+// durationArg returns a duration as an argument for the timeout command (ie, "1.5s")
+func durationArg(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// newRunnerLogger creates a logger for the runner library, with the same level as the logger
+func newRunnerLogger(logger *common.Logger) (*runnercommon.Logger, error) {
+	runnerLogger, err := runnercommon.NewLogger("", "", runnercommon.LogLevel(logger.Level()), false)
+	if err != nil {
+		logger.Error("Error creating runner logger: %v", err)
+		return nil, fmt.Errorf("error creating runner logger: %v", err)
+	}
+	return runnerLogger, nil
+}
+
+// containerName returns a random name for a container
+func containerName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate the container name: %w", err)
+	}
+	return "mcpshell-" + hex.EncodeToString(b), nil
+}
+
+// runProcess runs a process, capturing its stdout and stderr separately (and streaming
+// its stdout to the progress reporter of the context, if any).
+//
+// The process runs in its own process group: when the context is cancelled or the
+// timeout is exceeded, onCancel is called (if provided) and all the processes in the
+// group are terminated. On timeouts, the output produced so far is returned.
+func runProcess(ctx context.Context, name string, args []string, env []string, opts processOptions) (*processOutput, error) {
+	// the process is terminated when this context is cancelled
+	procCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	execCmd := exec.CommandContext(procCtx, name, args...)
+	if len(env) > 0 {
+		execCmd.Env = append(os.Environ(), env...)
+	}
+
+	killAfter := opts.killAfter
+	if killAfter == 0 {
+		killAfter = killGracePeriod
+	}
+	setProcessGroup(execCmd)
+	execCmd.Cancel = func() error {
+		if opts.onCancel != nil {
+			opts.onCancel()
+		}
+		return terminateProcessGroup(execCmd.Process, killAfter)
+	}
+	// do not wait forever for processes that keep the output open
+	execCmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout = &stdout
+	execCmd.Stderr = &stderr
+
+	// stream the lines of stdout when the client wants progress notifications
+	if reporter := progressFromContext(ctx); reporter != nil {
+		execCmd.Stdout = io.MultiWriter(&stdout, reporter)
+	}
+
+	if err := execCmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run command: %w", err)
+	}
+
+	var timedOut atomic.Bool
+	if opts.timeout > 0 {
+		timer := time.AfterFunc(opts.timeout, func() {
+			timedOut.Store(true)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+
+	err := execCmd.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := &processOutput{
+		Stdout:   strings.TrimSpace(stdout.String()),
+		Stderr:   strings.TrimSpace(stderr.String()),
+		TimedOut: timedOut.Load(),
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			result.ExitCode = exitErr.ExitCode() // -1 when killed by a signal
+		case !result.TimedOut:
+			return nil, fmt.Errorf("failed to run command: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// getShell returns the shell for running commands: the configured one, or the
+// SHELL environment variable, or /bin/sh.
+func getShell(shell string) string {
+	if shell != "" {
+		return shell
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+// shellQuote quotes a string for the shell, with single quotes
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// quoteArgs returns a list of arguments as a shell command, quoting all of them
+func quoteArgs(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellJoin returns a list of arguments as a shell command (ie, for logs),
+// quoting only the arguments that need it
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg == "" || strings.ContainsFunc(arg, needsQuoting) {
+			arg = shellQuote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// needsQuoting returns true for the characters that must be quoted in the shell
+func needsQuoting(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	default:
+		return !strings.ContainsRune("-_./:=,@%+", r)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package command
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup runs the command in a new process group, so all the processes
+// started by the command can be terminated together
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateProcessGroup sends SIGTERM to the process group of the process and, if some
+// process is still running after the grace period, SIGKILL.
+func terminateProcessGroup(p *os.Process, grace time.Duration) error {
+	pgid := p.Pid
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		return p.Kill()
+	}
+
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		// no process left in the group
+		if err := syscall.Kill(-pgid, 0); err != nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package command
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// setProcessGroup does nothing on Windows, where processes are killed individually
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills the process (Windows has no SIGTERM, so there is no grace period)
+func terminateProcessGroup(p *os.Process, grace time.Duration) error {
+	return p.Kill()
+}
@@ -0,0 +1,221 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// Limits of the progress notifications sent while a command runs
+const (
+	progressInterval         = time.Second // minimum interval between notifications
+	progressMaxNotifications = 100         // maximum number of notifications for a command
+	progressMaxMessageBytes  = 4096        // maximum size of the message of a notification
+)
+
+// progressSender sends a progress notification, with the number of lines of output
+// so far and the new lines
+type progressSender func(progress float64, message string) error
+
+// progressReporter streams the lines of the output of a command as progress
+// notifications while the command runs. Lines are sent in batches, at most once
+// every interval and up to maxNotifications, so noisy commands do not flood the client.
+//
+// It is an io.Writer, so it can receive the stdout of the command.
+type progressReporter struct {
+	send             progressSender
+	filter           func(line string) (string, bool) // the output filters (optional)
+	interval         time.Duration
+	maxNotifications int
+	maxMessageBytes  int
+	logger           *common.Logger
+
+	mu      sync.Mutex
+	partial []byte   // the last line, still incomplete
+	pending []string // the lines not sent yet
+	lines   int      // the lines of output so far
+	sent    int      // the notifications sent
+	failed  bool     // whether sending a notification failed
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// newProgressReporter creates a reporter that sends the notifications with the given function.
+func newProgressReporter(send progressSender, filter func(string) (string, bool), logger *common.Logger) *progressReporter {
+	return &progressReporter{
+		send:             send,
+		filter:           filter,
+		interval:         progressInterval,
+		maxNotifications: progressMaxNotifications,
+		maxMessageBytes:  progressMaxMessageBytes,
+		logger:           logger,
+	}
+}
+
+// newMCPProgressReporter creates a reporter for a tool call, when the client has
+// requested progress notifications (with a progress token). It returns nil otherwise.
+func (h *CommandHandler) newMCPProgressReporter(ctx context.Context, meta *mcp.Meta) *progressReporter {
+	if meta == nil || meta.ProgressToken == nil {
+		return nil
+	}
+	srv := mcpserver.ServerFromContext(ctx)
+	if srv == nil {
+		return nil
+	}
+
+	token := meta.ProgressToken
+	send := func(progress float64, message string) error {
+		return srv.SendNotificationToClient(ctx, string(mcp.MethodNotificationProgress), map[string]any{
+			"progressToken": token,
+			"progress":      progress,
+			"message":       message,
+		})
+	}
+	return newProgressReporter(send, h.outputProcessor.FilterLine, h.logger)
+}
+
+// Write receives the output of the command, splitting it in lines
+func (r *progressReporter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		r.addLine(string(bytes.TrimSuffix(data[:i], []byte("\r"))))
+		data = data[i+1:]
+	}
+	r.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// addLine adds a complete line to the pending lines (the lock must be held)
+func (r *progressReporter) addLine(line string) {
+	if r.filter != nil {
+		var keep bool
+		if line, keep = r.filter(line); !keep {
+			return
+		}
+	}
+	r.lines++
+	if r.sent < r.maxNotifications {
+		r.pending = append(r.pending, line)
+	}
+}
+
+// start starts sending the notifications in the background, once every interval
+func (r *progressReporter) start() {
+	r.stopCh = make(chan struct{})
+	r.doneCh = make(chan struct{})
+
+	go func() {
+		defer close(r.doneCh)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.flush()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops sending notifications, sending the lines still pending. It must be
+// called before returning the result of the tool.
+func (r *progressReporter) stop() {
+	if r.stopCh != nil {
+		close(r.stopCh)
+		<-r.doneCh
+	}
+
+	r.mu.Lock()
+	if len(r.partial) > 0 {
+		r.addLine(string(r.partial))
+		r.partial = nil
+	}
+	r.mu.Unlock()
+
+	r.flush()
+}
+
+// flush sends the pending lines in a notification
+func (r *progressReporter) flush() {
+	r.mu.Lock()
+	if len(r.pending) == 0 || r.failed || r.sent >= r.maxNotifications {
+		r.mu.Unlock()
+		return
+	}
+	message := r.message(r.pending)
+	progress := float64(r.lines)
+	r.pending = nil
+	r.sent++
+	if r.sent == r.maxNotifications {
+		message += "\n... [no more progress notifications, the full output will be in the result] ..."
+	}
+	r.mu.Unlock()
+
+	if err := r.send(progress, message); err != nil {
+		r.logger.Debug("Could not send progress notification, disabling them: %v", err)
+		r.mu.Lock()
+		r.failed = true
+		r.mu.Unlock()
+	}
+}
+
+// message returns the message for a batch of lines, keeping the last lines when
+// they are longer than the maximum size
+func (r *progressReporter) message(lines []string) string {
+	message := strings.Join(lines, "\n")
+	if len(message) <= r.maxMessageBytes {
+		return message
+	}
+
+	size, kept := 0, 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		if size+len(lines[i])+1 > r.maxMessageBytes {
+			break
+		}
+		size += len(lines[i]) + 1
+		kept++
+	}
+	if kept == 0 {
+		// a single line longer than the limit: keep its end
+		last := lines[len(lines)-1]
+		start := len(last) - r.maxMessageBytes
+		for start < len(last) && !utf8.RuneStart(last[start]) {
+			start++
+		}
+		return fmt.Sprintf("... [%d lines omitted] ...\n", len(lines)-1) + last[start:]
+	}
+	return fmt.Sprintf("... [%d lines omitted] ...\n", len(lines)-kept) + strings.Join(lines[len(lines)-kept:], "\n")
+}
+
+// progressContextKey is the context key for the progressReporter
+type progressContextKey struct{}
+
+// withProgress returns a copy of the context with the given progress reporter.
+func withProgress(ctx context.Context, reporter *progressReporter) context.Context {
+	return context.WithValue(ctx, progressContextKey{}, reporter)
+}
+
+// progressFromContext returns the progress reporter stored in the context, or nil if none.
+func progressFromContext(ctx context.Context) *progressReporter {
+	reporter, _ := ctx.Value(progressContextKey{}).(*progressReporter)
+	return reporter
+}
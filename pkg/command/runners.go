@@ -0,0 +1,316 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
+	"github.com/inercia/MCPShell/pkg/config"
+	runnercommon "github.com/inercia/go-restricted-runner/pkg/common"
+	"github.com/inercia/go-restricted-runner/pkg/runner"
+)
+
+// processRunner runs the (already rendered) commands of a tool with one of the runners.
+//
+// The Runner interface of the runner library only returns the stdout of the commands that
+// succeed, so the exec and docker runners are run directly (capturing the stdout, stderr
+// and exit code separately, streaming the output and terminating all the processes on
+// cancellations), while the firejail and sandbox-exec runners wrap the runner library.
+type processRunner interface {
+	// run runs the command, or argv when provided (without a shell)
+	run(ctx context.Context, cmd string, argv []string, env []string, params map[string]interface{}, opts processOptions) (*processOutput, error)
+}
+
+// newProcessRunner creates the runner for the commands of a tool, checking the implicit
+// requirements of the runner (ie, the Docker daemon is running).
+//
+// Parameters:
+//   - runnerType: The type of runner
+//   - runnerOpts: The options of the runner
+//   - shell: The shell of the tool (the exec runner can override it with its `shell` option)
+//   - render: The function for rendering the templates of the options with the arguments
+//   - logger: Logger for the runner
+//
+// Returns:
+//   - The runner
+//   - An error if the options are not valid or the requirements are not met
+func newProcessRunner(runnerType runner.Type, runnerOpts runner.Options, shell string, render templateRenderer, logger *common.Logger) (processRunner, error) {
+	runnerLogger, err := newRunnerLogger(logger)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := runner.New(runnerType, runnerOpts, runnerLogger); err != nil {
+		return nil, err
+	}
+
+	switch runnerType {
+	case runner.TypeExec:
+		opts, err := runner.NewExecOptions(runnerOpts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exec options: %w", err)
+		}
+		if opts.Shell != "" {
+			shell = opts.Shell
+		}
+		exec := &execRunner{shell: shell}
+		if runtime.GOOS == "windows" {
+			exec.library = newLibraryRunner(runnerType, runnerOpts, runnerLogger, shell, render, logger)
+		}
+		return exec, nil
+
+	case runner.TypeDocker:
+		opts, err := runner.NewDockerOptions(runnerOpts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid docker options: %w", err)
+		}
+		return &dockerRunner{opts: opts, shell: shell, logger: logger}, nil
+
+	default:
+		return newLibraryRunner(runnerType, runnerOpts, runnerLogger, shell, render, logger), nil
+	}
+}
+
+// execRunner runs the commands directly, with the shell of the runner
+type execRunner struct {
+	shell   string
+	library *libraryRunner // the exec runner of the library, for the shells of Windows (nil in other systems)
+}
+
+// run runs the command with the shell, from a temporary script file
+func (r *execRunner) run(ctx context.Context, cmd string, argv []string, env []string, params map[string]interface{}, opts processOptions) (*processOutput, error) {
+	if len(argv) > 0 {
+		return runProcess(ctx, argv[0], argv[1:], env, opts)
+	}
+	if r.library != nil {
+		return r.library.run(ctx, cmd, nil, env, params, opts)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "mcpshell")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	script := filepath.Join(tmpDir, "script.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+cmd), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to write temporary script: %w", err)
+	}
+
+	return runProcess(ctx, getShell(r.shell), []string{script}, env, opts)
+}
+
+// dockerRunner runs the commands in a new Docker container, with the options of the runner
+type dockerRunner struct {
+	opts   runner.DockerOptions
+	shell  string
+	logger *common.Logger
+}
+
+// run runs the command in a new container, built with the command line of the runner
+// library. When argv is provided, it is executed instead of the command.
+func (r *dockerRunner) run(ctx context.Context, cmd string, argv []string, env []string, params map[string]interface{}, opts processOptions) (*processOutput, error) {
+	// Name the container, so it can be stopped when the execution is cancelled
+	name, err := containerName()
+	if err != nil {
+		return nil, err
+	}
+	dockerOpts := r.opts
+	dockerOpts.DockerRunOpts = strings.TrimSpace(dockerOpts.DockerRunOpts + " --name " + name)
+
+	dockerCmd := dockerOpts.GetDirectExecutionCommand(r.containerCommand(cmd, argv), env)
+	r.logger.Debug("Running command in Docker: %s", dockerCmd)
+
+	// Killing the docker client does not stop the container
+	opts.onCancel = func() {
+		r.logger.Info("Stopping container %s", name)
+		stopCtx, cancel := context.WithTimeout(context.Background(), opts.killAfter+10*time.Second)
+		defer cancel()
+		seconds := strconv.Itoa(int(opts.killAfter.Round(time.Second).Seconds()))
+		if out, err := exec.CommandContext(stopCtx, "docker", "stop", "--time", seconds, name).CombinedOutput(); err != nil {
+			r.logger.Error("Failed to stop container %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return runProcess(ctx, "sh", []string{"-c", dockerCmd}, nil, opts)
+}
+
+// containerCommand returns the command run in the container: the prepare command of the
+// runner (if any) followed by the command of the tool, or its argv (with all the arguments
+// quoted, so they are never interpreted by the shell of the container)
+func (r *dockerRunner) containerCommand(cmd string, argv []string) string {
+	var script string
+	if len(argv) > 0 {
+		script = "exec " + quoteArgs(argv)
+	} else {
+		shell := r.shell
+		if shell == "" {
+			shell = "sh"
+		}
+		script = fmt.Sprintf("exec %s -c %s", shell, shellQuote(strings.TrimSpace(cmd)))
+	}
+	if r.opts.PrepareCommand != "" {
+		script = r.opts.PrepareCommand + "\n" + script
+	}
+	return "sh -c " + shellQuote(script)
+}
+
+// templateRenderer renders a template with the arguments of a call
+type templateRenderer func(text string, params map[string]interface{}) (string, error)
+
+// libraryRunner runs the commands with a runner of the runner library (ie, firejail
+// or sandbox-exec). The library only returns the stdout of the commands that succeed,
+// and the stderr (without the exit code) of the commands that fail.
+type libraryRunner struct {
+	runnerType runner.Type
+	options    runner.Options
+	newRunner  func(options runner.Options) (runner.Runner, error)
+	render     templateRenderer
+	shell      string
+	logger     *common.Logger
+}
+
+// newLibraryRunner creates a runner with the runner library. The runners of the library
+// render the templates of their options with the parameters of each call (and keep the
+// result), so a new one is created for every command (without checking the implicit
+// requirements again).
+func newLibraryRunner(runnerType runner.Type, runnerOpts runner.Options, runnerLogger *runnercommon.Logger,
+	shell string, render templateRenderer, logger *common.Logger,
+) *libraryRunner {
+	newRunner := func(options runner.Options) (runner.Runner, error) {
+		switch runnerType {
+		case runner.TypeFirejail:
+			return runner.NewFirejail(options, runnerLogger)
+		case runner.TypeSandboxExec:
+			return runner.NewSandboxExec(options, runnerLogger)
+		default:
+			return runner.NewExec(options, runnerLogger)
+		}
+	}
+	return &libraryRunner{
+		runnerType: runnerType,
+		options:    runnerOpts,
+		newRunner:  newRunner,
+		render:     render,
+		shell:      shell,
+		logger:     logger,
+	}
+}
+
+// renderOptions renders the templates in the options of the runner with the arguments,
+// with the same (restricted) template functions as the command. The runner library
+// renders these options again (with all the template functions), so the values
+// rendered cannot contain templates (ie, coming from the arguments).
+func (r *libraryRunner) renderOptions(params map[string]interface{}) (runner.Options, error) {
+	options := maps.Clone(r.options)
+	for _, name := range config.TemplatedRunnerOptions {
+		var templates []string
+		switch values := options[name].(type) {
+		case []string:
+			templates = values
+		case []interface{}:
+			for _, value := range values {
+				text, ok := value.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid runner option '%s': expected a list of strings", name)
+				}
+				templates = append(templates, text)
+			}
+		default:
+			continue
+		}
+
+		rendered := make([]string, 0, len(templates))
+		for _, text := range templates {
+			value, err := r.render(text, params)
+			if err != nil {
+				return nil, fmt.Errorf("error processing runner option '%s': %w", name, err)
+			}
+			if strings.Contains(value, "{{") {
+				return nil, fmt.Errorf("runner option '%s' cannot contain '{{' after processing its template", name)
+			}
+			rendered = append(rendered, value)
+		}
+		options[name] = rendered
+	}
+	return options, nil
+}
+
+// run runs the command with the runner library. The runners of the library run the
+// commands with a shell, so argv is run as a command with all the arguments quoted
+// (so they are never interpreted by the shell).
+func (r *libraryRunner) run(ctx context.Context, cmd string, argv []string, env []string, params map[string]interface{}, opts processOptions) (*processOutput, error) {
+	if len(argv) > 0 {
+		cmd = quoteArgs(argv)
+	}
+
+	options, err := r.renderOptions(params)
+	if err != nil {
+		return nil, err
+	}
+	libraryRunner, err := r.newRunner(options)
+	if err != nil {
+		return nil, fmt.Errorf("error creating runner: %v", err)
+	}
+
+	// The runner library does not terminate the command gracefully: use the timeout
+	// command when available (so the command receives SIGTERM), or kill it when the
+	// context expires
+	runCtx := ctx
+	timeoutCommand := false
+	if opts.timeout > 0 {
+		deadline := opts.timeout
+		if runner.ShouldUseUnixTimeoutCommand() {
+			timeoutCommand = true
+			cmd = fmt.Sprintf("timeout --kill-after=%s %s sh -c %s", durationArg(opts.killAfter), durationArg(opts.timeout), shellQuote(cmd))
+			deadline += opts.killAfter + time.Second
+			r.logger.Debug("Wrapped command with Unix timeout: %s", opts.timeout)
+		}
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	start := time.Now()
+	output, err := libraryRunner.Run(runCtx, r.shell, cmd, env, params, true)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// The runner library does not return the stdout of the commands that fail, and it
+		// only returns their stderr (as the error, without the exit code) when there is some
+		result := &processOutput{Stderr: err.Error(), ExitCode: 1}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		}
+
+		switch {
+		case opts.timeout > 0 && runCtx.Err() != nil:
+			// killed when the context expired
+			result.TimedOut = true
+		case timeoutCommand && exitErr != nil:
+			// terminated (124) or killed (137) by the timeout command
+			result.TimedOut = result.ExitCode == 124 || result.ExitCode == 137
+		case timeoutCommand:
+			// the exit code is not available when the command writes to stderr
+			result.TimedOut = time.Since(start) >= opts.timeout
+		}
+		if result.TimedOut && exitErr != nil {
+			result.Stderr = "" // the error is just the exit status, not an output of the command
+		}
+		return result, nil
+	}
+	return &processOutput{Stdout: output}, nil
+}
@@ -0,0 +1,238 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	mcpserver "github.com/mark3labs/mcp-go/server"
+
+	"github.com/inercia/MCPShell/pkg/common"
+	"github.com/inercia/MCPShell/pkg/config"
+)
+
+// LimitError is the error returned when a call exceeds a rate limit or a quota.
+type LimitError struct {
+	Limit   string        // the limit exceeded (ie, "rate limit of tool 'x' (10/min)")
+	ResetAt time.Time     // when the limit resets (zero when it resets with a new session)
+	ResetIn time.Duration // ... and the time until then
+}
+
+// Error returns the error message
+func (e *LimitError) Error() string {
+	if e.ResetAt.IsZero() {
+		return fmt.Sprintf("%s exceeded: the limit resets with a new session", e.Limit)
+	}
+	return fmt.Sprintf("%s exceeded: the limit resets at %s (in %s)", e.Limit, e.ResetAt.UTC().Format(time.RFC3339), e.ResetIn)
+}
+
+// tokenBucket is the state of a rate limit for a session
+type tokenBucket struct {
+	tokens float64   // the calls available
+	last   time.Time // the last time the tokens were refilled
+}
+
+// quotaUsage is the usage of a quota in a session (or in a day)
+type quotaUsage struct {
+	calls   int           // the calls made
+	runtime time.Duration // the cumulative runtime of the commands
+}
+
+// UsageLimiter enforces a rate limit (with a token bucket for each session) and a
+// quota (of calls and cumulative runtime, for each session or for each day).
+type UsageLimiter struct {
+	scope      string
+	rateLimit  string
+	rate       int           // the calls allowed in each period (zero for no rate limit)
+	period     time.Duration // ... and the period
+	quota      config.MCPQuotaConfig
+	maxRuntime time.Duration
+	logger     *common.Logger
+	now        func() time.Time // the current time (replaceable in tests)
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // the rate limits, by session
+	usage   map[string]*quotaUsage  // the usage of the quota, by session (or by day)
+}
+
+// NewUsageLimiter creates a limiter for a rate limit and a quota.
+//
+// Parameters:
+//   - scope: What is limited, for messages (ie, "tool 'x'", or "the server")
+//   - rateLimit: The rate limit (ie, "10/min"), or empty for no rate limit
+//   - quota: The quota (a zero quota for no quota)
+//   - logger: Logger for reporting the calls rejected
+//
+// Returns:
+//   - A new UsageLimiter
+//   - An error if the rate limit or the quota are not valid
+func NewUsageLimiter(scope string, rateLimit string, quota config.MCPQuotaConfig, logger *common.Logger) (*UsageLimiter, error) {
+	l := &UsageLimiter{
+		scope:     scope,
+		rateLimit: rateLimit,
+		quota:     quota,
+		logger:    logger,
+		now:       time.Now,
+		buckets:   map[string]*tokenBucket{},
+		usage:     map[string]*quotaUsage{},
+	}
+
+	var err error
+	if rateLimit != "" {
+		if l.rate, l.period, err = config.ParseRateLimit(rateLimit); err != nil {
+			return nil, err
+		}
+	}
+	if err := quota.Validate(); err != nil {
+		return nil, err
+	}
+	if l.maxRuntime, err = quota.GetMaxRuntime(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Matches returns true if the limiter has the given limits (ie, for reusing it
+// when the configuration is reloaded)
+func (l *UsageLimiter) Matches(rateLimit string, quota config.MCPQuotaConfig) bool {
+	return l != nil && l.rateLimit == rateLimit && l.quota == quota
+}
+
+// UsageReservation is a call allowed by a UsageLimiter. The call is counted in the
+// rate limit and the quota from the moment it is reserved, so concurrent calls cannot
+// exceed them, but it is refunded if it is cancelled (ie, when the command never runs).
+type UsageReservation struct {
+	limiter *UsageLimiter
+	bucket  *tokenBucket // the rate limit the call was counted in (nil for no rate limit)
+	usage   *quotaUsage  // the quota the call was counted in (nil for no quota)
+	done    bool
+}
+
+// Reserve checks the rate limit and the quota for a call, counting it when it is allowed.
+//
+// Parameters:
+//   - session: The ID of the session of the client (empty when unknown)
+//
+// Returns:
+//   - A reservation that must be committed when the command runs, or cancelled when
+//     it does not run
+//   - A *LimitError if the call exceeds the rate limit or the quota
+func (l *UsageLimiter) Reserve(session string) (*UsageReservation, error) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reservation := &UsageReservation{limiter: l}
+
+	// check the quota first, so calls rejected by the quota do not consume the rate limit
+	if !l.quota.IsZero() {
+		key, resetAt := session, time.Time{}
+		if l.quota.GetPeriod() == config.QuotaPerDay {
+			day := now.UTC().Truncate(24 * time.Hour)
+			key, resetAt = day.Format(time.DateOnly), day.Add(24*time.Hour)
+			if _, exists := l.usage[key]; !exists {
+				clear(l.usage) // forget the previous days
+			}
+		}
+
+		usage := l.usage[key]
+		if usage == nil {
+			usage = &quotaUsage{}
+			l.usage[key] = usage
+		}
+
+		var limit string
+		switch {
+		case l.quota.MaxCalls > 0 && usage.calls >= l.quota.MaxCalls:
+			limit = fmt.Sprintf("quota of %s (%d calls per %s)", l.scope, l.quota.MaxCalls, l.quota.GetPeriod())
+		case l.maxRuntime > 0 && usage.runtime >= l.maxRuntime:
+			limit = fmt.Sprintf("quota of %s (%s of runtime per %s)", l.scope, l.maxRuntime, l.quota.GetPeriod())
+		}
+		if limit != "" {
+			l.logger.Info("The %s has been exceeded in session '%s'", limit, session)
+			err := &LimitError{Limit: limit, ResetAt: resetAt}
+			if !resetAt.IsZero() {
+				err.ResetIn = resetAt.Sub(now).Round(time.Second)
+			}
+			return nil, err
+		}
+		reservation.usage = usage
+	}
+
+	if l.rate > 0 {
+		bucket := l.buckets[session]
+		if bucket == nil {
+			bucket = &tokenBucket{tokens: float64(l.rate), last: now}
+			l.buckets[session] = bucket
+		}
+
+		// refill the tokens for the time elapsed since the last call
+		perToken := l.period / time.Duration(l.rate)
+		bucket.tokens = min(float64(l.rate), bucket.tokens+float64(now.Sub(bucket.last))/float64(perToken))
+		bucket.last = now
+
+		if bucket.tokens < 1 {
+			limit := fmt.Sprintf("rate limit of %s (%s)", l.scope, l.rateLimit)
+			l.logger.Info("The %s has been exceeded in session '%s'", limit, session)
+			wait := time.Duration((1 - bucket.tokens) * float64(perToken))
+			return nil, &LimitError{Limit: limit, ResetAt: now.Add(wait), ResetIn: (wait + time.Second - 1).Truncate(time.Second)}
+		}
+		bucket.tokens--
+		reservation.bucket = bucket
+	}
+
+	if reservation.usage != nil {
+		reservation.usage.calls++
+	}
+	return reservation, nil
+}
+
+// Commit adds the runtime of the command to the quota, once it finishes
+func (r *UsageReservation) Commit(runtime time.Duration) {
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	if r.usage != nil {
+		r.usage.runtime += runtime
+	}
+}
+
+// Cancel refunds the call to the rate limit and to the quota, when the command does not
+// run (ie, when it is rejected by another limit). It does nothing once committed.
+func (r *UsageReservation) Cancel() {
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	if r.bucket != nil {
+		r.bucket.tokens = min(float64(r.limiter.rate), r.bucket.tokens+1)
+	}
+	if r.usage != nil {
+		r.usage.calls--
+	}
+}
+
+// Forget forgets the rate limit and the quota of a session (ie, when it is closed)
+func (l *UsageLimiter) Forget(session string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, session)
+	if l.quota.GetPeriod() == config.QuotaPerSession {
+		delete(l.usage, session)
+	}
+}
+
+// sessionID returns the ID of the session of the client, or an empty string if unknown
+func sessionID(ctx context.Context) string {
+	if session := mcpserver.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
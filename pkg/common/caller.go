@@ -0,0 +1,52 @@
+package common
+
+import "context"
+
+// CallerVariable is the name of the variable with the caller identity in constraints
+const CallerVariable = "_caller"
+
+// Caller identifies the client that is calling a tool, when it is known
+// (ie, when the client has been authenticated in HTTP mode).
+type Caller struct {
+	// Name is the name of the client: the name of its authentication token, or
+	// the common name of its client certificate
+	Name string
+
+	// Subject is the subject of the client certificate (empty if the client did not provide one)
+	Subject string
+}
+
+// callerContextKey is the context key for the Caller
+type callerContextKey struct{}
+
+// WithCaller returns a copy of the context with the given caller.
+func WithCaller(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, callerContextKey{}, caller)
+}
+
+// CallerFromContext returns the caller stored in the context, or nil if unknown.
+func CallerFromContext(ctx context.Context) *Caller {
+	caller, _ := ctx.Value(callerContextKey{}).(*Caller)
+	return caller
+}
+
+// String returns a description of the caller for logs.
+func (c *Caller) String() string {
+	switch {
+	case c == nil || (c.Name == "" && c.Subject == ""):
+		return "unknown"
+	case c.Subject == "" || c.Name == c.Subject:
+		return c.Name
+	default:
+		return c.Name + " (" + c.Subject + ")"
+	}
+}
+
+// Variables returns the caller identity as a map, for using it in constraints
+// (ie, `_caller.name == 'ci-agent'`). All the fields are empty for unknown callers.
+func (c *Caller) Variables() map[string]string {
+	if c == nil {
+		return map[string]string{"name": "", "subject": ""}
+	}
+	return map[string]string{"name": c.Name, "subject": c.Subject}
+}
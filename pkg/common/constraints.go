@@ -2,6 +2,7 @@ package common
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/google/cel-go/cel"
 )
@@ -31,23 +32,22 @@ func NewCompiledConstraints(constraints []string, paramTypes map[string]ParamCon
 
 	// Add parameter declarations based on their types
 	for name, param := range paramTypes {
-		paramType := param.Type
-		if paramType == "" {
-			paramType = "string"
+		if name == CallerVariable {
+			return nil, fmt.Errorf("parameter name '%s' is reserved", name)
 		}
-
-		switch paramType {
-		case "string":
-			envOpts = append(envOpts, cel.Variable(name, cel.StringType))
-		case "number", "integer":
-			envOpts = append(envOpts, cel.Variable(name, cel.DoubleType))
-		case "boolean":
-			envOpts = append(envOpts, cel.Variable(name, cel.BoolType))
-		default:
-			return nil, fmt.Errorf("unsupported parameter type for CEL: %s", paramType)
+		celType, err := celTypeForParam(param)
+		if err != nil {
+			return nil, err
 		}
+		envOpts = append(envOpts, cel.Variable(name, celType))
 	}
 
+	// The identity of the caller (empty when unknown)
+	envOpts = append(envOpts, cel.Variable(CallerVariable, cel.MapType(cel.StringType, cel.StringType)))
+
+	// Allow comparing integer parameters with double literals (ie, `replicas < 2.5`)
+	envOpts = append(envOpts, cel.CrossTypeNumericComparisons(true))
+
 	env, err := cel.NewEnv(envOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
@@ -79,6 +79,64 @@ func NewCompiledConstraints(constraints []string, paramTypes map[string]ParamCon
 	}, nil
 }
 
+// celTypeForParam returns the CEL type used for declaring a parameter.
+// Arrays are declared as lists of their items type (strings by default) and
+// objects as maps from strings to dynamic values, so expressions like
+// `names.all(n, n.matches('^[a-z]+$'))` or `labels.app == 'web'` type-check.
+// Integers are real CEL ints, so `replicas % 2 == 0` works as expected.
+func celTypeForParam(param ParamConfig) (*cel.Type, error) {
+	paramType := param.Type
+	if paramType == "" {
+		paramType = "string"
+	}
+
+	switch paramType {
+	case "string":
+		return cel.StringType, nil
+	case "number":
+		return cel.DoubleType, nil
+	case "integer":
+		return cel.IntType, nil
+	case "boolean":
+		return cel.BoolType, nil
+	case "array":
+		itemType := cel.StringType
+		if param.Items != nil {
+			var err error
+			itemType, err = celTypeForParam(*param.Items)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return cel.ListType(itemType), nil
+	case "object":
+		return cel.MapType(cel.StringType, cel.DynType), nil
+	default:
+		return nil, fmt.Errorf("unsupported parameter type for CEL: %s", paramType)
+	}
+}
+
+// toCELValue converts whole numbers of integer parameters (and of arrays of
+// integers) to int64, the Go type CEL uses for its ints. Other values are
+// returned unchanged (and rejected by CEL at evaluation time if mistyped).
+func toCELValue(value interface{}, param ParamConfig) interface{} {
+	switch param.Type {
+	case "integer":
+		if number, ok := toFloat64(value); ok && number == math.Trunc(number) {
+			return int64(number)
+		}
+	case "array":
+		if list, ok := value.([]interface{}); ok && param.Items != nil {
+			converted := make([]interface{}, len(list))
+			for i, item := range list {
+				converted[i] = toCELValue(item, *param.Items)
+			}
+			return converted
+		}
+	}
+	return value
+}
+
 // Evaluate evaluates all compiled constraints against the provided arguments
 // and returns details about which constraints failed.
 //
@@ -106,6 +164,9 @@ func (cc *CompiledConstraints) Evaluate(args map[string]interface{}, params map[
 	// Create a copy of args to avoid modifying the original
 	evalArgs := make(map[string]interface{})
 	for k, v := range args {
+		if param, exists := params[k]; exists {
+			v = toCELValue(v, param)
+		}
 		evalArgs[k] = v
 		cc.logger.Debug("Argument provided: %s = %v", k, v)
 	}
@@ -118,16 +179,30 @@ func (cc *CompiledConstraints) Evaluate(args map[string]interface{}, params map[
 			case "string", "":
 				evalArgs[name] = ""
 				cc.logger.Debug("Adding default empty string for missing parameter: %s", name)
-			case "number", "integer":
+			case "number":
 				evalArgs[name] = 0.0
 				cc.logger.Debug("Adding default zero value for missing parameter: %s", name)
+			case "integer":
+				evalArgs[name] = int64(0)
+				cc.logger.Debug("Adding default zero value for missing parameter: %s", name)
 			case "boolean":
 				evalArgs[name] = false
 				cc.logger.Debug("Adding default false value for missing parameter: %s", name)
+			case "array":
+				evalArgs[name] = []interface{}{}
+				cc.logger.Debug("Adding default empty list for missing parameter: %s", name)
+			case "object":
+				evalArgs[name] = map[string]interface{}{}
+				cc.logger.Debug("Adding default empty map for missing parameter: %s", name)
 			}
 		}
 	}
 
+	// The caller is unknown when not provided
+	if _, exists := evalArgs[CallerVariable]; !exists {
+		evalArgs[CallerVariable] = (*Caller)(nil).Variables()
+	}
+
 	var failedConstraints []string
 
 	// Evaluate each constraint program
@@ -172,6 +247,9 @@ func (cc *CompiledConstraints) Evaluate(args map[string]interface{}, params map[
 func formatArgValues(args map[string]interface{}) string {
 	result := ""
 	for k, v := range args {
+		if k == CallerVariable {
+			continue
+		}
 		if result != "" {
 			result += ", "
 		}
@@ -104,13 +104,40 @@ func TestConstraints(t *testing.T) {
 		},
 		{
 			name:        "Unsupported parameter type",
-			constraints: []string{"obj.field == 'value'"},
+			constraints: []string{"when == 'today'"},
 			paramTypes: map[string]ParamConfig{
-				"obj": {Type: "object", Description: "Object"}, // Unsupported type
+				"when": {Type: "date", Description: "Date"}, // Unsupported type
 			},
 			skipEvaluation: true,
 			wantCompileErr: true,
 		},
+		{
+			name:        "Array constraints",
+			constraints: []string{"names.size() <= 5", "names.all(n, n.matches('^[a-z-]+$'))"},
+			paramTypes: map[string]ParamConfig{
+				"names": {Type: "array", Description: "Names"}, // Items default to strings
+			},
+			skipEvaluation: true,
+			wantCompileErr: false,
+		},
+		{
+			name:        "Array with wrong items type",
+			constraints: []string{"names.all(n, n.matches('^[a-z-]+$'))"},
+			paramTypes: map[string]ParamConfig{
+				"names": {Type: "array", Description: "Numbers", Items: &ParamConfig{Type: "number"}},
+			},
+			skipEvaluation: true,
+			wantCompileErr: true,
+		},
+		{
+			name:        "Object constraints",
+			constraints: []string{"labels.all(k, k.matches('^[a-z]+$'))", "labels.app == 'web'"},
+			paramTypes: map[string]ParamConfig{
+				"labels": {Type: "object", Description: "Labels"},
+			},
+			skipEvaluation: true,
+			wantCompileErr: false,
+		},
 		{
 			name:        "Nil logger",
 			constraints: []string{"text.size() < 10"},
@@ -335,6 +362,113 @@ func TestConstraints(t *testing.T) {
 			wantEvalResult: true,
 			wantEvalErr:    false,
 		},
+		{
+			name:        "Array elements - pass",
+			constraints: []string{"names.all(n, n.matches('^[a-z-]+$'))"},
+			paramTypes: map[string]ParamConfig{
+				"names": {Type: "array", Description: "Names", Items: &ParamConfig{Type: "string"}},
+			},
+			args:           map[string]interface{}{"names": []interface{}{"web-front", "db"}},
+			wantCompileErr: false,
+			wantEvalResult: true,
+			wantEvalErr:    false,
+		},
+		{
+			name:        "Array elements - fail",
+			constraints: []string{"names.all(n, n.matches('^[a-z-]+$'))"},
+			paramTypes: map[string]ParamConfig{
+				"names": {Type: "array", Description: "Names", Items: &ParamConfig{Type: "string"}},
+			},
+			args:           map[string]interface{}{"names": []interface{}{"web", "db; rm -rf /"}},
+			wantCompileErr: false,
+			wantEvalResult: false,
+			wantEvalErr:    false,
+		},
+		{
+			name:        "Numeric array elements - pass",
+			constraints: []string{"ports.all(p, p > 0.0 && p < 65536.0)"},
+			paramTypes: map[string]ParamConfig{
+				"ports": {Type: "array", Description: "Ports", Items: &ParamConfig{Type: "number"}},
+			},
+			args:           map[string]interface{}{"ports": []interface{}{80.0, 443.0}},
+			wantCompileErr: false,
+			wantEvalResult: true,
+			wantEvalErr:    false,
+		},
+		{
+			name:        "Object fields - pass",
+			constraints: []string{"labels.all(k, k.matches('^[a-z]+$'))", "labels.app == 'web'"},
+			paramTypes: map[string]ParamConfig{
+				"labels": {Type: "object", Description: "Labels"},
+			},
+			args:           map[string]interface{}{"labels": map[string]interface{}{"app": "web", "tier": "front"}},
+			wantCompileErr: false,
+			wantEvalResult: true,
+			wantEvalErr:    false,
+		},
+		{
+			name:        "Default array and object values",
+			constraints: []string{"names.size() == 0", "labels.size() == 0"},
+			paramTypes: map[string]ParamConfig{
+				"names":  {Type: "array", Description: "Names"},
+				"labels": {Type: "object", Description: "Labels"},
+			},
+			args:           map[string]interface{}{},
+			wantCompileErr: false,
+			wantEvalResult: true,
+			wantEvalErr:    false,
+		},
+		{
+			name:        "Integer parameters are CEL ints",
+			constraints: []string{"replicas % 2 == 0", "replicas < 10", "replicas < 9.5"},
+			paramTypes: map[string]ParamConfig{
+				"replicas": {Type: "integer", Description: "Replicas"},
+			},
+			args:           map[string]interface{}{"replicas": 4.0},
+			wantCompileErr: false,
+			wantEvalResult: true,
+			wantEvalErr:    false,
+		},
+		{
+			name:        "Integer array elements",
+			constraints: []string{"ports.all(p, p % 2 == 0)"},
+			paramTypes: map[string]ParamConfig{
+				"ports": {Type: "array", Description: "Ports", Items: &ParamConfig{Type: "integer"}},
+			},
+			args:           map[string]interface{}{"ports": []interface{}{80.0, 443.0}},
+			wantCompileErr: false,
+			wantEvalResult: false,
+			wantEvalErr:    false,
+		},
+		{
+			name:           "Unknown caller",
+			constraints:    []string{"_caller.name == ''", "_caller.subject == ''"},
+			paramTypes:     map[string]ParamConfig{},
+			args:           map[string]interface{}{},
+			wantCompileErr: false,
+			wantEvalResult: true,
+			wantEvalErr:    false,
+		},
+		{
+			name:        "Known caller",
+			constraints: []string{"_caller.name in ['alice', 'bob']"},
+			paramTypes:  map[string]ParamConfig{},
+			args: map[string]interface{}{
+				CallerVariable: (&Caller{Name: "mallory"}).Variables(),
+			},
+			wantCompileErr: false,
+			wantEvalResult: false,
+			wantEvalErr:    false,
+		},
+		{
+			name:        "Reserved parameter name",
+			constraints: []string{"_caller == 'root'"},
+			paramTypes: map[string]ParamConfig{
+				"_caller": {Type: "string", Description: "Caller"},
+			},
+			args:           map[string]interface{}{},
+			wantCompileErr: true,
+		},
 		{
 			name:        "Partial parameters provided",
 			constraints: []string{"name.size() > 0", "value == 0.0", "flag == true"},
@@ -0,0 +1,348 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/cel"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Parts of the output kept when it is truncated
+const (
+	KeepHead = "head" // keep the first lines (or bytes)
+	KeepTail = "tail" // keep the last lines (or bytes)
+	KeepBoth = "both" // keep the first and the last lines (or bytes), removing the middle
+)
+
+var (
+	// ansiRegexp matches ANSI escape sequences: CSI sequences (ie, colors), OSC sequences
+	// (ie, window titles and hyperlinks) and other two-character sequences
+	ansiRegexp = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+	// spacesRegexp matches runs of spaces and tabs
+	spacesRegexp = regexp.MustCompile(`[ \t]+`)
+)
+
+// OutputReport describes the changes made by the output processing, so the LLM
+// knows when the output has been cut.
+type OutputReport struct {
+	OriginalBytes int    `json:"original_bytes"`           // size of the output of the command
+	OriginalLines int    `json:"original_lines"`           // lines in the output of the command
+	Bytes         int    `json:"bytes"`                    // size of the output returned
+	Lines         int    `json:"lines"`                    // lines in the output returned
+	FilteredLines int    `json:"filtered_lines,omitempty"` // lines removed by the include/exclude filters
+	Format        string `json:"format,omitempty"`         // the format the output was converted to
+	RowsOmitted   int    `json:"rows_omitted,omitempty"`   // rows removed by the rows limit of the conversion
+	Truncated     bool   `json:"truncated,omitempty"`      // whether the output was truncated
+}
+
+// Modified returns true if the output returned is not the output of the command
+func (r OutputReport) Modified() bool {
+	return r.Truncated || r.FilteredLines > 0 || r.Format != "" || r.Bytes != r.OriginalBytes
+}
+
+// OutputProcessor applies the output processing pipeline of an OutputConfig.
+type OutputProcessor struct {
+	config  OutputConfig
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+
+	selectProgram cel.Program        // the compiled `select` expression (nil if not provided)
+	schema        *jsonschema.Schema // the compiled output schema (nil if not provided)
+}
+
+// NewOutputProcessor creates an output processor, compiling the filters of the configuration.
+//
+// Parameters:
+//   - config: The output configuration
+//
+// Returns:
+//   - A new OutputProcessor
+//   - An error if some filter or limit is not valid
+func NewOutputProcessor(config OutputConfig) (*OutputProcessor, error) {
+	p := &OutputProcessor{config: config}
+
+	compile := func(kind string, patterns []string) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s pattern '%s': %w", kind, pattern, err)
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+
+	var err error
+	if p.include, err = compile("include", config.Include); err != nil {
+		return nil, err
+	}
+	if p.exclude, err = compile("exclude", config.Exclude); err != nil {
+		return nil, err
+	}
+
+	if config.MaxLines < 0 {
+		return nil, fmt.Errorf("max_lines must not be negative")
+	}
+	if config.MaxBytes < 0 {
+		return nil, fmt.Errorf("max_bytes must not be negative")
+	}
+	switch config.Keep {
+	case "", KeepHead, KeepTail, KeepBoth:
+	default:
+		return nil, fmt.Errorf("invalid keep value '%s' (must be '%s', '%s' or '%s')", config.Keep, KeepHead, KeepTail, KeepBoth)
+	}
+
+	if err := validateFormat(config); err != nil {
+		return nil, err
+	}
+
+	if config.Select != "" {
+		if p.selectProgram, err = compileSelect(config.Select); err != nil {
+			return nil, err
+		}
+	}
+	if config.Schema != nil {
+		if p.schema, err = compileOutputSchema(config.Schema); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Process applies the pipeline to the output of a command: ANSI stripping,
+// include/exclude filters, whitespace collapsing, format conversion, lines limit
+// and bytes limit. Outputs that cannot be converted to the format are kept as they are.
+//
+// Parameters:
+//   - output: The output of the command
+//
+// Returns:
+//   - The processed output
+//   - A report of the changes made
+func (p *OutputProcessor) Process(output string) (string, OutputReport) {
+	report := OutputReport{
+		OriginalBytes: len(output),
+		OriginalLines: countLines(output),
+	}
+
+	if p.config.StripANSI {
+		output = ansiRegexp.ReplaceAllString(output, "")
+	}
+
+	if len(p.include) > 0 || len(p.exclude) > 0 {
+		var kept []string
+		for _, line := range splitLines(output) {
+			if p.keepLine(line) {
+				kept = append(kept, line)
+			} else {
+				report.FilteredLines++
+			}
+		}
+		output = strings.Join(kept, "\n")
+	}
+
+	if p.config.CollapseWhitespace {
+		output = collapseWhitespace(output)
+	}
+
+	if p.config.Format != "" {
+		if converted, omitted, err := p.formatOutput(output); err == nil {
+			output = converted
+			report.Format = p.config.Format
+			report.RowsOmitted = omitted
+			report.Truncated = omitted > 0
+		}
+	}
+
+	output = p.truncate(output, &report)
+
+	report.Bytes = len(output)
+	report.Lines = countLines(output)
+	return output, report
+}
+
+// Limit applies the ANSI stripping and the limits of the pipeline (lines and bytes) to
+// an output that does not go through the other steps, ie, the stderr of a command or
+// the text of the JSON extracted from its output.
+//
+// Parameters:
+//   - output: The output to limit
+//
+// Returns:
+//   - The output, truncated when it exceeds the limits
+//   - A report of the changes made
+func (p *OutputProcessor) Limit(output string) (string, OutputReport) {
+	report := OutputReport{
+		OriginalBytes: len(output),
+		OriginalLines: countLines(output),
+	}
+
+	if p.config.StripANSI {
+		output = ansiRegexp.ReplaceAllString(output, "")
+	}
+	output = p.truncate(output, &report)
+
+	report.Bytes = len(output)
+	report.Lines = countLines(output)
+	return output, report
+}
+
+// truncate applies the lines limit and the bytes limit to the output, marking it as
+// truncated in the report when some limit is exceeded
+func (p *OutputProcessor) truncate(output string, report *OutputReport) string {
+	if p.config.MaxLines > 0 {
+		var truncated bool
+		output, truncated = p.truncateLines(output)
+		report.Truncated = report.Truncated || truncated
+	}
+
+	if p.config.MaxBytes > 0 {
+		var truncated bool
+		output, truncated = p.truncateBytes(output)
+		report.Truncated = report.Truncated || truncated
+	}
+
+	return output
+}
+
+// FilterLine applies the line-based steps of the pipeline (ANSI stripping, include/exclude
+// filters and whitespace collapsing) to a single line, ie, for streaming the output of a
+// command while it runs.
+//
+// Parameters:
+//   - line: A line of the output (without the line break)
+//
+// Returns:
+//   - The processed line
+//   - False if the line is removed by the filters
+func (p *OutputProcessor) FilterLine(line string) (string, bool) {
+	if p.config.StripANSI {
+		line = ansiRegexp.ReplaceAllString(line, "")
+	}
+	if !p.keepLine(line) {
+		return "", false
+	}
+	if p.config.CollapseWhitespace {
+		line = strings.TrimRight(spacesRegexp.ReplaceAllString(line, " "), " ")
+	}
+	return line, true
+}
+
+// keepLine returns true if the line passes the include and exclude filters
+func (p *OutputProcessor) keepLine(line string) bool {
+	if len(p.include) > 0 {
+		included := false
+		for _, re := range p.include {
+			if re.MatchString(line) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, re := range p.exclude {
+		if re.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// truncateLines keeps MaxLines lines of the output, replacing the rest with an elision marker
+func (p *OutputProcessor) truncateLines(output string) (string, bool) {
+	lines := splitLines(output)
+	limit := p.config.MaxLines
+	if len(lines) <= limit {
+		return output, false
+	}
+
+	marker := fmt.Sprintf("... [%d lines omitted] ...", len(lines)-limit)
+	var kept []string
+	switch p.config.Keep {
+	case KeepTail:
+		kept = append([]string{marker}, lines[len(lines)-limit:]...)
+	case KeepBoth:
+		head := (limit + 1) / 2
+		kept = append(append(append([]string{}, lines[:head]...), marker), lines[len(lines)-(limit-head):]...)
+	default:
+		kept = append(append([]string{}, lines[:limit]...), marker)
+	}
+	return strings.Join(kept, "\n"), true
+}
+
+// truncateBytes keeps MaxBytes bytes of the output (without breaking UTF-8 characters),
+// replacing the rest with an elision marker
+func (p *OutputProcessor) truncateBytes(output string) (string, bool) {
+	limit := p.config.MaxBytes
+	if len(output) <= limit {
+		return output, false
+	}
+
+	// move a position back (or forward) to the start of a character
+	backward := func(pos int) int {
+		for pos > 0 && !utf8.RuneStart(output[pos]) {
+			pos--
+		}
+		return pos
+	}
+	forward := func(pos int) int {
+		for pos < len(output) && !utf8.RuneStart(output[pos]) {
+			pos++
+		}
+		return pos
+	}
+
+	switch p.config.Keep {
+	case KeepTail:
+		start := forward(len(output) - limit)
+		return fmt.Sprintf("... [%d bytes omitted] ...\n", start) + output[start:], true
+	case KeepBoth:
+		headEnd := backward((limit + 1) / 2)
+		tailStart := forward(len(output) - (limit - headEnd))
+		return output[:headEnd] + fmt.Sprintf("\n... [%d bytes omitted] ...\n", tailStart-headEnd) + output[tailStart:], true
+	default:
+		end := backward(limit)
+		return output[:end] + fmt.Sprintf("\n... [%d bytes omitted] ...", len(output)-end), true
+	}
+}
+
+// collapseWhitespace collapses runs of spaces and tabs into a single space, removes
+// trailing spaces and collapses consecutive blank lines into a single one
+func collapseWhitespace(output string) string {
+	var lines []string
+	blank := false
+	for _, line := range splitLines(output) {
+		line = strings.TrimRight(spacesRegexp.ReplaceAllString(line, " "), " ")
+		if line == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// splitLines splits the output in lines (an empty output has no lines)
+func splitLines(output string) []string {
+	if output == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+}
+
+// countLines returns the number of lines in the output
+func countLines(output string) int {
+	return len(splitLines(output))
+}
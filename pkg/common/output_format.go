@@ -0,0 +1,449 @@
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats for converting the output of commands
+const (
+	FormatTable       = "table"        // a markdown table
+	FormatYAML        = "yaml"         // YAML
+	FormatJSONCompact = "json-compact" // JSON without indentation
+	FormatCSV         = "csv"          // CSV, with a header
+)
+
+// tabularData is the output of a command parsed as a table
+type tabularData struct {
+	columns []string
+	rows    []map[string]interface{}
+}
+
+// validateFormat checks the format options of the output configuration
+func validateFormat(config OutputConfig) error {
+	switch config.Format {
+	case "", FormatTable, FormatYAML, FormatJSONCompact, FormatCSV:
+	default:
+		return fmt.Errorf("invalid format '%s' (must be '%s', '%s', '%s' or '%s')",
+			config.Format, FormatTable, FormatYAML, FormatJSONCompact, FormatCSV)
+	}
+	if config.MaxRows < 0 {
+		return fmt.Errorf("max_rows must not be negative")
+	}
+	if config.Format == "" && (len(config.Columns) > 0 || config.MaxRows > 0) {
+		return fmt.Errorf("columns and max_rows require a format")
+	}
+	return nil
+}
+
+// formatOutput converts the output of a command to the configured format. The output
+// can be JSON (an array of objects for tables and CSV), CSV, TSV or whitespace-aligned
+// columns with a header (ie, the output of `kubectl get pods` or `df`).
+//
+// Returns the converted output, the number of rows omitted (because of max_rows), or
+// an error if the output cannot be converted.
+func (p *OutputProcessor) formatOutput(output string) (string, int, error) {
+	trimmed := strings.TrimSpace(output)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		var value interface{}
+		if err := json.Unmarshal([]byte(trimmed), &value); err == nil {
+			return p.formatValue(value, jsonKeysOrder([]byte(trimmed)))
+		}
+	}
+
+	data, err := parseTextTable(trimmed)
+	if err != nil {
+		return "", 0, err
+	}
+	return p.formatTable(data)
+}
+
+// formatValue converts a JSON value to the configured format. keysOrder is the order of
+// the keys of the objects in the original JSON (if known).
+func (p *OutputProcessor) formatValue(value interface{}, keysOrder []string) (string, int, error) {
+	if data, ok := tabularFromJSON(value, keysOrder); ok {
+		return p.formatTable(data)
+	}
+
+	// other JSON values can only be converted to YAML or compact JSON
+	switch p.config.Format {
+	case FormatYAML:
+		out, err := marshalYAML(value)
+		if err != nil {
+			return "", 0, err
+		}
+		return out, 0, nil
+	case FormatJSONCompact:
+		out, err := json.Marshal(value)
+		if err != nil {
+			return "", 0, err
+		}
+		return string(out), 0, nil
+	default:
+		return "", 0, fmt.Errorf("output is not a list of objects")
+	}
+}
+
+// formatTable converts a table to the configured format, selecting the columns and
+// limiting the number of rows
+func (p *OutputProcessor) formatTable(data *tabularData) (string, int, error) {
+	columns := data.columns
+	if len(p.config.Columns) > 0 {
+		columns = nil
+		for _, wanted := range p.config.Columns {
+			column := wanted
+			for _, existing := range data.columns {
+				if strings.EqualFold(existing, wanted) {
+					column = existing
+					break
+				}
+			}
+			columns = append(columns, column)
+		}
+	}
+
+	rows := data.rows
+	omitted := 0
+	if p.config.MaxRows > 0 && len(rows) > p.config.MaxRows {
+		omitted = len(rows) - p.config.MaxRows
+		rows = rows[:p.config.MaxRows]
+	}
+
+	var out string
+	var err error
+	switch p.config.Format {
+	case FormatTable:
+		out = markdownTable(columns, rows)
+	case FormatCSV:
+		out, err = csvTable(columns, rows)
+	case FormatYAML:
+		out, err = yamlTable(columns, rows)
+	case FormatJSONCompact:
+		out, err = jsonCompactTable(columns, rows)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	if omitted > 0 {
+		out += fmt.Sprintf("\n... [%d rows omitted] ...", omitted)
+	}
+	return out, omitted, nil
+}
+
+// tabularFromJSON returns the table for a JSON array of objects
+func tabularFromJSON(value interface{}, keysOrder []string) (*tabularData, bool) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+
+	data := &tabularData{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		row, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		keys := make([]string, 0, len(row))
+		for key := range row {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				data.columns = append(data.columns, key)
+			}
+		}
+		data.rows = append(data.rows, row)
+	}
+
+	// keep the order of the keys in the original JSON (the keys not found in it, ie,
+	// created by a `select` expression, go after the others)
+	if len(keysOrder) > 0 {
+		position := map[string]int{}
+		for i, key := range keysOrder {
+			position[key] = i
+		}
+		index := func(key string) int {
+			if i, ok := position[key]; ok {
+				return i
+			}
+			return len(keysOrder)
+		}
+		sort.SliceStable(data.columns, func(i, j int) bool {
+			return index(data.columns[i]) < index(data.columns[j])
+		})
+	}
+
+	return data, true
+}
+
+// jsonKeysOrder returns the keys of the objects in a JSON document (at any depth), in
+// the order they appear first (or nil if the JSON is not valid), so the columns of the
+// objects selected from the document keep the order they have in it
+func jsonKeysOrder(raw []byte) []string {
+	// the containers being decoded: for objects, whether the next token is a key
+	type container struct {
+		object  bool
+		waitKey bool
+	}
+	var stack []*container
+
+	var keys []string
+	seen := map[string]bool{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			return nil
+		}
+
+		var parent *container
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			if parent != nil && parent.object {
+				parent.waitKey = true // after this value, the next token is a key
+			}
+			stack = append(stack, &container{object: tok == json.Delim('{'), waitKey: true})
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+		default:
+			if parent == nil || !parent.object {
+				continue
+			}
+			if parent.waitKey {
+				if key, _ := tok.(string); !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+			parent.waitKey = !parent.waitKey
+		}
+	}
+}
+
+// parseTextTable parses a text table with a header: TSV, CSV or whitespace-aligned columns
+func parseTextTable(output string) (*tabularData, error) {
+	lines := splitLines(output)
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("output is not a table (a header and some rows are required)")
+	}
+
+	header := lines[0]
+	switch {
+	case strings.Contains(header, "\t"):
+		return parseDelimited(output, '\t')
+	case strings.Contains(header, ","):
+		if data, err := parseDelimited(output, ','); err == nil {
+			return data, nil
+		}
+	}
+	return parseColumns(lines)
+}
+
+// parseDelimited parses a CSV (or TSV) table with a header
+func parseDelimited(output string, separator rune) (*tabularData, error) {
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.Comma = separator
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("output is not a valid table: %w", err)
+	}
+	if len(records) < 2 || len(records[0]) < 2 {
+		return nil, fmt.Errorf("output is not a table")
+	}
+
+	data := &tabularData{columns: records[0]}
+	for _, record := range records[1:] {
+		row := map[string]interface{}{}
+		for i, column := range data.columns {
+			row[column] = record[i]
+		}
+		data.rows = append(data.rows, row)
+	}
+	return data, nil
+}
+
+// parseColumns parses a table of whitespace-aligned columns with a header. Only
+// the last column can contain spaces (ie, the command in the output of `ps`). When
+// all the rows have less fields than the header, the last words of the header are
+// considered the name of the last column (ie, "Mounted on" in the output of `df`).
+func parseColumns(lines []string) (*tabularData, error) {
+	header := strings.Fields(lines[0])
+
+	var fields [][]string
+	minFields := len(header)
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		f := strings.Fields(line)
+		fields = append(fields, f)
+		minFields = min(minFields, len(f))
+	}
+
+	if minFields < len(header) {
+		header = append(header[:minFields-1:minFields-1], strings.Join(header[minFields-1:], " "))
+	}
+	if len(header) < 2 || len(fields) == 0 {
+		return nil, fmt.Errorf("output is not a table")
+	}
+
+	data := &tabularData{columns: header}
+	for _, f := range fields {
+		if len(f) > len(header) {
+			f = append(f[:len(header)-1:len(header)-1], strings.Join(f[len(header)-1:], " "))
+		}
+		row := map[string]interface{}{}
+		for i, column := range header {
+			row[column] = f[i]
+		}
+		data.rows = append(data.rows, row)
+	}
+	return data, nil
+}
+
+// cellText returns the text of a value in a table cell
+func cellText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		out, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(out)
+	}
+}
+
+// markdownTable returns the rows as a markdown table
+func markdownTable(columns []string, rows []map[string]interface{}) string {
+	escape := func(s string) string {
+		s = strings.ReplaceAll(s, "|", "\\|")
+		return strings.Join(strings.Fields(s), " ")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("|")
+	for _, column := range columns {
+		sb.WriteString(" " + escape(column) + " |")
+	}
+	sb.WriteString("\n|")
+	for range columns {
+		sb.WriteString(" --- |")
+	}
+	for _, row := range rows {
+		sb.WriteString("\n|")
+		for _, column := range columns {
+			sb.WriteString(" " + escape(cellText(row[column])) + " |")
+		}
+	}
+	return sb.String()
+}
+
+// csvTable returns the rows as CSV, with a header
+func csvTable(columns []string, rows []map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(columns); err != nil {
+		return "", err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = cellText(row[column])
+		}
+		if err := writer.Write(record); err != nil {
+			return "", err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// yamlTable returns the rows as a YAML list of objects (keeping the order of the columns)
+func yamlTable(columns []string, rows []map[string]interface{}) (string, error) {
+	list := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, row := range rows {
+		object := &yaml.Node{Kind: yaml.MappingNode}
+		for _, column := range columns {
+			key, value := &yaml.Node{}, &yaml.Node{}
+			if err := key.Encode(column); err != nil {
+				return "", err
+			}
+			if err := value.Encode(row[column]); err != nil {
+				return "", err
+			}
+			object.Content = append(object.Content, key, value)
+		}
+		list.Content = append(list.Content, object)
+	}
+
+	return marshalYAML(list)
+}
+
+// marshalYAML returns the YAML of a value, indented with two spaces
+func marshalYAML(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// jsonCompactTable returns the rows as a compact JSON array of objects (keeping the order of the columns)
+func jsonCompactTable(columns []string, rows []map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, row := range rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("{")
+		for j, column := range columns {
+			if j > 0 {
+				buf.WriteString(",")
+			}
+			key, _ := json.Marshal(column)
+			value, err := json.Marshal(row[column])
+			if err != nil {
+				return "", err
+			}
+			buf.Write(key)
+			buf.WriteString(":")
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+	}
+	buf.WriteString("]")
+	return buf.String(), nil
+}
@@ -0,0 +1,161 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// OutputVariable is the name of the variable with the JSON output of the command
+// in the `select` expressions
+const OutputVariable = "output"
+
+// outputSchemaURL is the (fake) location of the output schemas when compiling them
+const outputSchemaURL = "mcpshell://output-schema.json"
+
+// compileSelect compiles a `select` expression, a CEL expression with the JSON
+// output of the command in the `output` variable (ie, `output.items.map(i, i.metadata.name)`).
+func compileSelect(expression string) (cel.Program, error) {
+	env, err := cel.NewEnv(cel.Variable(OutputVariable, cel.DynType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid select expression '%s': %w", expression, issues.Err())
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create program for select expression '%s': %w", expression, err)
+	}
+	return program, nil
+}
+
+// compileOutputSchema compiles the JSON schema of the output of a tool. MCP requires
+// the structured content of tools to be an object, so the schema must describe one.
+func compileOutputSchema(schema map[string]interface{}) (*jsonschema.Schema, error) {
+	if schema["type"] != "object" {
+		return nil, fmt.Errorf("the output schema must be of type 'object'")
+	}
+
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output schema: %w", err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid output schema: %w", err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(outputSchemaURL, doc); err != nil {
+		return nil, fmt.Errorf("invalid output schema: %w", err)
+	}
+	compiled, err := compiler.Compile(outputSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output schema: %w", err)
+	}
+	return compiled, nil
+}
+
+// ExtractsJSON returns true if the output of the command must be parsed as JSON
+// (ie, when a `select` expression or an output schema has been provided)
+func (p *OutputProcessor) ExtractsJSON() bool {
+	return p.selectProgram != nil || p.schema != nil
+}
+
+// ExtractJSON parses the output of a command as JSON, selects the fields needed
+// (with the `select` expression) and validates the result against the output schema.
+// The text of the result is converted to the configured format (when possible) and
+// limited like the outputs of Process, while the structured content is returned complete.
+//
+// Parameters:
+//   - output: The output of the command
+//
+// Returns:
+//   - The text of the result: the JSON of the value selected (or the value in the
+//     configured format)
+//   - The structured content of the result (nil when the value selected is not an object,
+//     or when there is no output schema)
+//   - A report of the changes made to the text (relative to the JSON of the value selected)
+//   - An error if the output is not valid JSON, the selection fails or the value
+//     selected does not match the schema
+func (p *OutputProcessor) ExtractJSON(output string) (string, map[string]interface{}, OutputReport, error) {
+	var report OutputReport
+	var value interface{}
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return "", nil, report, fmt.Errorf("output is not valid JSON: %w", err)
+	}
+
+	if p.selectProgram != nil {
+		selected, err := p.selectFields(value)
+		if err != nil {
+			return "", nil, report, err
+		}
+		value = selected
+	}
+
+	text, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", nil, report, fmt.Errorf("failed to encode the output: %w", err)
+	}
+
+	report.OriginalBytes = len(text)
+	report.OriginalLines = countLines(string(text))
+
+	var object map[string]interface{}
+	if p.schema != nil {
+		var ok bool
+		if object, ok = value.(map[string]interface{}); !ok {
+			return "", nil, report, fmt.Errorf("output is not a JSON object")
+		}
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(text))
+		if err != nil {
+			return "", nil, report, fmt.Errorf("failed to decode the output: %w", err)
+		}
+		if err := p.schema.Validate(doc); err != nil {
+			return "", nil, report, fmt.Errorf("output does not match the schema: %s", strings.TrimSpace(err.Error()))
+		}
+	}
+
+	// the text of the result is in the configured format, when it can be converted
+	// (keeping the columns in the order of the keys in the output of the command)
+	result := string(text)
+	if p.config.Format != "" {
+		if converted, omitted, err := p.formatValue(value, jsonKeysOrder([]byte(output))); err == nil {
+			result = converted
+			report.Format = p.config.Format
+			report.RowsOmitted = omitted
+			report.Truncated = omitted > 0
+		}
+	}
+
+	result = p.truncate(result, &report)
+
+	report.Bytes = len(result)
+	report.Lines = countLines(result)
+	return result, object, report, nil
+}
+
+// selectFields evaluates the `select` expression on the JSON output of the command
+func (p *OutputProcessor) selectFields(output interface{}) (interface{}, error) {
+	val, _, err := p.selectProgram.Eval(map[string]interface{}{OutputVariable: output})
+	if err != nil {
+		return nil, fmt.Errorf("select expression evaluation error: %w", err)
+	}
+
+	// convert the CEL value back to plain JSON values (maps, lists, numbers...)
+	native, err := val.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, fmt.Errorf("select expression result cannot be converted to JSON: %w", err)
+	}
+	return native.(*structpb.Value).AsInterface(), nil
+}
@@ -0,0 +1,360 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputProcessor(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        OutputConfig
+		output        string
+		expected      string
+		wantTruncated bool
+		wantFiltered  int
+	}{
+		{
+			name:     "no processing",
+			output:   "line 1\nline 2",
+			expected: "line 1\nline 2",
+		},
+		{
+			name:     "strip ANSI",
+			config:   OutputConfig{StripANSI: true},
+			output:   "\x1b[31mred\x1b[0m and \x1b]0;title\x07plain",
+			expected: "red and plain",
+		},
+		{
+			name:         "include and exclude",
+			config:       OutputConfig{Include: []string{"ERROR|WARN"}, Exclude: []string{"ignored"}},
+			output:       "INFO start\nERROR failed\nWARN ignored\nWARN slow",
+			expected:     "ERROR failed\nWARN slow",
+			wantFiltered: 2,
+		},
+		{
+			name:     "collapse whitespace",
+			config:   OutputConfig{CollapseWhitespace: true},
+			output:   "a    b\t\tc   \n\n\n\nd",
+			expected: "a b c\n\nd",
+		},
+		{
+			name:          "max lines keeping the head",
+			config:        OutputConfig{MaxLines: 2},
+			output:        "1\n2\n3\n4\n5",
+			expected:      "1\n2\n... [3 lines omitted] ...",
+			wantTruncated: true,
+		},
+		{
+			name:          "max lines keeping the tail",
+			config:        OutputConfig{MaxLines: 2, Keep: KeepTail},
+			output:        "1\n2\n3\n4\n5",
+			expected:      "... [3 lines omitted] ...\n4\n5",
+			wantTruncated: true,
+		},
+		{
+			name:          "max lines keeping both",
+			config:        OutputConfig{MaxLines: 3, Keep: KeepBoth},
+			output:        "1\n2\n3\n4\n5\n6",
+			expected:      "1\n2\n... [3 lines omitted] ...\n6",
+			wantTruncated: true,
+		},
+		{
+			name:     "max lines not exceeded",
+			config:   OutputConfig{MaxLines: 5},
+			output:   "1\n2\n3",
+			expected: "1\n2\n3",
+		},
+		{
+			name:          "max bytes keeping the head",
+			config:        OutputConfig{MaxBytes: 4},
+			output:        "0123456789",
+			expected:      "0123\n... [6 bytes omitted] ...",
+			wantTruncated: true,
+		},
+		{
+			name:          "max bytes keeping the tail",
+			config:        OutputConfig{MaxBytes: 4, Keep: KeepTail},
+			output:        "0123456789",
+			expected:      "... [6 bytes omitted] ...\n6789",
+			wantTruncated: true,
+		},
+		{
+			name:          "max bytes keeping both",
+			config:        OutputConfig{MaxBytes: 4, Keep: KeepBoth},
+			output:        "0123456789",
+			expected:      "01\n... [6 bytes omitted] ...\n89",
+			wantTruncated: true,
+		},
+		{
+			name:          "max bytes does not break characters",
+			config:        OutputConfig{MaxBytes: 3},
+			output:        "ñandú",
+			expected:      "ña\n... [4 bytes omitted] ...",
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, err := NewOutputProcessor(tt.config)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			result, report := processor.Process(tt.output)
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+			if report.Truncated != tt.wantTruncated {
+				t.Errorf("Expected truncated=%v, got %v", tt.wantTruncated, report.Truncated)
+			}
+			if report.FilteredLines != tt.wantFiltered {
+				t.Errorf("Expected %d filtered lines, got %d", tt.wantFiltered, report.FilteredLines)
+			}
+			if report.OriginalBytes != len(tt.output) || report.Bytes != len(result) {
+				t.Errorf("Unexpected sizes in report: %+v", report)
+			}
+			if report.Modified() != (tt.output != tt.expected) {
+				t.Errorf("Expected modified=%v, got %v", tt.output != tt.expected, report.Modified())
+			}
+		})
+	}
+}
+
+func TestNewOutputProcessorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  OutputConfig
+		wantErr string
+	}{
+		{"invalid include", OutputConfig{Include: []string{"("}}, "invalid include pattern"},
+		{"invalid exclude", OutputConfig{Exclude: []string{"[a-"}}, "invalid exclude pattern"},
+		{"negative max lines", OutputConfig{MaxLines: -1}, "max_lines"},
+		{"negative max bytes", OutputConfig{MaxBytes: -1}, "max_bytes"},
+		{"invalid keep", OutputConfig{Keep: "middle"}, "invalid keep value"},
+		{"invalid format", OutputConfig{Format: "xml"}, "invalid format"},
+		{"negative max rows", OutputConfig{Format: FormatTable, MaxRows: -1}, "max_rows"},
+		{"columns without format", OutputConfig{Columns: []string{"name"}}, "require a format"},
+		{"invalid select", OutputConfig{Select: "output.items.map("}, "invalid select expression"},
+		{"schema not an object", OutputConfig{Schema: map[string]interface{}{"type": "array"}}, "must be of type 'object'"},
+		{"invalid schema", OutputConfig{Schema: map[string]interface{}{"type": "object", "required": "name"}}, "invalid output schema"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewOutputProcessor(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestOutputProcessorExtractJSON(t *testing.T) {
+	podSchema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"name", "phase"},
+		"properties": map[string]interface{}{
+			"name":  map[string]interface{}{"type": "string"},
+			"phase": map[string]interface{}{"type": "string"},
+		},
+	}
+	pod := `{"metadata": {"name": "web", "labels": {"app": "web"}}, "status": {"phase": "Running"}}`
+
+	tests := []struct {
+		name           string
+		config         OutputConfig
+		output         string
+		wantText       string
+		wantStructured map[string]interface{}
+		wantReport     OutputReport
+		wantErr        string
+	}{
+		{
+			name:     "select without schema",
+			config:   OutputConfig{Select: "output.metadata.labels"},
+			output:   pod,
+			wantText: "{\n  \"app\": \"web\"\n}",
+		},
+		{
+			name:     "select a list",
+			config:   OutputConfig{Select: "output.items.map(i, i.name)"},
+			output:   `{"items": [{"name": "a"}, {"name": "b"}]}`,
+			wantText: "[\n  \"a\",\n  \"b\"\n]",
+		},
+		{
+			name:           "select with schema",
+			config:         OutputConfig{Select: `{"name": output.metadata.name, "phase": output.status.phase}`, Schema: podSchema},
+			output:         pod,
+			wantText:       "{\n  \"name\": \"web\",\n  \"phase\": \"Running\"\n}",
+			wantStructured: map[string]interface{}{"name": "web", "phase": "Running"},
+		},
+		{
+			name:           "schema without select",
+			config:         OutputConfig{Schema: map[string]interface{}{"type": "object"}},
+			output:         `{"count": 3}`,
+			wantText:       "{\n  \"count\": 3\n}",
+			wantStructured: map[string]interface{}{"count": 3.0},
+		},
+		{
+			name:       "select in a format",
+			config:     OutputConfig{Select: "output.items", Format: FormatTable},
+			output:     `{"items": [{"name": "a", "size": 1}]}`,
+			wantText:   "| name | size |\n| --- | --- |\n| a | 1 |",
+			wantReport: OutputReport{Format: FormatTable},
+		},
+		{
+			name:       "select in a format, with the order of the keys and the rows limit",
+			config:     OutputConfig{Select: "output.items", Format: FormatCSV, MaxRows: 1},
+			output:     `{"items": [{"size": 1, "name": "a"}, {"size": 2, "name": "b"}]}`,
+			wantText:   "size,name\n1,a\n... [1 rows omitted] ...",
+			wantReport: OutputReport{Format: FormatCSV, RowsOmitted: 1, Truncated: true},
+		},
+		{
+			name:    "not JSON",
+			config:  OutputConfig{Select: "output.name"},
+			output:  "error: not found",
+			wantErr: "not valid JSON",
+		},
+		{
+			name:    "missing field in select",
+			config:  OutputConfig{Select: "output.spec.replicas"},
+			output:  pod,
+			wantErr: "select expression evaluation error",
+		},
+		{
+			name:    "output not matching the schema",
+			config:  OutputConfig{Schema: podSchema},
+			output:  pod,
+			wantErr: "does not match the schema",
+		},
+		{
+			name:    "output not an object",
+			config:  OutputConfig{Schema: map[string]interface{}{"type": "object"}},
+			output:  `[1, 2]`,
+			wantErr: "not a JSON object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, err := NewOutputProcessor(tt.config)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !processor.ExtractsJSON() {
+				t.Fatalf("Expected the processor to extract JSON")
+			}
+
+			text, structured, report, err := processor.ExtractJSON(tt.output)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if text != tt.wantText {
+				t.Errorf("Expected text %q, got %q", tt.wantText, text)
+			}
+			if !reflect.DeepEqual(structured, tt.wantStructured) {
+				t.Errorf("Expected structured content %v, got %v", tt.wantStructured, structured)
+			}
+			if report.Format != tt.wantReport.Format || report.RowsOmitted != tt.wantReport.RowsOmitted ||
+				report.Truncated != tt.wantReport.Truncated {
+				t.Errorf("Expected report %+v, got %+v", tt.wantReport, report)
+			}
+		})
+	}
+}
+
+func TestOutputProcessorFormat(t *testing.T) {
+	pods := `[{"name": "web", "status": "Running", "restarts": 0}, {"name": "db", "status": "Pending", "restarts": 2}]`
+	kubectl := "NAME   READY   STATUS    RESTARTS\nweb    1/1     Running   0\ndb     0/1     Pending   2"
+	df := "Filesystem  Size  Used Avail Use% Mounted on\n/dev/sda1   100G   40G   60G  40% /\ntmpfs       1G     0     1G   0% /dev/shm"
+
+	tests := []struct {
+		name        string
+		config      OutputConfig
+		output      string
+		expected    string
+		wantOmitted int
+	}{
+		{
+			name:     "JSON to table",
+			config:   OutputConfig{Format: FormatTable},
+			output:   pods,
+			expected: "| name | status | restarts |\n| --- | --- | --- |\n| web | Running | 0 |\n| db | Pending | 2 |",
+		},
+		{
+			name:     "JSON to compact JSON with columns",
+			config:   OutputConfig{Format: FormatJSONCompact, Columns: []string{"status", "name"}},
+			output:   pods,
+			expected: `[{"status":"Running","name":"web"},{"status":"Pending","name":"db"}]`,
+		},
+		{
+			name:        "JSON to YAML with max rows",
+			config:      OutputConfig{Format: FormatYAML, MaxRows: 1},
+			output:      pods,
+			expected:    "- name: web\n  status: Running\n  restarts: 0\n... [1 rows omitted] ...",
+			wantOmitted: 1,
+		},
+		{
+			name:     "JSON object to YAML",
+			config:   OutputConfig{Format: FormatYAML},
+			output:   `{"b": 1, "a": {"c": true}}`,
+			expected: "a:\n  c: true\nb: 1",
+		},
+		{
+			name:     "columns to CSV",
+			config:   OutputConfig{Format: FormatCSV, Columns: []string{"name", "status"}},
+			output:   kubectl,
+			expected: "NAME,STATUS\nweb,Running\ndb,Pending",
+		},
+		{
+			name:     "columns with spaces in the header",
+			config:   OutputConfig{Format: FormatTable, Columns: []string{"Filesystem", "Mounted on"}},
+			output:   df,
+			expected: "| Filesystem | Mounted on |\n| --- | --- |\n| /dev/sda1 | / |\n| tmpfs | /dev/shm |",
+		},
+		{
+			name:     "CSV to table",
+			config:   OutputConfig{Format: FormatTable},
+			output:   "name,description\nweb,\"front, end\"\ndb,a|b",
+			expected: "| name | description |\n| --- | --- |\n| web | front, end |\n| db | a\\|b |",
+		},
+		{
+			name:     "TSV to compact JSON",
+			config:   OutputConfig{Format: FormatJSONCompact},
+			output:   "name\tport\nweb\t80",
+			expected: `[{"name":"web","port":"80"}]`,
+		},
+		{
+			name:     "fall back to the text",
+			config:   OutputConfig{Format: FormatTable},
+			output:   "no resources found",
+			expected: "no resources found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, err := NewOutputProcessor(tt.config)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			result, report := processor.Process(tt.output)
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+			if report.RowsOmitted != tt.wantOmitted || report.Truncated != (tt.wantOmitted > 0) {
+				t.Errorf("Unexpected report: %+v", report)
+			}
+		})
+	}
+}
@@ -1,9 +1,35 @@
 package common
 
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultPromptName is the name of the prompt built from the top-level system and user prompts
+const DefaultPromptName = "default"
+
 // PromptsConfig holds prompt configuration with system and user prompts
 type PromptsConfig struct {
-	System []string `yaml:"system,omitempty"` // System prompts
-	User   []string `yaml:"user,omitempty"`   // User prompts
+	System []string       `yaml:"system,omitempty"` // System prompts
+	User   []string       `yaml:"user,omitempty"`   // User prompts
+	Named  []PromptConfig `yaml:"named,omitempty"`  // Named prompts, with arguments
+}
+
+// PromptConfig is a named prompt that clients can get with some arguments.
+// The system and user prompts are templates that can use the arguments.
+type PromptConfig struct {
+	Name        string                 `yaml:"name"`                  // Name of the prompt
+	Description string                 `yaml:"description,omitempty"` // Description shown to clients
+	Arguments   []PromptArgumentConfig `yaml:"arguments,omitempty"`   // Arguments accepted by the prompt
+	System      []string               `yaml:"system,omitempty"`      // System prompts (templates)
+	User        []string               `yaml:"user,omitempty"`        // User prompts (templates)
+}
+
+// PromptArgumentConfig is an argument of a named prompt
+type PromptArgumentConfig struct {
+	Name        string `yaml:"name"`                  // Name of the argument
+	Description string `yaml:"description,omitempty"` // Description shown to clients
+	Required    bool   `yaml:"required,omitempty"`    // Whether the argument is required
 }
 
 // GetSystemPrompts returns all system prompts joined with newlines
@@ -47,3 +73,97 @@ func (p PromptsConfig) HasSystemPrompts() bool {
 func (p PromptsConfig) HasUserPrompts() bool {
 	return len(p.User) > 0
 }
+
+// GetPrompts returns all the prompts: the default prompt (when there are top-level
+// system or user prompts) followed by the named prompts.
+func (p PromptsConfig) GetPrompts() []PromptConfig {
+	var prompts []PromptConfig
+	if p.HasSystemPrompts() || p.HasUserPrompts() {
+		prompts = append(prompts, PromptConfig{
+			Name:        DefaultPromptName,
+			Description: "Default prompt for using the tools of this server",
+			System:      p.System,
+			User:        p.User,
+		})
+	}
+	return append(prompts, p.Named...)
+}
+
+// Validate checks the prompts have unique names, valid arguments and valid templates.
+func (p PromptsConfig) Validate() error {
+	names := map[string]bool{}
+	for _, prompt := range p.GetPrompts() {
+		if prompt.Name == "" {
+			return fmt.Errorf("prompt without a name")
+		}
+		if names[prompt.Name] {
+			return fmt.Errorf("duplicate prompt name '%s'", prompt.Name)
+		}
+		names[prompt.Name] = true
+
+		if len(prompt.System) == 0 && len(prompt.User) == 0 {
+			return fmt.Errorf("prompt '%s' has no system or user prompts", prompt.Name)
+		}
+
+		args := map[string]bool{}
+		for _, arg := range prompt.Arguments {
+			if arg.Name == "" {
+				return fmt.Errorf("prompt '%s' has an argument without a name", prompt.Name)
+			}
+			if args[arg.Name] {
+				return fmt.Errorf("prompt '%s' has a duplicate argument '%s'", prompt.Name, arg.Name)
+			}
+			args[arg.Name] = true
+		}
+
+		// check the templates by rendering them without arguments
+		for _, text := range append(append([]string{}, prompt.System...), prompt.User...) {
+			if _, err := ProcessTemplate(text, map[string]interface{}{}); err != nil {
+				return fmt.Errorf("invalid template in prompt '%s': %w", prompt.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Render processes the system and user templates of the prompt with the given arguments.
+//
+// Parameters:
+//   - args: Map of argument names to their values
+//
+// Returns:
+//   - The system prompts joined with newlines
+//   - The user prompts joined with newlines
+//   - An error if some required argument is missing or some template fails
+func (p PromptConfig) Render(args map[string]string) (string, string, error) {
+	values := make(map[string]interface{}, len(p.Arguments))
+	for _, arg := range p.Arguments {
+		value, exists := args[arg.Name]
+		if !exists && arg.Required {
+			return "", "", fmt.Errorf("required argument missing: %s", arg.Name)
+		}
+		values[arg.Name] = value
+	}
+
+	render := func(list []string) (string, error) {
+		rendered := make([]string, 0, len(list))
+		for _, text := range list {
+			res, err := ProcessTemplate(text, values)
+			if err != nil {
+				return "", fmt.Errorf("error processing prompt template: %w", err)
+			}
+			rendered = append(rendered, res)
+		}
+		return strings.Join(rendered, "\n"), nil
+	}
+
+	system, err := render(p.System)
+	if err != nil {
+		return "", "", err
+	}
+	user, err := render(p.User)
+	if err != nil {
+		return "", "", err
+	}
+	return system, user, nil
+}
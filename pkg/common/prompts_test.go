@@ -0,0 +1,138 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPromptsConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		prompts PromptsConfig
+		wantErr string
+	}{
+		{
+			name:    "empty",
+			prompts: PromptsConfig{},
+		},
+		{
+			name: "default and named prompts",
+			prompts: PromptsConfig{
+				System: []string{"You are a helpful assistant"},
+				Named: []PromptConfig{
+					{
+						Name:      "debug_pod",
+						Arguments: []PromptArgumentConfig{{Name: "pod", Required: true}},
+						User:      []string{"Debug the pod {{ .pod }}"},
+					},
+				},
+			},
+		},
+		{
+			name:    "named prompt without a name",
+			prompts: PromptsConfig{Named: []PromptConfig{{User: []string{"hello"}}}},
+			wantErr: "prompt without a name",
+		},
+		{
+			name: "duplicate name with the default prompt",
+			prompts: PromptsConfig{
+				User:  []string{"hello"},
+				Named: []PromptConfig{{Name: DefaultPromptName, User: []string{"hello"}}},
+			},
+			wantErr: "duplicate prompt name 'default'",
+		},
+		{
+			name:    "prompt without text",
+			prompts: PromptsConfig{Named: []PromptConfig{{Name: "empty"}}},
+			wantErr: "prompt 'empty' has no system or user prompts",
+		},
+		{
+			name: "duplicate argument",
+			prompts: PromptsConfig{Named: []PromptConfig{{
+				Name:      "dup",
+				Arguments: []PromptArgumentConfig{{Name: "a"}, {Name: "a"}},
+				User:      []string{"{{ .a }}"},
+			}}},
+			wantErr: "prompt 'dup' has a duplicate argument 'a'",
+		},
+		{
+			name:    "invalid template",
+			prompts: PromptsConfig{Named: []PromptConfig{{Name: "bad", User: []string{"{{ .a "}}}},
+			wantErr: "invalid template in prompt 'bad'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.prompts.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPromptConfig_Render(t *testing.T) {
+	prompt := PromptConfig{
+		Name: "debug_pod",
+		Arguments: []PromptArgumentConfig{
+			{Name: "pod", Required: true},
+			{Name: "namespace"},
+		},
+		System: []string{"You are a Kubernetes expert.", "Only use read-only tools."},
+		User:   []string{"Debug the pod {{ .pod }}{{ if .namespace }} in {{ .namespace }}{{ end }}."},
+	}
+
+	tests := []struct {
+		name       string
+		args       map[string]string
+		wantSystem string
+		wantUser   string
+		wantErr    string
+	}{
+		{
+			name:       "all arguments",
+			args:       map[string]string{"pod": "web-1", "namespace": "prod"},
+			wantSystem: "You are a Kubernetes expert.\nOnly use read-only tools.",
+			wantUser:   "Debug the pod web-1 in prod.",
+		},
+		{
+			name:       "optional argument missing",
+			args:       map[string]string{"pod": "web-1"},
+			wantSystem: "You are a Kubernetes expert.\nOnly use read-only tools.",
+			wantUser:   "Debug the pod web-1.",
+		},
+		{
+			name:    "required argument missing",
+			args:    map[string]string{"namespace": "prod"},
+			wantErr: "required argument missing: pod",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			system, user, err := prompt.Render(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Render() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render() unexpected error: %v", err)
+			}
+			if system != tt.wantSystem {
+				t.Errorf("Render() system = %q, want %q", system, tt.wantSystem)
+			}
+			if user != tt.wantUser {
+				t.Errorf("Render() user = %q, want %q", user, tt.wantUser)
+			}
+		})
+	}
+}
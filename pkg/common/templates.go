@@ -2,10 +2,10 @@ package common
 
 import (
 	"bytes"
+	"maps"
 	"strings"
 	"text/template"
-
-	"github.com/Masterminds/sprig/v3"
+	"text/template/parse"
 )
 
 // ProcessTemplate processes a template with the given arguments.
@@ -19,10 +19,25 @@ import (
 //   - The processed template string with substituted variables
 //   - An error if template processing fails
 func ProcessTemplate(text string, args map[string]interface{}) (string, error) {
+	return ProcessTemplateWithFuncs(text, args, nil)
+}
+
+// ProcessTemplateWithFuncs processes a template with the given arguments, like
+// ProcessTemplate, also allowing some of the restricted functions (ie, `env`).
+//
+// Parameters:
+//   - text: The template to process
+//   - args: Map of variable names to their values
+//   - allowedFuncs: The restricted functions allowed in the template
+//
+// Returns:
+//   - The processed template string with substituted variables
+//   - An error if template processing fails (ie, when it uses a function not allowed)
+func ProcessTemplateWithFuncs(text string, args map[string]interface{}, allowedFuncs []string) (string, error) {
 	// Create a template from the command string
 	tmpl, err := template.New("command").
 		Option("missingkey=zero").
-		Funcs(sprig.FuncMap()).
+		Funcs(templateFuncs(allowedFuncs...)).
 		Parse(text)
 	if err != nil {
 		return "", err
@@ -60,3 +75,208 @@ func ProcessTemplateListFlexible(list []string, args map[string]interface{}) []s
 	}
 	return res
 }
+
+// TemplateVariables returns the names of the variables used in a template
+// (ie, "namespace" for `{{ .namespace }}` or `{{ if $.all }}`).
+//
+// Parameters:
+//   - text: The template
+//
+// Returns:
+//   - The names of the variables used (without duplicates)
+//   - An error if the template cannot be parsed
+func TemplateVariables(text string) ([]string, error) {
+	uses, err := parseTemplateUses(text)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVariableNames(uses.variables, func(templateVariableUse) bool { return true }), nil
+}
+
+// UnquotedTemplateVariables returns the names of the variables whose values are
+// written in the output of a template without a shell quoting function (`shellquote`,
+// `shellquoteList` or `jsonarg`), like `{{ .name }}` or `{{ .name | upper }}`.
+// Variables only used in conditions (ie, `{{ if .all }}`) are not included.
+//
+// Parameters:
+//   - text: The template
+//
+// Returns:
+//   - The names of the variables written without quoting (without duplicates)
+//   - An error if the template cannot be parsed
+func UnquotedTemplateVariables(text string) ([]string, error) {
+	uses, err := parseTemplateUses(text)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVariableNames(uses.variables, func(use templateVariableUse) bool { return use.output && !use.quoted }), nil
+}
+
+// templateVariableUse is a use of a variable in a template
+type templateVariableUse struct {
+	name   string
+	output bool // whether the value is written in the output (and not only used in a condition)
+	quoted bool // whether the value is written through a shell quoting function
+}
+
+// uniqueVariableNames returns the names of the variables used, without duplicates
+func uniqueVariableNames(uses []templateVariableUse, keep func(templateVariableUse) bool) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, use := range uses {
+		if keep(use) && !seen[use.name] {
+			seen[use.name] = true
+			names = append(names, use.name)
+		}
+	}
+	return names
+}
+
+// templateUses are the variables and functions used in a template
+type templateUses struct {
+	variables []templateVariableUse
+	functions []string
+}
+
+// templateScope is the context of a node of a template while looking for the uses of
+// the variables: what the dot and the variables declared refer to
+type templateScope struct {
+	output bool              // whether the values are written in the output
+	quoted bool              // whether the values are written through a shell quoting function
+	dot    string            // the variable the dot refers to (empty for the root)
+	vars   map[string]string // the variables declared (ie, `$file`), by the variable they refer to
+}
+
+// parseTemplateUses returns all the uses of variables and functions in a template.
+// Inside `range` and `with`, the dot (and the variables declared) refer to the
+// variable of the pipeline (ie, `files` for `{{ range .files }}{{ . }}{{ end }}`).
+func parseTemplateUses(text string) (*templateUses, error) {
+	tmpl, err := template.New("command").Funcs(allTemplateFuncs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	uses := &templateUses{}
+	use := func(name string, scope templateScope) {
+		uses.variables = append(uses.variables, templateVariableUse{name: name, output: scope.output, quoted: scope.quoted})
+	}
+
+	var walk func(node parse.Node, scope templateScope)
+
+	// walkBranch walks an if/range/with, where range and with change the dot in their body
+	walkBranch := func(n *parse.BranchNode, scope templateScope, changesDot bool) {
+		walk(n.Pipe, templateScope{dot: scope.dot, vars: scope.vars})
+		body := scope
+		if changesDot {
+			body.dot = pipeVariable(n.Pipe, scope)
+			if n.Pipe != nil && len(n.Pipe.Decl) > 0 {
+				body.vars = maps.Clone(scope.vars)
+				if body.vars == nil {
+					body.vars = map[string]string{}
+				}
+				// the last variable declared is the element (the first one is the index in `range $i, $e := ...`)
+				for i, decl := range n.Pipe.Decl {
+					if i == len(n.Pipe.Decl)-1 && body.dot != "" {
+						body.vars[decl.Ident[0]] = body.dot
+					} else {
+						delete(body.vars, decl.Ident[0])
+					}
+				}
+			}
+		}
+		walk(n.List, body)
+		walk(n.ElseList, scope)
+	}
+
+	walk = func(node parse.Node, scope templateScope) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n != nil {
+				for _, child := range n.Nodes {
+					walk(child, scope)
+				}
+			}
+		case *parse.ActionNode:
+			scope.output, scope.quoted = true, isShellQuotingPipe(n.Pipe)
+			walk(n.Pipe, scope)
+		case *parse.IfNode:
+			walkBranch(&n.BranchNode, scope, false)
+		case *parse.RangeNode:
+			walkBranch(&n.BranchNode, scope, true)
+		case *parse.WithNode:
+			walkBranch(&n.BranchNode, scope, true)
+		case *parse.TemplateNode:
+			walk(n.Pipe, templateScope{dot: scope.dot, vars: scope.vars})
+		case *parse.PipeNode:
+			if n != nil {
+				for _, cmd := range n.Cmds {
+					walk(cmd, scope)
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, scope)
+			}
+		case *parse.ChainNode:
+			walk(n.Node, scope)
+		case *parse.DotNode:
+			if scope.dot != "" {
+				use(scope.dot, scope)
+			}
+		case *parse.FieldNode:
+			if scope.dot != "" {
+				use(scope.dot, scope) // a field of the value of the variable
+			} else {
+				use(n.Ident[0], scope)
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				use(n.Ident[1], scope)
+			} else if name, ok := scope.vars[n.Ident[0]]; ok {
+				use(name, scope)
+			}
+		case *parse.IdentifierNode:
+			uses.functions = append(uses.functions, n.Ident)
+		}
+	}
+	walk(tmpl.Root, templateScope{output: true})
+
+	return uses, nil
+}
+
+// pipeVariable returns the variable a pipeline evaluates to, when it is just a variable
+// (ie, `.files`, `$.files`, `.` or a variable declared), or an empty string otherwise
+func pipeVariable(pipe *parse.PipeNode, scope templateScope) string {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return ""
+	}
+	switch n := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		if scope.dot != "" {
+			return scope.dot
+		}
+		return n.Ident[0]
+	case *parse.DotNode:
+		return scope.dot
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			return n.Ident[1]
+		}
+		return scope.vars[n.Ident[0]]
+	}
+	return ""
+}
+
+// isShellQuotingPipe returns true if the result of a pipeline is produced by a
+// shell quoting function (ie, `{{ shellquote .name }}` or `{{ .name | shellquote }}`)
+func isShellQuotingPipe(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) == 0 {
+		return false
+	}
+	last := pipe.Cmds[len(pipe.Cmds)-1]
+	if len(last.Args) == 0 {
+		return false
+	}
+	ident, ok := last.Args[0].(*parse.IdentifierNode)
+	return ok && shellQuotingFuncs[ident.Ident]
+}
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// shellQuotingFuncs are the template functions that make a value safe for the shell
+var shellQuotingFuncs = map[string]bool{
+	"shellquote":     true,
+	"shellquoteList": true,
+	"jsonarg":        true,
+}
+
+// restrictedTemplateFuncs are the Sprig functions that are not available in the
+// templates unless they are explicitly allowed (in `mcp.run.template_functions`)
+var restrictedTemplateFuncs = []string{
+	// access to the environment of the server (ie, secrets) and the network
+	"env",
+	"expandenv",
+	"getHostByName",
+
+	// random values
+	"randAlphaNum",
+	"randAlpha",
+	"randAscii",
+	"randNumeric",
+	"randBytes",
+	"randInt",
+	"shuffle",
+	"uuidv4",
+
+	// crypto: keys, certificates, passwords and encryption
+	"bcrypt",
+	"htpasswd",
+	"genPrivateKey",
+	"derivePassword",
+	"buildCustomCert",
+	"genCA",
+	"genCAWithKey",
+	"genSelfSignedCert",
+	"genSelfSignedCertWithKey",
+	"genSignedCert",
+	"genSignedCertWithKey",
+	"encryptAES",
+	"decryptAES",
+}
+
+// allTemplateFuncs returns all the functions that can be used in templates: the
+// Sprig functions, plus some functions for building shell commands safely.
+func allTemplateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["shellquote"] = shellquote
+	funcs["shellquoteList"] = shellquoteList
+	funcs["jsonarg"] = jsonarg
+	funcs["pathclean"] = pathclean
+	return funcs
+}
+
+// templateFuncs returns the functions available in the templates: all of them
+// except the restricted ones that have not been explicitly allowed.
+func templateFuncs(allowed ...string) template.FuncMap {
+	funcs := allTemplateFuncs()
+	for _, name := range restrictedTemplateFuncs {
+		if !slices.Contains(allowed, name) {
+			delete(funcs, name)
+		}
+	}
+	return funcs
+}
+
+// ValidateTemplateFunctions checks that the functions allowed in the templates
+// (in `mcp.run.template_functions`) exist.
+//
+// Parameters:
+//   - names: The names of the functions
+//
+// Returns:
+//   - An error if some function does not exist
+func ValidateTemplateFunctions(names []string) error {
+	funcs := allTemplateFuncs()
+	for _, name := range names {
+		if _, exists := funcs[name]; !exists {
+			return fmt.Errorf("unknown template function '%s'", name)
+		}
+	}
+	return nil
+}
+
+// DisallowedTemplateFunctions returns the restricted functions used in a template
+// that are not in the list of allowed functions.
+//
+// Parameters:
+//   - text: The template
+//   - allowed: The restricted functions that are allowed
+//
+// Returns:
+//   - The names of the functions used but not allowed (without duplicates)
+//   - An error if the template cannot be parsed
+func DisallowedTemplateFunctions(text string, allowed []string) ([]string, error) {
+	uses, err := parseTemplateUses(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, name := range uses.functions {
+		if slices.Contains(restrictedTemplateFuncs, name) && !slices.Contains(allowed, name) && !slices.Contains(res, name) {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// shellquote quotes a value as a single argument for sh, with single quotes
+// (ie, `it's` is returned as `'it'"'"'s'`)
+func shellquote(value interface{}) string {
+	return "'" + strings.ReplaceAll(toTemplateString(value), "'", `'"'"'`) + "'"
+}
+
+// shellquoteList quotes all the elements of a list as arguments for sh,
+// separated by spaces (ie, for `ls {{ shellquoteList .files }}`)
+func shellquoteList(list interface{}) (string, error) {
+	var quoted []string
+	switch l := list.(type) {
+	case nil:
+		return "", nil
+	case []string:
+		for _, item := range l {
+			quoted = append(quoted, shellquote(item))
+		}
+	case []interface{}:
+		for _, item := range l {
+			quoted = append(quoted, shellquote(item))
+		}
+	default:
+		return "", fmt.Errorf("shellquoteList: expected a list, got %T", list)
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// jsonarg encodes a value as JSON, quoted as a single argument for sh
+// (ie, for `curl -d {{ jsonarg .payload }}`)
+func jsonarg(value interface{}) (string, error) {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("jsonarg: %w", err)
+	}
+	return shellquote(string(out)), nil
+}
+
+// pathclean returns the shortest path equivalent to the given one, removing
+// the duplicated separators and resolving the `.` and `..` elements
+func pathclean(value interface{}) string {
+	path := toTemplateString(value)
+	if path == "" {
+		return ""
+	}
+	return filepath.Clean(path)
+}
+
+// toTemplateString returns the text of a value in a template
+func toTemplateString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
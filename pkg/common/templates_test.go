@@ -0,0 +1,167 @@
+package common
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplateVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected []string
+	}{
+		{"no variables", "ls -l", nil},
+		{"simple", "kubectl get pods -n {{ .namespace }}", []string{"namespace"}},
+		{"conditionals and functions", "ls {{ if .all }}-a{{ end }} {{ .dir | quote }} {{ .dir }}", []string{"all", "dir"}},
+		{"root variable", "{{ range .files }}{{ $.prefix }}{{ . }}{{ end }}", []string{"files", "prefix"}},
+		{"else branch", "{{ if .a }}x{{ else }}{{ .b }}{{ end }}", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := TemplateVariables(tt.template)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(vars, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, vars)
+			}
+		})
+	}
+
+	if _, err := TemplateVariables("{{ .unclosed"); err == nil {
+		t.Errorf("Expected error for an invalid template")
+	}
+}
+
+func TestTemplateShellFuncs(t *testing.T) {
+	args := map[string]interface{}{
+		"text":    "it's $(id); `ls` *",
+		"files":   []interface{}{"a b", "c'd", 3},
+		"payload": map[string]interface{}{"name": "it's"},
+		"path":    "/var//log/../tmp/./x",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"shellquote", "{{ shellquote .text }}", `'it'"'"'s $(id); ` + "`ls`" + ` *'`},
+		{"shellquote in a pipe", "{{ .text | shellquote }}", `'it'"'"'s $(id); ` + "`ls`" + ` *'`},
+		{"shellquote empty", "{{ shellquote .missing }}", `''`},
+		{"shellquoteList", "{{ shellquoteList .files }}", `'a b' 'c'"'"'d' '3'`},
+		{"jsonarg", "{{ jsonarg .payload }}", `'{"name":"it'"'"'s"}'`},
+		{"pathclean", "{{ pathclean .path }}", "/var/tmp/x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ProcessTemplate(tt.template, args)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+
+	// the shell must receive the original values as single arguments
+	cmd, err := ProcessTemplate(`printf '%s|' {{ shellquote .text }} {{ shellquoteList .files }}`, args)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		t.Fatalf("Failed to run %q: %v", cmd, err)
+	}
+	if expected := "it's $(id); `ls` *|a b|c'd|3|"; strings.TrimSpace(string(out)) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(out))
+	}
+}
+
+func TestUnquotedTemplateVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected []string
+	}{
+		{"quoted", "ls {{ shellquote .dir }} {{ .file | shellquote }} {{ jsonarg .obj }}", nil},
+		{"unquoted", "ls {{ .dir }} {{ .file | upper }}", []string{"dir", "file"}},
+		{"quoted then modified", "ls {{ shellquote .dir | upper }}", []string{"dir"}},
+		{"only in conditions", "ls {{ if .all }}-a{{ end }}", nil},
+		{"inside conditions", "ls {{ if .all }}{{ .dir }}{{ end }}", []string{"dir"}},
+		{"quoted sub-pipeline", `{{ shellquote (printf "%s/%s" .dir .file) }}`, nil},
+		{"dot in range", "ls {{ range .files }}{{ . }} {{ end }}", []string{"files"}},
+		{"dot in range quoted", "ls {{ range .files }}{{ shellquote . }} {{ end }}", nil},
+		{"dot in with", "du {{ with .path }}{{ . }}{{ else }}{{ .dir }}{{ end }}", []string{"path", "dir"}},
+		{"field in range", "echo {{ range $.pods }}{{ .name }}{{ end }}", []string{"pods"}},
+		{"variable in range", "ls {{ range $i, $f := .files }}{{ $i }} {{ $f }}{{ end }}", []string{"files"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := UnquotedTemplateVariables(tt.template)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(vars, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, vars)
+			}
+		})
+	}
+}
+
+func TestRestrictedTemplateFuncs(t *testing.T) {
+	t.Setenv("MCPSHELL_TEST_VALUE", "secret")
+
+	if _, err := ProcessTemplate(`{{ env "MCPSHELL_TEST_VALUE" }}`, nil); err == nil {
+		t.Errorf("Expected error when using a restricted function")
+	}
+	if _, err := ProcessTemplateWithFuncs(`{{ uuidv4 }}`, nil, []string{"env"}); err == nil {
+		t.Errorf("Expected error when using a restricted function that is not allowed")
+	}
+
+	result, err := ProcessTemplateWithFuncs(`{{ env "MCPSHELL_TEST_VALUE" }}`, nil, []string{"env"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result != "secret" {
+		t.Errorf("Expected secret, got %s", result)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		allowed  []string
+		expected []string
+	}{
+		{"no restricted functions", "ls {{ .dir | upper }}", nil, nil},
+		{"restricted", `{{ env "HOME" }} {{ uuidv4 }} {{ env "USER" }}`, nil, []string{"env", "uuidv4"}},
+		{"allowed", `{{ env "HOME" }} {{ uuidv4 }}`, []string{"env"}, []string{"uuidv4"}},
+		{"in conditions", `{{ if .all }}{{ randAlpha 5 }}{{ end }}`, nil, []string{"randAlpha"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			funcs, err := DisallowedTemplateFunctions(tt.template, tt.allowed)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(funcs, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, funcs)
+			}
+		})
+	}
+
+	if err := ValidateTemplateFunctions([]string{"env", "uuidv4"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := ValidateTemplateFunctions([]string{"nonexistent"}); err == nil {
+		t.Errorf("Expected error for an unknown function")
+	}
+}
@@ -2,21 +2,89 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 )
 
+// Policies for commands that fail (ie, that exit with a code that is not a success code)
+const (
+	// OnErrorError returns an error result, with the output of the command attached (default)
+	OnErrorError = "error"
+
+	// OnErrorOutput returns the output of the command as a successful result
+	OnErrorOutput = "output"
+)
+
 // OutputConfig defines how tool output should be formatted before being returned.
 type OutputConfig struct {
 	// Prefix is a template string that gets prepended to the command output.
 	// It can use the same template variables as the command itself.
 	Prefix string `yaml:"prefix,omitempty"`
+
+	// OnError is the policy for commands that fail: "error" (default) or "output"
+	OnError string `yaml:"on_error,omitempty"`
+
+	// The output processing pipeline, applied in this order to the stdout of the command
+	// (see OutputProcessor)
+
+	// StripANSI removes the ANSI escape sequences (ie, colors)
+	StripANSI bool `yaml:"strip_ansi,omitempty"`
+
+	// Include keeps only the lines matching some of these regular expressions
+	Include []string `yaml:"include,omitempty"`
+
+	// Exclude removes the lines matching any of these regular expressions
+	Exclude []string `yaml:"exclude,omitempty"`
+
+	// CollapseWhitespace collapses runs of spaces and blank lines, and removes trailing spaces
+	CollapseWhitespace bool `yaml:"collapse_whitespace,omitempty"`
+
+	// MaxLines is the maximum number of lines returned (0 for no limit)
+	MaxLines int `yaml:"max_lines,omitempty"`
+
+	// MaxBytes is the maximum number of bytes returned (0 for no limit)
+	MaxBytes int `yaml:"max_bytes,omitempty"`
+
+	// Keep is the part of the output kept when it is truncated: "head" (default), "tail" or "both"
+	Keep string `yaml:"keep,omitempty"`
+
+	// Format converts tabular outputs (JSON arrays of objects, CSV/TSV or whitespace-aligned
+	// columns with a header) to "table" (markdown), "yaml", "json-compact" or "csv"
+	Format string `yaml:"format,omitempty"`
+
+	// Columns are the columns kept when converting the output (all of them when empty)
+	Columns []string `yaml:"columns,omitempty"`
+
+	// MaxRows is the maximum number of rows kept when converting the output (0 for no limit)
+	MaxRows int `yaml:"max_rows,omitempty"`
+
+	// Schema is the JSON schema of the output of the command (an object). When provided,
+	// the output is parsed as JSON and returned as structured content, and the schema is
+	// advertised as the output schema of the tool.
+	Schema map[string]interface{} `yaml:"schema,omitempty"`
+
+	// Select is a CEL expression for extracting the fields needed from the JSON
+	// output of the command (available in the `output` variable)
+	Select string `yaml:"select,omitempty"`
+}
+
+// Validate checks the output configuration is valid
+func (o OutputConfig) Validate() error {
+	switch o.OnError {
+	case "", OnErrorError, OnErrorOutput:
+	default:
+		return fmt.Errorf("invalid on_error policy '%s' (must be '%s' or '%s')", o.OnError, OnErrorError, OnErrorOutput)
+	}
+	_, err := NewOutputProcessor(o)
+	return err
 }
 
 // ParamConfig defines the configuration for a single parameter in a tool.
 type ParamConfig struct {
-	// Type specifies the parameter data type. Valid values: "string" (default), "number"/"integer", "boolean"
+	// Type specifies the parameter data type. Valid values: "string" (default), "number"/"integer",
+	// "boolean", "array" and "object"
 	Type string `yaml:"type,omitempty"`
 
 	// Description provides information about the parameter's purpose
@@ -27,6 +95,35 @@ type ParamConfig struct {
 
 	// Default specifies a default value to use when the parameter is not provided
 	Default interface{} `yaml:"default,omitempty"`
+
+	// Items describes the elements of the list when Type is "array".
+	// If not specified, elements are considered strings.
+	Items *ParamConfig `yaml:"items,omitempty"`
+
+	// Properties describes the nested fields when Type is "object"
+	Properties map[string]ParamConfig `yaml:"properties,omitempty"`
+
+	// Enum restricts the parameter to a fixed list of values
+	Enum []interface{} `yaml:"enum,omitempty"`
+
+	// Pattern is a regular expression that string values must match
+	Pattern string `yaml:"pattern,omitempty"`
+
+	// Minimum is the lowest value accepted for numeric parameters
+	Minimum *float64 `yaml:"minimum,omitempty"`
+
+	// Maximum is the highest value accepted for numeric parameters
+	Maximum *float64 `yaml:"maximum,omitempty"`
+
+	// MinLength is the minimum length of strings (or number of elements of arrays)
+	MinLength *int `yaml:"minLength,omitempty"`
+
+	// MaxLength is the maximum length of strings (or number of elements of arrays)
+	MaxLength *int `yaml:"maxLength,omitempty"`
+
+	// Env is the name of an environment variable where the argument is exported to the command
+	// (instead of, or in addition to, interpolating it in the command)
+	Env string `yaml:"env,omitempty"`
 }
 
 // LoggingConfig defines configuration options for application logging.
@@ -43,7 +140,7 @@ type LoggingConfig struct {
 //
 // Parameters:
 //   - value: The string value to convert
-//   - paramType: The parameter type ("string", "number", "integer", "boolean")
+//   - paramType: The parameter type ("string", "number", "integer", "boolean", "array", "object")
 //
 // Returns:
 //   - The converted value
@@ -84,7 +181,73 @@ func ConvertStringToType(value string, paramType string) (interface{}, error) {
 		default:
 			return nil, fmt.Errorf("failed to parse '%s' as boolean", value)
 		}
+	case "array":
+		// Accept a JSON array, or fall back to a comma-separated list of strings
+		trimmed := strings.TrimSpace(value)
+		if strings.HasPrefix(trimmed, "[") {
+			var listVal []interface{}
+			if err := json.Unmarshal([]byte(trimmed), &listVal); err != nil {
+				return nil, fmt.Errorf("failed to parse '%s' as array: %w", value, err)
+			}
+			return listVal, nil
+		}
+		listVal := []interface{}{}
+		if trimmed == "" {
+			return listVal, nil
+		}
+		for _, item := range strings.Split(trimmed, ",") {
+			listVal = append(listVal, strings.TrimSpace(item))
+		}
+		return listVal, nil
+	case "object":
+		// Objects can only be provided as JSON
+		var objVal map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &objVal); err != nil {
+			return nil, fmt.Errorf("failed to parse '%s' as object: %w", value, err)
+		}
+		return objVal, nil
 	default:
 		return nil, fmt.Errorf("unsupported parameter type: %s", paramType)
 	}
 }
+
+// ConvertStringToParam converts a string value to the type described by a parameter
+// configuration. It works like ConvertStringToType, but it also converts the elements
+// of arrays provided as comma-separated lists to the type declared in Items.
+//
+// Parameters:
+//   - value: The string value to convert
+//   - param: The parameter configuration
+//
+// Returns:
+//   - The converted value
+//   - An error if the conversion fails
+func ConvertStringToParam(value string, param ParamConfig) (interface{}, error) {
+	converted, err := ConvertStringToType(value, param.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	if param.Type != "array" || param.Items == nil {
+		return converted, nil
+	}
+
+	list, ok := converted.([]interface{})
+	if !ok {
+		return converted, nil
+	}
+
+	for i, item := range list {
+		strItem, ok := item.(string)
+		if !ok {
+			continue
+		}
+		convertedItem, err := ConvertStringToType(strItem, param.Items.Type)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert element %d: %w", i, err)
+		}
+		list[i] = convertedItem
+	}
+
+	return list, nil
+}
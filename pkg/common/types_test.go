@@ -1,6 +1,7 @@
 package common
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -24,6 +25,8 @@ func TestConvertStringToType(t *testing.T) {
 		{"invalid boolean", "not-a-boolean", "boolean", nil, true},
 		{"empty type defaults to string", "test", "", "test", false},
 		{"unsupported type", "test", "unknown", nil, true},
+		{"invalid object", "not-json", "object", nil, true},
+		{"invalid JSON array", "[1, 2", "array", nil, true},
 	}
 
 	for _, tt := range tests {
@@ -45,6 +48,65 @@ func TestConvertStringToType(t *testing.T) {
 	}
 }
 
+func TestConvertStringToTypeCollections(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		param    ParamConfig
+		expected interface{}
+	}{
+		{
+			name:     "comma-separated array",
+			value:    "web, db,cache",
+			param:    ParamConfig{Type: "array"},
+			expected: []interface{}{"web", "db", "cache"},
+		},
+		{
+			name:     "empty array",
+			value:    "",
+			param:    ParamConfig{Type: "array"},
+			expected: []interface{}{},
+		},
+		{
+			name:     "JSON array",
+			value:    `["a b", "c"]`,
+			param:    ParamConfig{Type: "array"},
+			expected: []interface{}{"a b", "c"},
+		},
+		{
+			name:     "comma-separated array with typed items",
+			value:    "80,443",
+			param:    ParamConfig{Type: "array", Items: &ParamConfig{Type: "integer"}},
+			expected: []interface{}{int64(80), int64(443)},
+		},
+		{
+			name:     "JSON object",
+			value:    `{"app": "web", "replicas": 2}`,
+			param:    ParamConfig{Type: "object"},
+			expected: map[string]interface{}{"app": "web", "replicas": 2.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ConvertStringToParam(tt.value, tt.param)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Expected %v (%T), got %v (%T)", tt.expected, tt.expected, result, result)
+			}
+		})
+	}
+
+	t.Run("invalid typed items", func(t *testing.T) {
+		_, err := ConvertStringToParam("80,http", ParamConfig{Type: "array", Items: &ParamConfig{Type: "integer"}})
+		if err == nil {
+			t.Errorf("Expected error but got none")
+		}
+	})
+}
+
 // Mock CommandHandler to test default parameter values
 type mockCommandHandler struct {
 	params map[string]ParamConfig
@@ -204,3 +266,24 @@ func TestParamConfigDefault(t *testing.T) {
 		})
 	}
 }
+
+func TestOutputConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  OutputConfig
+		wantErr bool
+	}{
+		{name: "default", output: OutputConfig{}},
+		{name: "error policy", output: OutputConfig{OnError: OnErrorError}},
+		{name: "output policy", output: OutputConfig{OnError: OnErrorOutput}},
+		{name: "invalid policy", output: OutputConfig{OnError: "ignore"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.output.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,525 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// NoCacheArgument is the reserved argument for bypassing the cache of the results of a tool
+const NoCacheArgument = "_nocache"
+
+// ArgumentProblem describes a single problem found in the arguments of a tool call.
+type ArgumentProblem struct {
+	// Parameter is the name of the parameter (with the path for nested values, like "names[1]")
+	Parameter string `json:"parameter"`
+
+	// Message explains what is wrong with the argument
+	Message string `json:"message"`
+}
+
+// String returns a human-readable description of the problem.
+func (p ArgumentProblem) String() string {
+	return fmt.Sprintf("parameter '%s': %s", p.Parameter, p.Message)
+}
+
+// ArgumentsError is returned when the arguments of a tool call are not valid.
+// It contains all the problems found, so they can be reported at once.
+type ArgumentsError struct {
+	Problems []ArgumentProblem
+}
+
+// Error returns a description of all the problems found in the arguments.
+func (e *ArgumentsError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, p.String())
+	}
+	return "invalid arguments:\n- " + strings.Join(lines, "\n- ")
+}
+
+// ParamValidator checks arguments against the declaration of a tool's parameters:
+// the parameter names and types, the required parameters and the declarative
+// validation rules (enum, pattern, minimum/maximum and minLength/maxLength).
+type ParamValidator struct {
+	params   map[string]ParamConfig
+	patterns map[string]*regexp.Regexp // compiled patterns, indexed by the original expression
+}
+
+// NewParamValidator creates a validator for the given parameters, compiling
+// all the patterns found in them (including nested items and properties).
+//
+// Parameters:
+//   - params: Map of parameter names to their configurations
+//
+// Returns:
+//   - A new ParamValidator
+//   - An error if some pattern is not a valid regular expression
+func NewParamValidator(params map[string]ParamConfig) (*ParamValidator, error) {
+	v := &ParamValidator{
+		params:   params,
+		patterns: map[string]*regexp.Regexp{},
+	}
+
+	for name, param := range params {
+		if err := v.compilePatterns(name, param); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// compilePatterns compiles the pattern of a parameter and of all its nested parameters.
+func (v *ParamValidator) compilePatterns(name string, param ParamConfig) error {
+	if param.Pattern != "" {
+		if _, exists := v.patterns[param.Pattern]; !exists {
+			re, err := regexp.Compile(param.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern for parameter '%s': %w", name, err)
+			}
+			v.patterns[param.Pattern] = re
+		}
+	}
+
+	if param.Items != nil {
+		if err := v.compilePatterns(name+"[]", *param.Items); err != nil {
+			return err
+		}
+	}
+
+	for propName, prop := range param.Properties {
+		if err := v.compilePatterns(name+"."+propName, prop); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateArguments checks the arguments of a tool call, after defaults have been applied.
+// It rejects undeclared parameters, coerces values to the declared types when
+// there is no loss of information (ie, "5" for a number, 3.0 for an integer) and
+// checks the required parameters and the validation rules. Null values are handled
+// like parameters not provided.
+//
+// Parameters:
+//   - args: Map of argument names to their values
+//
+// Returns:
+//   - A new map with the coerced arguments
+//   - An *ArgumentsError with all the problems found, or nil if the arguments are valid
+func (v *ParamValidator) ValidateArguments(args map[string]interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return args, nil
+	}
+
+	var problems []ArgumentProblem
+	result := make(map[string]interface{}, len(args))
+
+	for name, value := range args {
+		param, declared := v.params[name]
+		if !declared {
+			problems = append(problems, ArgumentProblem{name, "unknown parameter"})
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		coerced, coerceProblems := coerceValue(name, value, param)
+		problems = append(problems, coerceProblems...)
+		result[name] = coerced
+	}
+
+	for name, param := range v.params {
+		if args[name] == nil && param.Required {
+			problems = append(problems, ArgumentProblem{name, "required parameter missing"})
+		}
+	}
+
+	// Only check the validation rules when all the values have the right types
+	if len(problems) == 0 {
+		problems = v.validate(result)
+	}
+
+	if len(problems) > 0 {
+		sortProblems(problems)
+		return nil, &ArgumentsError{Problems: problems}
+	}
+
+	return result, nil
+}
+
+// Validate checks the provided arguments against the validation rules.
+// Parameters that are not present in args are ignored (required parameters
+// are checked in ValidateArguments).
+//
+// Parameters:
+//   - args: Map of argument names to their values
+//
+// Returns:
+//   - A sorted list of problems found, one per failed rule (empty if all the rules pass)
+func (v *ParamValidator) Validate(args map[string]interface{}) []ArgumentProblem {
+	if v == nil {
+		return nil
+	}
+
+	problems := v.validate(args)
+	sortProblems(problems)
+	return problems
+}
+
+// validate checks all the arguments against the validation rules.
+func (v *ParamValidator) validate(args map[string]interface{}) []ArgumentProblem {
+	var problems []ArgumentProblem
+	for name, param := range v.params {
+		value, exists := args[name]
+		if !exists || value == nil {
+			continue
+		}
+		problems = append(problems, v.validateValue(name, value, param)...)
+	}
+	return problems
+}
+
+// validateValue checks a single value (and its nested values) against the rules of a parameter.
+func (v *ParamValidator) validateValue(name string, value interface{}, param ParamConfig) []ArgumentProblem {
+	var problems []ArgumentProblem
+
+	if len(param.Enum) > 0 && !enumContains(param.Enum, value) {
+		problems = append(problems, ArgumentProblem{name, fmt.Sprintf("value %s is not one of the allowed values %s",
+			formatValue(value), formatEnum(param.Enum))})
+	}
+
+	switch typed := value.(type) {
+	case string:
+		if param.Pattern != "" {
+			if re := v.patterns[param.Pattern]; re != nil && !re.MatchString(typed) {
+				problems = append(problems, ArgumentProblem{name, fmt.Sprintf("value %s does not match the pattern '%s'",
+					formatValue(value), param.Pattern)})
+			}
+		}
+		problems = append(problems, checkLength(name, "length", utf8.RuneCountInString(typed), param)...)
+
+	case []interface{}:
+		problems = append(problems, checkLength(name, "number of elements", len(typed), param)...)
+		if param.Items != nil {
+			for i, item := range typed {
+				problems = append(problems, v.validateValue(fmt.Sprintf("%s[%d]", name, i), item, *param.Items)...)
+			}
+		}
+
+	case map[string]interface{}:
+		for propName, prop := range param.Properties {
+			if propValue, exists := typed[propName]; exists && propValue != nil {
+				problems = append(problems, v.validateValue(name+"."+propName, propValue, prop)...)
+			} else if prop.Required {
+				problems = append(problems, ArgumentProblem{name + "." + propName, "required field missing"})
+			}
+		}
+
+	default:
+		if number, ok := toFloat64(value); ok {
+			if param.Minimum != nil && number < *param.Minimum {
+				problems = append(problems, ArgumentProblem{name, fmt.Sprintf("value %v is lower than the minimum %v",
+					value, *param.Minimum)})
+			}
+			if param.Maximum != nil && number > *param.Maximum {
+				problems = append(problems, ArgumentProblem{name, fmt.Sprintf("value %v is greater than the maximum %v",
+					value, *param.Maximum)})
+			}
+		}
+	}
+
+	return problems
+}
+
+// coerceValue converts a value to the type declared for a parameter, when that
+// can be done without losing information. Values that cannot be converted are
+// reported as problems.
+func coerceValue(name string, value interface{}, param ParamConfig) (interface{}, []ArgumentProblem) {
+	paramType := param.Type
+	if paramType == "" {
+		paramType = "string"
+	}
+
+	mismatch := func() []ArgumentProblem {
+		return []ArgumentProblem{{name, fmt.Sprintf("expected %s, got %s", describeType(paramType), describeValue(value))}}
+	}
+
+	switch paramType {
+	case "string":
+		switch typed := value.(type) {
+		case string:
+			return typed, nil
+		case bool:
+			return strconv.FormatBool(typed), nil
+		default:
+			if number, ok := value.(json.Number); ok {
+				return number.String(), nil
+			}
+			if number, ok := toFloat64(value); ok {
+				// without exponents, so large integers (ie, 20240101) keep all their digits
+				return strconv.FormatFloat(number, 'f', -1, 64), nil
+			}
+			return nil, mismatch()
+		}
+
+	case "number":
+		if number, ok := toFloat64(value); ok {
+			return number, nil
+		}
+		if s, ok := value.(string); ok {
+			if number, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				return number, nil
+			}
+		}
+		return nil, mismatch()
+
+	case "integer":
+		if number, ok := toFloat64(value); ok {
+			if i, ok := value.(int64); ok {
+				return i, nil
+			}
+			if number != math.Trunc(number) || math.Abs(number) > 1<<53 {
+				return nil, mismatch()
+			}
+			return int64(number), nil
+		}
+		if s, ok := value.(string); ok {
+			if i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+				return i, nil
+			}
+		}
+		return nil, mismatch()
+
+	case "boolean":
+		switch typed := value.(type) {
+		case bool:
+			return typed, nil
+		case string:
+			if b, err := ConvertStringToType(typed, "boolean"); err == nil {
+				return b, nil
+			}
+		}
+		return nil, mismatch()
+
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			if s, isString := value.(string); isString && strings.HasPrefix(strings.TrimSpace(s), "[") {
+				ok = json.Unmarshal([]byte(s), &list) == nil
+			}
+		}
+		if !ok {
+			return nil, mismatch()
+		}
+		items := ParamConfig{Type: "string"}
+		if param.Items != nil {
+			items = *param.Items
+		}
+		var problems []ArgumentProblem
+		result := make([]interface{}, len(list))
+		for i, item := range list {
+			coerced, itemProblems := coerceValue(fmt.Sprintf("%s[%d]", name, i), item, items)
+			problems = append(problems, itemProblems...)
+			result[i] = coerced
+		}
+		return result, problems
+
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			if s, isString := value.(string); isString && strings.HasPrefix(strings.TrimSpace(s), "{") {
+				ok = json.Unmarshal([]byte(s), &obj) == nil
+			}
+		}
+		if !ok {
+			return nil, mismatch()
+		}
+		// objects without declared properties are free-form
+		if len(param.Properties) == 0 {
+			return obj, nil
+		}
+		var problems []ArgumentProblem
+		result := make(map[string]interface{}, len(obj))
+		for propName, propValue := range obj {
+			prop, declared := param.Properties[propName]
+			if !declared {
+				problems = append(problems, ArgumentProblem{name + "." + propName, "unknown field"})
+				continue
+			}
+			if propValue == nil {
+				continue
+			}
+			coerced, propProblems := coerceValue(name+"."+propName, propValue, prop)
+			problems = append(problems, propProblems...)
+			result[propName] = coerced
+		}
+		return result, problems
+
+	default:
+		return nil, []ArgumentProblem{{name, fmt.Sprintf("unsupported parameter type: %s", paramType)}}
+	}
+}
+
+// checkLength checks a length (of a string or a list) against the minLength/maxLength rules.
+func checkLength(name string, what string, length int, param ParamConfig) []ArgumentProblem {
+	var problems []ArgumentProblem
+	if param.MinLength != nil && length < *param.MinLength {
+		problems = append(problems, ArgumentProblem{name, fmt.Sprintf("%s %d is lower than the minimum length %d",
+			what, length, *param.MinLength)})
+	}
+	if param.MaxLength != nil && length > *param.MaxLength {
+		problems = append(problems, ArgumentProblem{name, fmt.Sprintf("%s %d is greater than the maximum length %d",
+			what, length, *param.MaxLength)})
+	}
+	return problems
+}
+
+// sortProblems sorts problems by parameter name and message, for stable error messages.
+func sortProblems(problems []ArgumentProblem) {
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].Parameter != problems[j].Parameter {
+			return problems[i].Parameter < problems[j].Parameter
+		}
+		return problems[i].Message < problems[j].Message
+	})
+}
+
+// enumContains checks if a value is in a list of allowed values.
+func enumContains(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if enumEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// enumEqual checks if a value is equal to an allowed value. Numbers are compared by
+// value, regardless of their Go type (also in lists and objects), and other values
+// are compared deeply (so lists and objects can be compared without panicking).
+func enumEqual(allowed, value interface{}) bool {
+	if number, ok := toFloat64(value); ok {
+		allowedNumber, ok := toFloat64(allowed)
+		return ok && allowedNumber == number
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		a, ok := allowed.([]interface{})
+		if !ok || len(a) != len(v) {
+			return false
+		}
+		for i := range v {
+			if !enumEqual(a[i], v[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		a, ok := allowed.(map[string]interface{})
+		if !ok || len(a) != len(v) {
+			return false
+		}
+		for key, item := range v {
+			allowedItem, exists := a[key]
+			if !exists || !enumEqual(allowedItem, item) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(allowed, value)
+	}
+}
+
+// toFloat64 converts any Go numeric value to a float64.
+func toFloat64(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// describeType returns the name of a parameter type for error messages.
+func describeType(paramType string) string {
+	switch paramType {
+	case "array", "integer", "object":
+		return "an " + paramType
+	default:
+		return "a " + paramType
+	}
+}
+
+// describeValue returns the JSON type and value of an argument for error messages.
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string " + formatValue(value)
+	case bool:
+		return fmt.Sprintf("boolean %v", value)
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		if _, ok := toFloat64(value); ok {
+			return fmt.Sprintf("number %v", value)
+		}
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// formatValue formats a value for error messages, quoting strings.
+func formatValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// formatEnum formats a list of allowed values for error messages.
+func formatEnum(enum []interface{}) string {
+	values := make([]string, 0, len(enum))
+	for _, e := range enum {
+		values = append(values, formatValue(e))
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
@@ -0,0 +1,287 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ptrFloat(f float64) *float64 { return &f }
+func ptrInt(i int) *int           { return &i }
+
+func TestParamValidator(t *testing.T) {
+	params := map[string]ParamConfig{
+		"namespace": {
+			Type:    "string",
+			Enum:    []interface{}{"default", "kube-system"},
+			Pattern: "^[a-z-]+$",
+		},
+		"name": {
+			Type:      "string",
+			Pattern:   "^[a-z0-9-]+$",
+			MinLength: ptrInt(2),
+			MaxLength: ptrInt(10),
+		},
+		"replicas": {
+			Type:    "integer",
+			Minimum: ptrFloat(1),
+			Maximum: ptrFloat(5),
+		},
+		"level": {
+			Type: "number",
+			Enum: []interface{}{1, 2, 3},
+		},
+		"names": {
+			Type:      "array",
+			MaxLength: ptrInt(2),
+			Items:     &ParamConfig{Type: "string", Pattern: "^[a-z]+$"},
+		},
+		"ports": {
+			Type: "array",
+			Enum: []interface{}{[]interface{}{80, 443}, []interface{}{8080}},
+		},
+		"selector": {
+			Type: "object",
+			Properties: map[string]ParamConfig{
+				"app": {Type: "string", Required: true, MaxLength: ptrInt(3)},
+			},
+		},
+	}
+
+	validator, err := NewParamValidator(params)
+	if err != nil {
+		t.Fatalf("Unexpected error creating validator: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     map[string]interface{}
+		problems []string
+	}{
+		{
+			name: "all valid",
+			args: map[string]interface{}{
+				"namespace": "default",
+				"name":      "web-1",
+				"replicas":  3.0,
+				"level":     2.0,
+				"names":     []interface{}{"web", "db"},
+				"ports":     []interface{}{80.0, 443.0},
+				"selector":  map[string]interface{}{"app": "web"},
+			},
+		},
+		{
+			name: "missing parameters are ignored",
+			args: map[string]interface{}{},
+		},
+		{
+			name:     "value not in enum",
+			args:     map[string]interface{}{"namespace": "prod"},
+			problems: []string{`parameter 'namespace': value "prod" is not one of the allowed values ["default", "kube-system"]`},
+		},
+		{
+			name:     "numeric enum compared by value",
+			args:     map[string]interface{}{"level": int64(4)},
+			problems: []string{`parameter 'level': value 4 is not one of the allowed values [1, 2, 3]`},
+		},
+		{
+			name:     "list not in a list enum",
+			args:     map[string]interface{}{"ports": []interface{}{80.0}},
+			problems: []string{`parameter 'ports': value [80] is not one of the allowed values [[80 443], [8080]]`},
+		},
+		{
+			name: "pattern and length",
+			args: map[string]interface{}{"name": "a;b"},
+			problems: []string{
+				`parameter 'name': value "a;b" does not match the pattern '^[a-z0-9-]+$'`,
+			},
+		},
+		{
+			name: "too long",
+			args: map[string]interface{}{"name": "a-very-long-name"},
+			problems: []string{
+				"parameter 'name': length 16 is greater than the maximum length 10",
+			},
+		},
+		{
+			name: "out of range",
+			args: map[string]interface{}{"replicas": 7.0},
+			problems: []string{
+				"parameter 'replicas': value 7 is greater than the maximum 5",
+			},
+		},
+		{
+			name: "array items and size",
+			args: map[string]interface{}{"names": []interface{}{"web", "DB", "cache"}},
+			problems: []string{
+				"parameter 'names': number of elements 3 is greater than the maximum length 2",
+				`parameter 'names[1]': value "DB" does not match the pattern '^[a-z]+$'`,
+			},
+		},
+		{
+			name: "nested object fields",
+			args: map[string]interface{}{"selector": map[string]interface{}{}},
+			problems: []string{
+				"parameter 'selector.app': required field missing",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var problems []string
+			for _, p := range validator.Validate(tt.args) {
+				problems = append(problems, p.String())
+			}
+			if strings.Join(problems, "\n") != strings.Join(tt.problems, "\n") {
+				t.Errorf("Validate() =\n%s\nwant:\n%s", strings.Join(problems, "\n"), strings.Join(tt.problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateArguments(t *testing.T) {
+	validator, err := NewParamValidator(map[string]ParamConfig{
+		"name":     {Type: "string", Required: true},
+		"replicas": {Type: "integer", Maximum: ptrFloat(5)},
+		"ratio":    {Type: "number"},
+		"force":    {Type: "boolean"},
+		"ports":    {Type: "array", Items: &ParamConfig{Type: "integer"}},
+		"labels":   {Type: "object"},
+		"selector": {
+			Type: "object",
+			Properties: map[string]ParamConfig{
+				"app": {Type: "string"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating validator: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     map[string]interface{}
+		want     map[string]interface{}
+		problems []string
+	}{
+		{
+			name: "values with the right types",
+			args: map[string]interface{}{"name": "web", "replicas": 3.0, "ratio": 0.5, "force": true},
+			want: map[string]interface{}{"name": "web", "replicas": int64(3), "ratio": 0.5, "force": true},
+		},
+		{
+			name: "coerced values",
+			args: map[string]interface{}{
+				"name":     42.0,
+				"replicas": "3",
+				"ratio":    "0.5",
+				"force":    "yes",
+				"ports":    "[80, 443]",
+				"labels":   `{"app": "web"}`,
+				"selector": map[string]interface{}{"app": true},
+			},
+			want: map[string]interface{}{
+				"name":     "42",
+				"replicas": int64(3),
+				"ratio":    0.5,
+				"force":    true,
+				"ports":    []interface{}{int64(80), int64(443)},
+				"labels":   map[string]interface{}{"app": "web"},
+				"selector": map[string]interface{}{"app": "true"},
+			},
+		},
+		{
+			name: "large numbers are coerced to strings without exponents",
+			args: map[string]interface{}{"name": 20240101.0, "selector": map[string]interface{}{"app": 1234567.0}},
+			want: map[string]interface{}{"name": "20240101", "selector": map[string]interface{}{"app": "1234567"}},
+		},
+		{
+			name: "null values are handled like missing values",
+			args: map[string]interface{}{"name": "web", "replicas": nil, "selector": map[string]interface{}{"app": nil}},
+			want: map[string]interface{}{"name": "web", "selector": map[string]interface{}{}},
+		},
+		{
+			name:     "null values for required parameters",
+			args:     map[string]interface{}{"name": nil},
+			problems: []string{"parameter 'name': required parameter missing"},
+		},
+		{
+			name: "all the problems are reported at once",
+			args: map[string]interface{}{
+				"replicas": 2.5,
+				"force":    "maybe",
+				"ports":    []interface{}{80.0, "http"},
+				"selector": map[string]interface{}{"tier": "web"},
+				"user":     "root",
+			},
+			problems: []string{
+				"parameter 'force': expected a boolean, got string \"maybe\"",
+				"parameter 'name': required parameter missing",
+				"parameter 'ports[1]': expected an integer, got string \"http\"",
+				"parameter 'replicas': expected an integer, got number 2.5",
+				"parameter 'selector.tier': unknown field",
+				"parameter 'user': unknown parameter",
+			},
+		},
+		{
+			name: "validation rules are checked after coercion",
+			args: map[string]interface{}{"name": "web", "replicas": "7"},
+			problems: []string{
+				"parameter 'replicas': value 7 is greater than the maximum 5",
+			},
+		},
+		{
+			name:     "objects are not coerced to strings",
+			args:     map[string]interface{}{"name": map[string]interface{}{}},
+			problems: []string{"parameter 'name': expected a string, got an object"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validator.ValidateArguments(tt.args)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ValidateArguments() = %#v, want %#v", got, tt.want)
+				}
+				return
+			}
+
+			var argsErr *ArgumentsError
+			if !errors.As(err, &argsErr) {
+				t.Fatalf("Expected an ArgumentsError, got: %v", err)
+			}
+			var problems []string
+			for _, p := range argsErr.Problems {
+				problems = append(problems, p.String())
+			}
+			if strings.Join(problems, "\n") != strings.Join(tt.problems, "\n") {
+				t.Errorf("ValidateArguments() problems =\n%s\nwant:\n%s", strings.Join(problems, "\n"), strings.Join(tt.problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestParamValidatorInvalidPattern(t *testing.T) {
+	_, err := NewParamValidator(map[string]ParamConfig{
+		"names": {Type: "array", Items: &ParamConfig{Type: "string", Pattern: "[a-z"}},
+	})
+	if err == nil {
+		t.Fatal("Expected error for invalid pattern")
+	}
+	if !strings.Contains(err.Error(), "names[]") {
+		t.Errorf("Expected error to mention the nested parameter, got: %v", err)
+	}
+}
+
+func TestParamValidatorReservedName(t *testing.T) {
+	// the name is only reserved in the tools with a cache (checked when creating them)
+	if _, err := NewParamValidator(map[string]ParamConfig{NoCacheArgument: {Type: "boolean"}}); err != nil {
+		t.Errorf("Unexpected error for the %s parameter: %v", NoCacheArgument, err)
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Periods of the quotas
+const (
+	QuotaPerSession = "session" // the usage of each client session
+	QuotaPerDay     = "day"     // the usage of all the clients during a day (UTC)
+)
+
+// MCPQuotaConfig is a quota of the calls to a tool (or to all the tools).
+type MCPQuotaConfig struct {
+	// MaxCalls is the maximum number of calls (0 for no limit)
+	MaxCalls int `yaml:"max_calls,omitempty"`
+
+	// MaxRuntime is the maximum cumulative runtime of the commands (ie, "10m")
+	MaxRuntime string `yaml:"max_runtime,omitempty"`
+
+	// Per is the period of the quota: "session" (default) or "day"
+	Per string `yaml:"per,omitempty"`
+}
+
+// IsZero returns true if no quota is configured
+func (q MCPQuotaConfig) IsZero() bool {
+	return q.MaxCalls == 0 && q.MaxRuntime == ""
+}
+
+// Validate checks the quota configuration.
+//
+// Returns:
+//   - An error if the quota is not valid
+func (q MCPQuotaConfig) Validate() error {
+	if q.MaxCalls < 0 {
+		return fmt.Errorf("quota max_calls must not be negative")
+	}
+	if _, err := q.GetMaxRuntime(); err != nil {
+		return err
+	}
+	switch q.Per {
+	case "", QuotaPerSession, QuotaPerDay:
+	default:
+		return fmt.Errorf("invalid quota period '%s' (must be '%s' or '%s')", q.Per, QuotaPerSession, QuotaPerDay)
+	}
+	if q.Per != "" && q.IsZero() {
+		return fmt.Errorf("quota per requires max_calls or max_runtime")
+	}
+	return nil
+}
+
+// GetMaxRuntime returns the maximum cumulative runtime of the commands.
+//
+// Returns:
+//   - The maximum runtime (zero for no limit)
+//   - An error if the maximum runtime is not valid
+func (q MCPQuotaConfig) GetMaxRuntime() (time.Duration, error) {
+	if q.MaxRuntime == "" {
+		return 0, nil
+	}
+	maxRuntime, err := time.ParseDuration(q.MaxRuntime)
+	if err != nil || maxRuntime <= 0 {
+		return 0, fmt.Errorf("invalid quota max_runtime '%s' (must be a positive duration, like '10m')", q.MaxRuntime)
+	}
+	return maxRuntime, nil
+}
+
+// GetPeriod returns the period of the quota ("session" when not configured)
+func (q MCPQuotaConfig) GetPeriod() string {
+	if q.Per == "" {
+		return QuotaPerSession
+	}
+	return q.Per
+}
+
+// rateLimitUnits are the names of the periods of the rate limits
+var rateLimitUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+}
+
+// ParseRateLimit parses a rate limit, as a number of calls per period (ie, "10/min",
+// "100/hour" or "5/30s").
+//
+// Parameters:
+//   - limit: The rate limit
+//
+// Returns:
+//   - The number of calls allowed in each period
+//   - The period
+//   - An error if the rate limit is not valid
+func ParseRateLimit(limit string) (int, time.Duration, error) {
+	invalid := fmt.Errorf("invalid rate_limit '%s' (must be calls per period, like '10/min')", limit)
+
+	calls, unit, found := strings.Cut(limit, "/")
+	if !found {
+		return 0, 0, invalid
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(calls))
+	if err != nil || count <= 0 {
+		return 0, 0, invalid
+	}
+
+	unit = strings.TrimSpace(unit)
+	period, ok := rateLimitUnits[unit]
+	if !ok {
+		if period, err = time.ParseDuration(unit); err != nil || period <= 0 {
+			return 0, 0, invalid
+		}
+	}
+	return count, period, nil
+}
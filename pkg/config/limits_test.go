@@ -0,0 +1,101 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		limit      string
+		wantCalls  int
+		wantPeriod time.Duration
+		wantErr    bool
+	}{
+		{limit: "10/min", wantCalls: 10, wantPeriod: time.Minute},
+		{limit: "1/s", wantCalls: 1, wantPeriod: time.Second},
+		{limit: "100 / hour", wantCalls: 100, wantPeriod: time.Hour},
+		{limit: "1000/day", wantCalls: 1000, wantPeriod: 24 * time.Hour},
+		{limit: "5/30s", wantCalls: 5, wantPeriod: 30 * time.Second},
+		{limit: "10", wantErr: true},
+		{limit: "0/min", wantErr: true},
+		{limit: "ten/min", wantErr: true},
+		{limit: "10/fortnight", wantErr: true},
+		{limit: "10/-1s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.limit, func(t *testing.T) {
+			calls, period, err := ParseRateLimit(tt.limit)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid rate_limit") {
+					t.Errorf("Expected an invalid rate_limit error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if calls != tt.wantCalls || period != tt.wantPeriod {
+				t.Errorf("Expected %d/%s, got %d/%s", tt.wantCalls, tt.wantPeriod, calls, period)
+			}
+		})
+	}
+}
+
+func TestMCPQuotaConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   MCPQuotaConfig
+		wantErr string
+	}{
+		{
+			name:  "no quota",
+			quota: MCPQuotaConfig{},
+		},
+		{
+			name:  "calls per session",
+			quota: MCPQuotaConfig{MaxCalls: 100},
+		},
+		{
+			name:  "runtime per day",
+			quota: MCPQuotaConfig{MaxRuntime: "1h", Per: QuotaPerDay},
+		},
+		{
+			name:    "negative max_calls",
+			quota:   MCPQuotaConfig{MaxCalls: -1},
+			wantErr: "max_calls must not be negative",
+		},
+		{
+			name:    "invalid max_runtime",
+			quota:   MCPQuotaConfig{MaxRuntime: "1 hour"},
+			wantErr: "invalid quota max_runtime '1 hour'",
+		},
+		{
+			name:    "invalid period",
+			quota:   MCPQuotaConfig{MaxCalls: 10, Per: "week"},
+			wantErr: "invalid quota period 'week'",
+		},
+		{
+			name:    "period without limits",
+			quota:   MCPQuotaConfig{Per: QuotaPerDay},
+			wantErr: "quota per requires max_calls or max_runtime",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.quota.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
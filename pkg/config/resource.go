@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/yosida95/uritemplate/v3"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// MCPResourceConfig represents a resource whose contents are the output of a command.
+// Resources have a fixed URI, or a URI template (RFC 6570) whose variables are
+// passed to the command as parameters (ie, `k8s://pods/{namespace}`).
+type MCPResourceConfig struct {
+	// URI is the URI of the resource (for resources with a fixed URI)
+	URI string `yaml:"uri,omitempty"`
+
+	// URITemplate is the URI template of the resource (for resource templates)
+	URITemplate string `yaml:"uri_template,omitempty"`
+
+	// Name is the name of the resource (shown to AI clients)
+	Name string `yaml:"name"`
+
+	// Description explains what the resource contains (shown to AI clients)
+	Description string `yaml:"description,omitempty"`
+
+	// MIMEType is the MIME type of the contents of the resource (ie, "application/json")
+	MIMEType string `yaml:"mime_type,omitempty"`
+
+	// Tags are labels for grouping resources (ie, for allowing them to some authentication tokens)
+	Tags []string `yaml:"tags,omitempty"`
+
+	// Params defines the type and validations of the variables of the URI template.
+	// Variables that are not declared here are required strings.
+	Params map[string]common.ParamConfig `yaml:"params,omitempty"`
+
+	// Constraints are expressions that limit when the resource can be read
+	Constraints []string `yaml:"constraints,omitempty"`
+
+	// Run specifies how to execute the command that produces the contents
+	Run MCPToolRunConfig `yaml:"run"`
+
+	// Output specifies how to format the output of the command
+	Output common.OutputConfig `yaml:"output,omitempty"`
+}
+
+// IsTemplate returns true if the resource is a resource template
+func (r MCPResourceConfig) IsTemplate() bool {
+	return r.URITemplate != ""
+}
+
+// GetURI returns the URI (or the URI template) that identifies the resource
+func (r MCPResourceConfig) GetURI() string {
+	if r.IsTemplate() {
+		return r.URITemplate
+	}
+	return r.URI
+}
+
+// Validate checks the resource has a name, a command and exactly one of a URI
+// or a URI template, and that the parameters match the variables of the template.
+//
+// Returns:
+//   - An error if the resource configuration is not valid
+func (r MCPResourceConfig) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("resource '%s' has no name", r.GetURI())
+	}
+	if (r.URI == "") == (r.URITemplate == "") {
+		return fmt.Errorf("resource '%s' must have either a uri or a uri_template", r.Name)
+	}
+	if err := r.Run.Validate(); err != nil {
+		return fmt.Errorf("%w for resource '%s'", err, r.Name)
+	}
+
+	if !r.IsTemplate() {
+		if len(r.Params) > 0 {
+			return fmt.Errorf("resource '%s' has params but no uri_template", r.Name)
+		}
+		return nil
+	}
+
+	tmpl, err := uritemplate.New(r.URITemplate)
+	if err != nil {
+		return fmt.Errorf("invalid uri_template for resource '%s': %w", r.Name, err)
+	}
+	vars := map[string]bool{}
+	for _, name := range tmpl.Varnames() {
+		vars[name] = true
+	}
+	for name := range r.Params {
+		if !vars[name] {
+			return fmt.Errorf("parameter '%s' of resource '%s' is not a variable of its uri_template", name, r.Name)
+		}
+	}
+	return nil
+}
+
+// GetParams returns the parameters of the resource: the variables of the URI template,
+// with the configuration in Params or as required strings when not declared.
+func (r MCPResourceConfig) GetParams() map[string]common.ParamConfig {
+	if !r.IsTemplate() {
+		return nil
+	}
+	tmpl, err := uritemplate.New(r.URITemplate)
+	if err != nil {
+		return nil
+	}
+
+	params := make(map[string]common.ParamConfig)
+	for _, name := range tmpl.Varnames() {
+		if param, ok := r.Params[name]; ok {
+			params[name] = param
+		} else {
+			params[name] = common.ParamConfig{Type: "string", Required: true}
+		}
+	}
+	return params
+}
+
+// GetTool returns a tool for running the command of the resource, so it can be
+// executed like any other tool (with the same runners, constraints and timeouts).
+//
+// Returns:
+//   - The tool for the resource
+//   - false if no runner meets its prerequisites
+func (r MCPResourceConfig) GetTool() (Tool, bool) {
+	tool := Tool{
+		MCPTool: mcp.Tool{Name: r.Name, Description: r.Description},
+		Config: MCPToolConfig{
+			Name:        r.Name,
+			Description: r.Description,
+			Params:      r.GetParams(),
+			Constraints: r.Constraints,
+			Run:         r.Run,
+			Output:      r.Output,
+		},
+	}
+	ok := tool.CheckToolRequirements()
+	return tool, ok
+}
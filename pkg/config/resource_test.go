@@ -0,0 +1,114 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+func TestMCPResourceConfig_Validate(t *testing.T) {
+	run := MCPToolRunConfig{Command: "echo hello"}
+
+	tests := []struct {
+		name     string
+		resource MCPResourceConfig
+		wantErr  string
+	}{
+		{
+			name:     "fixed uri",
+			resource: MCPResourceConfig{Name: "status", URI: "cluster://status", Run: run},
+		},
+		{
+			name: "uri template with params",
+			resource: MCPResourceConfig{
+				Name:        "pods",
+				URITemplate: "k8s://pods/{namespace}",
+				Params:      map[string]common.ParamConfig{"namespace": {Type: "string", Pattern: "^[a-z-]+$"}},
+				Run:         run,
+			},
+		},
+		{
+			name:     "no name",
+			resource: MCPResourceConfig{URI: "cluster://status", Run: run},
+			wantErr:  "has no name",
+		},
+		{
+			name:     "no uri",
+			resource: MCPResourceConfig{Name: "status", Run: run},
+			wantErr:  "must have either a uri or a uri_template",
+		},
+		{
+			name:     "uri and uri template",
+			resource: MCPResourceConfig{Name: "status", URI: "a://b", URITemplate: "a://{b}", Run: run},
+			wantErr:  "must have either a uri or a uri_template",
+		},
+		{
+			name:     "no command",
+			resource: MCPResourceConfig{Name: "status", URI: "cluster://status"},
+			wantErr:  "empty command template",
+		},
+		{
+			name:     "invalid uri template",
+			resource: MCPResourceConfig{Name: "pods", URITemplate: "k8s://pods/{namespace", Run: run},
+			wantErr:  "invalid uri_template",
+		},
+		{
+			name: "param not in the uri template",
+			resource: MCPResourceConfig{
+				Name:        "pods",
+				URITemplate: "k8s://pods/{namespace}",
+				Params:      map[string]common.ParamConfig{"pod": {Type: "string"}},
+				Run:         run,
+			},
+			wantErr: "parameter 'pod' of resource 'pods' is not a variable",
+		},
+		{
+			name: "params without uri template",
+			resource: MCPResourceConfig{
+				Name:   "status",
+				URI:    "cluster://status",
+				Params: map[string]common.ParamConfig{"pod": {Type: "string"}},
+				Run:    run,
+			},
+			wantErr: "has params but no uri_template",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.resource.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMCPResourceConfig_GetParams(t *testing.T) {
+	resource := MCPResourceConfig{
+		Name:        "logs",
+		URITemplate: "k8s://logs/{namespace}/{pod}",
+		Params:      map[string]common.ParamConfig{"namespace": {Type: "string", Description: "Namespace"}},
+	}
+
+	want := map[string]common.ParamConfig{
+		"namespace": {Type: "string", Description: "Namespace"},
+		"pod":       {Type: "string", Required: true},
+	}
+	if got := resource.GetParams(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetParams() = %v, want %v", got, want)
+	}
+
+	fixed := MCPResourceConfig{Name: "status", URI: "cluster://status"}
+	if got := fixed.GetParams(); got != nil {
+		t.Errorf("GetParams() = %v, want nil for resources with a fixed URI", got)
+	}
+}
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
 	"github.com/mark3labs/mcp-go/mcp"
 
 	"github.com/inercia/MCPShell/pkg/common"
@@ -140,6 +144,9 @@ func CreateMCPTool(config MCPToolConfig) mcp.Tool {
 			paramOptions = append(paramOptions, mcp.Required())
 		}
 
+		// Add the declarative validations, so clients can constrain themselves
+		paramOptions = append(paramOptions, withValidations(param))
+
 		// Add default value if specified
 		if param.Default != nil {
 			switch paramType {
@@ -159,6 +166,8 @@ func CreateMCPTool(config MCPToolConfig) mcp.Tool {
 				if boolVal, ok := param.Default.(bool); ok {
 					paramOptions = append(paramOptions, mcp.DefaultBool(boolVal))
 				}
+			case "array", "object":
+				paramOptions = append(paramOptions, withDefault(param.Default))
 			}
 		}
 
@@ -166,12 +175,155 @@ func CreateMCPTool(config MCPToolConfig) mcp.Tool {
 		switch paramType {
 		case "string":
 			options = append(options, mcp.WithString(name, paramOptions...))
-		case "number", "integer":
+		case "number":
+			options = append(options, mcp.WithNumber(name, paramOptions...))
+		case "integer":
+			// mcp-go has no integer properties, so the type of the number is replaced
+			paramOptions = append(paramOptions, withType("integer"))
 			options = append(options, mcp.WithNumber(name, paramOptions...))
 		case "boolean":
 			options = append(options, mcp.WithBoolean(name, paramOptions...))
+		case "array":
+			paramOptions = append(paramOptions, mcp.Items(paramJSONSchema(itemsOrString(param.Items))))
+			options = append(options, mcp.WithArray(name, paramOptions...))
+		case "object":
+			properties, required := propertiesJSONSchema(param.Properties)
+			paramOptions = append(paramOptions, mcp.Properties(properties))
+			options = append(options, mcp.WithObject(name, paramOptions...))
+			if len(required) > 0 {
+				options = append(options, withRequiredProperties(name, required))
+			}
+		}
+	}
+
+	// Add the reserved argument for bypassing the cache, for tools with cached results
+	if config.Run.Cache != nil {
+		options = append(options, mcp.WithBoolean(common.NoCacheArgument,
+			mcp.Description(fmt.Sprintf("Run the command again instead of returning a result cached in the last %s", config.Run.Cache.TTL))))
+	}
+
+	// Add the output schema, for tools returning structured content
+	if config.Output.Schema != nil {
+		if schema, err := json.Marshal(config.Output.Schema); err == nil {
+			options = append(options, mcp.WithRawOutputSchema(schema))
 		}
 	}
 
 	return mcp.NewTool(config.Name, options...)
 }
+
+// paramJSONSchema returns the JSON schema for a (possibly nested) parameter,
+// as used for the items of arrays and the properties of objects.
+func paramJSONSchema(param common.ParamConfig) map[string]interface{} {
+	paramType := param.Type
+	if paramType == "" {
+		paramType = "string"
+	}
+
+	schema := map[string]interface{}{
+		"type": paramType,
+	}
+	if param.Description != "" {
+		schema["description"] = param.Description
+	}
+	if param.Default != nil {
+		schema["default"] = param.Default
+	}
+	addValidationsSchema(schema, param)
+
+	switch paramType {
+	case "array":
+		schema["items"] = paramJSONSchema(itemsOrString(param.Items))
+	case "object":
+		properties, required := propertiesJSONSchema(param.Properties)
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+
+	return schema
+}
+
+// propertiesJSONSchema returns the JSON schema for the properties of an object,
+// together with the sorted list of required properties.
+func propertiesJSONSchema(properties map[string]common.ParamConfig) (map[string]interface{}, []string) {
+	result := make(map[string]interface{}, len(properties))
+	var required []string
+	for name, prop := range properties {
+		result[name] = paramJSONSchema(prop)
+		if prop.Required {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+	return result, required
+}
+
+// itemsOrString returns the items configuration of an array, defaulting to strings.
+func itemsOrString(items *common.ParamConfig) common.ParamConfig {
+	if items == nil {
+		return common.ParamConfig{Type: "string"}
+	}
+	return *items
+}
+
+// addValidationsSchema adds the declarative validations of a parameter to its JSON schema.
+// For arrays, the length limits are translated to limits in the number of items.
+func addValidationsSchema(schema map[string]interface{}, param common.ParamConfig) {
+	if len(param.Enum) > 0 {
+		schema["enum"] = param.Enum
+	}
+	if param.Pattern != "" {
+		schema["pattern"] = param.Pattern
+	}
+	if param.Minimum != nil {
+		schema["minimum"] = *param.Minimum
+	}
+	if param.Maximum != nil {
+		schema["maximum"] = *param.Maximum
+	}
+
+	minKey, maxKey := "minLength", "maxLength"
+	if param.Type == "array" {
+		minKey, maxKey = "minItems", "maxItems"
+	}
+	if param.MinLength != nil {
+		schema[minKey] = *param.MinLength
+	}
+	if param.MaxLength != nil {
+		schema[maxKey] = *param.MaxLength
+	}
+}
+
+// withValidations adds the declarative validations of a parameter to a property schema.
+func withValidations(param common.ParamConfig) mcp.PropertyOption {
+	return func(schema map[string]interface{}) {
+		addValidationsSchema(schema, param)
+	}
+}
+
+// withType sets the type in a property schema.
+func withType(paramType string) mcp.PropertyOption {
+	return func(schema map[string]interface{}) {
+		schema["type"] = paramType
+	}
+}
+
+// withDefault sets an arbitrary default value in a property schema.
+func withDefault(value interface{}) mcp.PropertyOption {
+	return func(schema map[string]interface{}) {
+		schema["default"] = value
+	}
+}
+
+// withRequiredProperties sets the list of required nested properties of an object parameter.
+// It must be applied after the parameter has been added, as the "required" key of the
+// property schema is also used by mcp.Required() for marking the parameter itself.
+func withRequiredProperties(name string, required []string) mcp.ToolOption {
+	return func(t *mcp.Tool) {
+		if schema, ok := t.InputSchema.Properties[name].(map[string]interface{}); ok {
+			schema["required"] = required
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+func TestCreateMCPToolCollections(t *testing.T) {
+	toolConfig := MCPToolConfig{
+		Name:        "get_pods",
+		Description: "Get some pods",
+		Params: map[string]common.ParamConfig{
+			"names": {
+				Type:        "array",
+				Description: "Pod names",
+				Required:    true,
+				Items:       &common.ParamConfig{Type: "string", Description: "A pod name"},
+			},
+			"ports": {
+				Type:        "array",
+				Description: "Ports",
+				Items:       &common.ParamConfig{Type: "integer"},
+				Default:     []interface{}{80, 443},
+			},
+			"labels": {
+				Type:        "object",
+				Description: "Label selector",
+				Required:    true,
+				Properties: map[string]common.ParamConfig{
+					"app":  {Type: "string", Description: "Application", Required: true},
+					"tier": {Type: "string", Description: "Tier"},
+				},
+			},
+			"tags": {
+				Type:        "array",
+				Description: "Tags without items definition",
+			},
+		},
+	}
+
+	tool := CreateMCPTool(toolConfig)
+
+	names, ok := tool.InputSchema.Properties["names"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected 'names' property schema, got %T", tool.InputSchema.Properties["names"])
+	}
+	if names["type"] != "array" {
+		t.Errorf("Expected 'names' to be an array, got %v", names["type"])
+	}
+	expectedItems := map[string]interface{}{"type": "string", "description": "A pod name"}
+	if !reflect.DeepEqual(names["items"], expectedItems) {
+		t.Errorf("Expected 'names' items %v, got %v", expectedItems, names["items"])
+	}
+
+	ports := tool.InputSchema.Properties["ports"].(map[string]interface{})
+	if !reflect.DeepEqual(ports["items"], map[string]interface{}{"type": "integer"}) {
+		t.Errorf("Unexpected 'ports' items: %v", ports["items"])
+	}
+	if !reflect.DeepEqual(ports["default"], []interface{}{80, 443}) {
+		t.Errorf("Unexpected 'ports' default: %v", ports["default"])
+	}
+
+	tags := tool.InputSchema.Properties["tags"].(map[string]interface{})
+	if !reflect.DeepEqual(tags["items"], map[string]interface{}{"type": "string"}) {
+		t.Errorf("Expected 'tags' items to default to strings, got %v", tags["items"])
+	}
+
+	labels := tool.InputSchema.Properties["labels"].(map[string]interface{})
+	if labels["type"] != "object" {
+		t.Errorf("Expected 'labels' to be an object, got %v", labels["type"])
+	}
+	properties, ok := labels["properties"].(map[string]interface{})
+	if !ok || len(properties) != 2 {
+		t.Fatalf("Expected 2 nested properties for 'labels', got %v", labels["properties"])
+	}
+	if !reflect.DeepEqual(labels["required"], []string{"app"}) {
+		t.Errorf("Expected nested required properties [app], got %v", labels["required"])
+	}
+
+	required := map[string]bool{}
+	for _, name := range tool.InputSchema.Required {
+		required[name] = true
+	}
+	if !required["names"] || !required["labels"] || len(required) != 2 {
+		t.Errorf("Expected 'names' and 'labels' to be required, got %v", tool.InputSchema.Required)
+	}
+}
+
+func TestCreateMCPToolValidations(t *testing.T) {
+	minimum, maximum := 1.0, 10.0
+	maxLength := 3
+
+	tool := CreateMCPTool(MCPToolConfig{
+		Name:        "scale",
+		Description: "Scale a deployment",
+		Params: map[string]common.ParamConfig{
+			"namespace": {
+				Type:        "string",
+				Description: "Namespace",
+				Enum:        []interface{}{"default", "prod"},
+				Pattern:     "^[a-z]+$",
+			},
+			"replicas": {
+				Type:        "integer",
+				Description: "Number of replicas",
+				Minimum:     &minimum,
+				Maximum:     &maximum,
+			},
+			"names": {
+				Type:        "array",
+				Description: "Deployments",
+				MaxLength:   &maxLength,
+				Items:       &common.ParamConfig{Type: "string", MaxLength: &maxLength},
+			},
+		},
+	})
+
+	namespace := tool.InputSchema.Properties["namespace"].(map[string]interface{})
+	if !reflect.DeepEqual(namespace["enum"], []interface{}{"default", "prod"}) {
+		t.Errorf("Unexpected enum: %v", namespace["enum"])
+	}
+	if namespace["pattern"] != "^[a-z]+$" {
+		t.Errorf("Unexpected pattern: %v", namespace["pattern"])
+	}
+
+	replicas := tool.InputSchema.Properties["replicas"].(map[string]interface{})
+	if replicas["minimum"] != 1.0 || replicas["maximum"] != 10.0 {
+		t.Errorf("Unexpected range: %v - %v", replicas["minimum"], replicas["maximum"])
+	}
+	if replicas["type"] != "integer" {
+		t.Errorf("Expected integer type, got %v", replicas["type"])
+	}
+
+	names := tool.InputSchema.Properties["names"].(map[string]interface{})
+	if names["maxItems"] != 3 {
+		t.Errorf("Expected maxItems for array, got %v", names["maxItems"])
+	}
+	items := names["items"].(map[string]interface{})
+	if items["maxLength"] != 3 {
+		t.Errorf("Expected maxLength for items, got %v", items["maxLength"])
+	}
+}
+
+func TestCreateMCPToolOutputSchema(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"phase": map[string]interface{}{"type": "string"},
+		},
+	}
+
+	tool := CreateMCPTool(MCPToolConfig{
+		Name:   "pod_status",
+		Output: common.OutputConfig{Schema: schema},
+	})
+
+	var advertised map[string]interface{}
+	if err := json.Unmarshal(tool.RawOutputSchema, &advertised); err != nil {
+		t.Fatalf("Invalid output schema: %v", err)
+	}
+	if !reflect.DeepEqual(advertised, schema) {
+		t.Errorf("Expected output schema %v, got %v", schema, advertised)
+	}
+
+	if tool := CreateMCPTool(MCPToolConfig{Name: "no_schema"}); tool.RawOutputSchema != nil {
+		t.Errorf("Expected no output schema, got %s", tool.RawOutputSchema)
+	}
+}
+
+func TestCreateMCPToolCache(t *testing.T) {
+	tool := CreateMCPTool(MCPToolConfig{
+		Name: "get_pods",
+		Run:  MCPToolRunConfig{Command: "kubectl get pods", Cache: &MCPCacheConfig{TTL: "30s"}},
+	})
+	noCache, ok := tool.InputSchema.Properties[common.NoCacheArgument].(map[string]interface{})
+	if !ok || noCache["type"] != "boolean" {
+		t.Errorf("Expected the %s argument for cached tools, got %v", common.NoCacheArgument, tool.InputSchema.Properties)
+	}
+
+	tool = CreateMCPTool(MCPToolConfig{Name: "not_cached", Run: MCPToolRunConfig{Command: "date"}})
+	if _, exists := tool.InputSchema.Properties[common.NoCacheArgument]; exists {
+		t.Errorf("Expected no %s argument for tools without cache", common.NoCacheArgument)
+	}
+}
@@ -9,6 +9,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -34,12 +39,90 @@ type MCPConfig struct {
 
 	// Tools is a list of tool definitions that will be provided to clients
 	Tools []MCPToolConfig `yaml:"tools"`
+
+	// Resources is a list of resources (or resource templates) whose contents are the output of commands
+	Resources []MCPResourceConfig `yaml:"resources,omitempty"`
 }
 
 // MCPRunConfig represents run-specific configuration options.
 type MCPRunConfig struct {
 	// Shell is the shell to use for executing commands (e.g., bash, sh, zsh)
 	Shell string `yaml:"shell,omitempty"`
+
+	// TemplateFunctions are the restricted template functions (ie, `env`) allowed
+	// in the templates of the tools
+	TemplateFunctions []string `yaml:"template_functions,omitempty"`
+
+	// MaxConcurrent is the maximum number of commands running at the same time in
+	// the server (0 for no limit)
+	MaxConcurrent int `yaml:"max_concurrent,omitempty"`
+
+	// MaxQueued is the maximum number of calls waiting for running their commands when
+	// the concurrency limits are reached, in the server and in each tool (default: 10)
+	MaxQueued int `yaml:"max_queued,omitempty"`
+
+	// QueueTimeout is the maximum time a call waits for running its command (default: 30s,
+	// "0s" rejects the calls immediately when the limits are reached)
+	QueueTimeout string `yaml:"queue_timeout,omitempty"`
+
+	// RateLimit is the maximum rate of calls of each session to all the tools (ie, "10/min")
+	RateLimit string `yaml:"rate_limit,omitempty"`
+
+	// Quota limits the calls to all the tools, per session or per day
+	Quota MCPQuotaConfig `yaml:"quota,omitempty"`
+}
+
+// Validate checks the run configuration of the server: the concurrency limits, the
+// rate limit and the quota.
+//
+// Returns:
+//   - An error if the run configuration is not valid
+func (r MCPRunConfig) Validate() error {
+	if _, _, err := r.GetQueueSettings(); err != nil {
+		return err
+	}
+	if r.RateLimit != "" {
+		if _, _, err := ParseRateLimit(r.RateLimit); err != nil {
+			return err
+		}
+	}
+	return r.Quota.Validate()
+}
+
+// Defaults for the queue of calls waiting because of the concurrency limits
+const (
+	DefaultMaxQueued    = 10
+	DefaultQueueTimeout = 30 * time.Second
+)
+
+// GetQueueSettings returns the size of the queue of calls waiting because of the
+// concurrency limits, and the maximum time they wait.
+//
+// Returns:
+//   - The maximum number of calls waiting
+//   - The maximum time a call waits
+//   - An error if some setting is not valid
+func (r MCPRunConfig) GetQueueSettings() (int, time.Duration, error) {
+	if r.MaxConcurrent < 0 {
+		return 0, 0, fmt.Errorf("max_concurrent must not be negative")
+	}
+
+	maxQueued := r.MaxQueued
+	switch {
+	case maxQueued < 0:
+		return 0, 0, fmt.Errorf("max_queued must not be negative")
+	case maxQueued == 0:
+		maxQueued = DefaultMaxQueued
+	}
+
+	queueTimeout := DefaultQueueTimeout
+	if r.QueueTimeout != "" {
+		var err error
+		if queueTimeout, err = time.ParseDuration(r.QueueTimeout); err != nil || queueTimeout < 0 {
+			return 0, 0, fmt.Errorf("invalid queue_timeout '%s' (must be a duration, like '30s')", r.QueueTimeout)
+		}
+	}
+	return maxQueued, queueTimeout, nil
 }
 
 // MCPToolConfig represents a single tool configuration.
@@ -50,6 +133,9 @@ type MCPToolConfig struct {
 	// Description explains what the tool does (shown to AI clients)
 	Description string `yaml:"description"`
 
+	// Tags are labels for grouping tools (ie, for allowing them in authentication tokens)
+	Tags []string `yaml:"tags,omitempty"`
+
 	// Params defines the parameters that the tool accepts
 	Params map[string]common.ParamConfig `yaml:"params"`
 
@@ -92,15 +178,359 @@ type MCPToolRunConfig struct {
 	// Command is a template for the shell command to execute
 	Command string `yaml:"command"`
 
+	// Argv is the command to execute as a list of templates, one for each argument
+	// (the first one is the executable). The command is executed without a shell.
+	Argv []string `yaml:"argv,omitempty"`
+
 	// Env is a list of environment variable names to pass from the parent process
 	Env []string `yaml:"env,omitempty"`
 
-	// Timeout is the maximum duration for command execution (e.g., "30s", "5m")
-	// If not specified, no timeout is applied
+	// Timeout is the maximum duration for command execution (e.g., "30s", "5m"). When
+	// it is exceeded, the command is terminated (with SIGTERM) and the output produced
+	// so far is returned. If not specified, no timeout is applied
 	Timeout string `yaml:"timeout,omitempty"`
 
+	// KillAfter is the time given to the command for exiting after the timeout,
+	// before killing it with SIGKILL (e.g., "10s"). Default: 5s
+	KillAfter string `yaml:"kill_after,omitempty"`
+
 	// Runners is a list of possible runner configurations
 	Runners []MCPToolRunner `yaml:"runners,omitempty"`
+
+	// SuccessCodes are the exit codes of the command that are not failures (default: 0)
+	SuccessCodes []int `yaml:"success_codes,omitempty"`
+
+	// ParamsAsEnv exports all the arguments as environment variables (MCP_PARAM_<name>),
+	// so commands can use them with the normal shell quoting (ie, "$MCP_PARAM_namespace")
+	ParamsAsEnv bool `yaml:"params_as_env,omitempty"`
+
+	// MaxConcurrent is the maximum number of commands of this tool running at the
+	// same time (0 for no limit)
+	MaxConcurrent int `yaml:"max_concurrent,omitempty"`
+
+	// Lock is a template for the name of a lock held while the command runs, so commands
+	// with the same lock (also of different tools) never run at the same time
+	// (ie, "terraform-{{ .workspace }}"). Commands are not locked when it renders to an
+	// empty string
+	Lock string `yaml:"lock,omitempty"`
+
+	// LockTimeout is the maximum time a call waits for the lock (default: 30s, "0s"
+	// fails immediately when the lock is held)
+	LockTimeout string `yaml:"lock_timeout,omitempty"`
+
+	// RateLimit is the maximum rate of calls of each session to this tool (ie, "10/min")
+	RateLimit string `yaml:"rate_limit,omitempty"`
+
+	// Quota limits the calls to this tool, per session or per day
+	Quota MCPQuotaConfig `yaml:"quota,omitempty"`
+
+	// Cache caches the results of the tool, so calls with the same arguments do not
+	// run the command again (only for read-only tools)
+	Cache *MCPCacheConfig `yaml:"cache,omitempty"`
+}
+
+// DefaultLockTimeout is the default maximum time a call waits for the lock of the tool
+const DefaultLockTimeout = 30 * time.Second
+
+// MCPCacheConfig is the configuration of the cache of the results of a tool.
+type MCPCacheConfig struct {
+	// TTL is the time the results are cached (ie, "30s")
+	TTL string `yaml:"ttl"`
+}
+
+// GetTTL returns the time the results are cached.
+//
+// Returns:
+//   - The time the results are cached
+//   - An error if the TTL is not valid
+func (c MCPCacheConfig) GetTTL() (time.Duration, error) {
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid cache ttl '%s' (must be a positive duration, like '30s')", c.TTL)
+	}
+	return ttl, nil
+}
+
+// GetTemplates returns all the templates of the tool: the command (or the argv
+// elements), the environment variables, the lock, the output prefix and the options
+// of the runners.
+func (t MCPToolConfig) GetTemplates() []string {
+	var templates []string
+	if t.Run.Command != "" {
+		templates = append(templates, t.Run.Command)
+	}
+	templates = append(templates, t.Run.Argv...)
+	templates = append(templates, t.Run.Env...)
+	if t.Run.Lock != "" {
+		templates = append(templates, t.Run.Lock)
+	}
+	if t.Output.Prefix != "" {
+		templates = append(templates, t.Output.Prefix)
+	}
+	for _, runner := range t.Run.Runners {
+		templates = append(templates, runner.GetTemplates()...)
+	}
+	return templates
+}
+
+// TemplatedRunnerOptions are the options of the runners that are templates, rendered
+// with the arguments of each call (ie, `allow_read_folders: ["{{ .dir }}"]`)
+var TemplatedRunnerOptions = []string{"allow_read_folders", "allow_write_folders", "allow_read_files", "allow_write_files"}
+
+// GetTemplates returns the templates in the options of the runner
+func (r MCPToolRunner) GetTemplates() []string {
+	var templates []string
+	for _, name := range TemplatedRunnerOptions {
+		switch values := r.Options[name].(type) {
+		case []string:
+			templates = append(templates, values...)
+		case []interface{}:
+			for _, value := range values {
+				if text, ok := value.(string); ok {
+					templates = append(templates, text)
+				}
+			}
+		}
+	}
+	return templates
+}
+
+// ParamEnvPrefix is the prefix of the environment variables with the arguments of tools
+const ParamEnvPrefix = "MCP_PARAM_"
+
+var (
+	// envNameRegexp matches valid environment variable names
+	envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// invalidEnvCharsRegexp matches the characters that cannot be used in environment variable names
+	invalidEnvCharsRegexp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// GetParamsEnv returns the environment variables where the arguments are exported,
+// indexed by parameter name: the parameters with an explicit `env` name, and all the
+// parameters (as MCP_PARAM_<name>) when `run.params_as_env` is enabled.
+func (t MCPToolConfig) GetParamsEnv() map[string]string {
+	res := map[string]string{}
+	for name, param := range t.Params {
+		switch {
+		case param.Env != "":
+			res[name] = param.Env
+		case t.Run.ParamsAsEnv:
+			res[name] = ParamEnvPrefix + invalidEnvCharsRegexp.ReplaceAllString(name, "_")
+		}
+	}
+	return res
+}
+
+// CheckParamsEnv checks the environment variables where the arguments are exported.
+//
+// Returns:
+//   - The parameters that are exported but also interpolated in the command
+//     (or argv, or env), as the interpolation is probably a mistake
+//   - An error if some environment variable name is not valid or it is used twice
+func (t MCPToolConfig) CheckParamsEnv() ([]string, error) {
+	paramsEnv := t.GetParamsEnv()
+	if len(paramsEnv) == 0 {
+		return nil, nil
+	}
+
+	params := make([]string, 0, len(paramsEnv))
+	for name := range paramsEnv {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+
+	used := map[string]string{}
+	for _, name := range params {
+		envName := paramsEnv[name]
+		if !envNameRegexp.MatchString(envName) {
+			return nil, fmt.Errorf("invalid environment variable name '%s' for parameter '%s'", envName, name)
+		}
+		if other, exists := used[envName]; exists {
+			return nil, fmt.Errorf("parameters '%s' and '%s' use the same environment variable '%s'", other, name, envName)
+		}
+		used[envName] = name
+	}
+
+	// Find the parameters used in the templates of the command
+	templates := append([]string{t.Run.Command}, t.Run.Argv...)
+	templates = append(templates, t.Run.Env...)
+	interpolated := map[string]bool{}
+	for _, text := range templates {
+		vars, err := common.TemplateVariables(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command template: %w", err)
+		}
+		for _, v := range vars {
+			interpolated[v] = true
+		}
+	}
+
+	var res []string
+	for _, name := range params {
+		if interpolated[name] {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// GetUnquotedParams returns the string parameters (or arrays of strings) that are
+// interpolated in the shell command without a quoting function (ie, `{{ .name }}`
+// instead of `{{ shellquote .name }}`) and without any restriction of their characters
+// (an enum, a pattern or a constraint matching them with a regular expression, like
+// `name.matches('^[a-z]+$')`), as they could be used for injecting shell code.
+// Commands in argv form are not executed by a shell, so they are always safe.
+//
+// Returns:
+//   - The names of the parameters, sorted
+//   - An error if the command template cannot be parsed
+func (t MCPToolConfig) GetUnquotedParams() ([]string, error) {
+	if t.Run.Command == "" {
+		return nil, nil
+	}
+
+	unquoted, err := common.UnquotedTemplateVariables(t.Run.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, name := range unquoted {
+		param, exists := t.Params[name]
+		if !exists {
+			continue
+		}
+		// the items of the arrays are written when iterating them (ie, with `range`)
+		if param.Type == "array" {
+			if param.Items == nil {
+				param = common.ParamConfig{}
+			} else {
+				param = *param.Items
+			}
+		}
+		if param.Type != "" && param.Type != "string" {
+			continue
+		}
+		if len(param.Enum) > 0 || param.Pattern != "" {
+			continue
+		}
+
+		// only the constraints matching the parameter with a regular expression restrict its
+		// characters (ie, not `size(name) < 10`)
+		mentioned := regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\b`)
+		restricted := false
+		for _, constraint := range t.Constraints {
+			if mentioned.MatchString(constraint) && strings.Contains(constraint, "matches(") {
+				restricted = true
+				break
+			}
+		}
+		if !restricted {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// argvRunners are the runners that can execute commands from an argv list
+var argvRunners = []string{"exec", "docker", "firejail", "sandbox-exec"}
+
+// Validate checks the command of the run configuration: either a command or an
+// argv list must be provided (but not both), and argv requires runners supporting it.
+//
+// Returns:
+//   - An error if the run configuration is not valid
+func (r MCPToolRunConfig) Validate() error {
+	switch {
+	case r.Command != "" && len(r.Argv) > 0:
+		return fmt.Errorf("command and argv cannot be used together")
+	case r.Command == "" && len(r.Argv) == 0:
+		return fmt.Errorf("empty command template")
+	}
+
+	if _, _, err := r.GetTimeouts(); err != nil {
+		return err
+	}
+	if r.MaxConcurrent < 0 {
+		return fmt.Errorf("max_concurrent must not be negative")
+	}
+	if _, err := r.GetLockTimeout(); err != nil {
+		return err
+	}
+	if r.RateLimit != "" {
+		if _, _, err := ParseRateLimit(r.RateLimit); err != nil {
+			return err
+		}
+	}
+	if err := r.Quota.Validate(); err != nil {
+		return err
+	}
+	if r.Cache != nil {
+		if _, err := r.Cache.GetTTL(); err != nil {
+			return err
+		}
+	}
+
+	if len(r.Argv) > 0 {
+		if strings.TrimSpace(r.Argv[0]) == "" {
+			return fmt.Errorf("the first element of argv (the executable) cannot be empty")
+		}
+		for _, runner := range r.Runners {
+			if !slices.Contains(argvRunners, runner.Name) {
+				return fmt.Errorf("runner '%s' does not support argv (supported runners: %s)",
+					runner.Name, strings.Join(argvRunners, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetTimeouts returns the timeout of the command and the time given to the command
+// for exiting after the timeout (zero when not configured).
+//
+// Returns:
+//   - The timeout (zero for no timeout)
+//   - The time before killing the command after the timeout (zero for the default)
+//   - An error if some duration is not valid
+func (r MCPToolRunConfig) GetTimeouts() (time.Duration, time.Duration, error) {
+	var timeout, killAfter time.Duration
+	var err error
+	if r.Timeout != "" {
+		if timeout, err = time.ParseDuration(r.Timeout); err != nil || timeout <= 0 {
+			return 0, 0, fmt.Errorf("invalid timeout '%s' (must be a positive duration, like '30s')", r.Timeout)
+		}
+	}
+	if r.KillAfter != "" {
+		if r.Timeout == "" {
+			return 0, 0, fmt.Errorf("kill_after requires a timeout")
+		}
+		if killAfter, err = time.ParseDuration(r.KillAfter); err != nil || killAfter <= 0 {
+			return 0, 0, fmt.Errorf("invalid kill_after '%s' (must be a positive duration, like '10s')", r.KillAfter)
+		}
+	}
+	return timeout, killAfter, nil
+}
+
+// GetLockTimeout returns the maximum time a call waits for the lock of the tool.
+//
+// Returns:
+//   - The maximum time waiting for the lock (zero for not waiting)
+//   - An error if the lock timeout is not valid
+func (r MCPToolRunConfig) GetLockTimeout() (time.Duration, error) {
+	if r.LockTimeout == "" {
+		return DefaultLockTimeout, nil
+	}
+	if r.Lock == "" {
+		return 0, fmt.Errorf("lock_timeout requires a lock")
+	}
+	lockTimeout, err := time.ParseDuration(r.LockTimeout)
+	if err != nil || lockTimeout < 0 {
+		return 0, fmt.Errorf("invalid lock_timeout '%s' (must be a duration, like '30s')", r.LockTimeout)
+	}
+	return lockTimeout, nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////////
@@ -179,7 +609,7 @@ func (c *ToolsConfig) ToYAML() ([]byte, error) {
 // - Prompts are concatenated from all files
 // - MCP description from the first file is used (others are ignored)
 // - MCP run config from the first file is used (others are ignored)
-// - Tools from all files are combined
+// - Tools and resources from all files are combined
 //
 // Parameters:
 //   - filepaths: List of paths to YAML configuration files
@@ -204,6 +634,7 @@ func LoadAndMergeConfigs(filepaths []string) (*ToolsConfig, error) {
 		// Merge prompts (concatenate system and user prompts)
 		mergedConfig.Prompts.System = append(mergedConfig.Prompts.System, config.Prompts.System...)
 		mergedConfig.Prompts.User = append(mergedConfig.Prompts.User, config.Prompts.User...)
+		mergedConfig.Prompts.Named = append(mergedConfig.Prompts.Named, config.Prompts.Named...)
 
 		// For MCP config, use the first file's description and run config
 		if isFirstFile {
@@ -214,6 +645,7 @@ func LoadAndMergeConfigs(filepaths []string) (*ToolsConfig, error) {
 
 		// Merge tools (combine from all files)
 		mergedConfig.MCP.Tools = append(mergedConfig.MCP.Tools, config.MCP.Tools...)
+		mergedConfig.MCP.Resources = append(mergedConfig.MCP.Resources, config.MCP.Resources...)
 	}
 
 	return &mergedConfig, nil
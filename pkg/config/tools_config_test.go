@@ -1,8 +1,13 @@
 package config
 
 import (
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
 )
 
 func TestCheckToolPrerequisites(t *testing.T) {
@@ -157,3 +162,326 @@ func TestCreateTools_Prerequisites(t *testing.T) {
 		t.Errorf("Expected tool named 'tool1', got '%s'", tools[0].MCPTool.Name)
 	}
 }
+
+func TestMCPToolRunConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     MCPToolRunConfig
+		wantErr string
+	}{
+		{
+			name: "command",
+			run:  MCPToolRunConfig{Command: "ls {{ .dir }}"},
+		},
+		{
+			name: "argv",
+			run:  MCPToolRunConfig{Argv: []string{"ls", "{{ .dir }}"}},
+		},
+		{
+			name: "argv with supported runners",
+			run:  MCPToolRunConfig{Argv: []string{"ls"}, Runners: []MCPToolRunner{{Name: "docker"}, {Name: "firejail"}, {Name: "sandbox-exec"}, {Name: "exec"}}},
+		},
+		{
+			name:    "no command",
+			run:     MCPToolRunConfig{},
+			wantErr: "empty command template",
+		},
+		{
+			name:    "command and argv",
+			run:     MCPToolRunConfig{Command: "ls", Argv: []string{"ls"}},
+			wantErr: "cannot be used together",
+		},
+		{
+			name:    "empty executable",
+			run:     MCPToolRunConfig{Argv: []string{"", "-l"}},
+			wantErr: "cannot be empty",
+		},
+		{
+			name:    "argv with an unsupported runner",
+			run:     MCPToolRunConfig{Argv: []string{"ls"}, Runners: []MCPToolRunner{{Name: "chroot"}}},
+			wantErr: "runner 'chroot' does not support argv",
+		},
+		{
+			name: "timeouts",
+			run:  MCPToolRunConfig{Command: "ls", Timeout: "30s", KillAfter: "10s"},
+		},
+		{
+			name:    "invalid timeout",
+			run:     MCPToolRunConfig{Command: "ls", Timeout: "30"},
+			wantErr: "invalid timeout '30'",
+		},
+		{
+			name:    "invalid kill_after",
+			run:     MCPToolRunConfig{Command: "ls", Timeout: "30s", KillAfter: "-1s"},
+			wantErr: "invalid kill_after '-1s'",
+		},
+		{
+			name:    "kill_after without timeout",
+			run:     MCPToolRunConfig{Command: "ls", KillAfter: "10s"},
+			wantErr: "kill_after requires a timeout",
+		},
+		{
+			name:    "negative max_concurrent",
+			run:     MCPToolRunConfig{Command: "ls", MaxConcurrent: -1},
+			wantErr: "max_concurrent must not be negative",
+		},
+		{
+			name: "lock",
+			run:  MCPToolRunConfig{Command: "terraform apply", Lock: "terraform-{{ .workspace }}", LockTimeout: "0s"},
+		},
+		{
+			name:    "invalid lock_timeout",
+			run:     MCPToolRunConfig{Command: "terraform apply", Lock: "terraform", LockTimeout: "1"},
+			wantErr: "invalid lock_timeout '1'",
+		},
+		{
+			name:    "lock_timeout without lock",
+			run:     MCPToolRunConfig{Command: "terraform apply", LockTimeout: "10s"},
+			wantErr: "lock_timeout requires a lock",
+		},
+		{
+			name: "cache",
+			run:  MCPToolRunConfig{Command: "kubectl get pods", Cache: &MCPCacheConfig{TTL: "30s"}},
+		},
+		{
+			name:    "cache without ttl",
+			run:     MCPToolRunConfig{Command: "kubectl get pods", Cache: &MCPCacheConfig{}},
+			wantErr: "invalid cache ttl ''",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMCPRunConfig_GetQueueSettings(t *testing.T) {
+	tests := []struct {
+		name             string
+		run              MCPRunConfig
+		wantMaxQueued    int
+		wantQueueTimeout time.Duration
+		wantErr          string
+	}{
+		{
+			name:             "defaults",
+			run:              MCPRunConfig{MaxConcurrent: 4},
+			wantMaxQueued:    DefaultMaxQueued,
+			wantQueueTimeout: DefaultQueueTimeout,
+		},
+		{
+			name:             "custom",
+			run:              MCPRunConfig{MaxConcurrent: 4, MaxQueued: 2, QueueTimeout: "5s"},
+			wantMaxQueued:    2,
+			wantQueueTimeout: 5 * time.Second,
+		},
+		{
+			name:             "no waiting",
+			run:              MCPRunConfig{QueueTimeout: "0s"},
+			wantMaxQueued:    DefaultMaxQueued,
+			wantQueueTimeout: 0,
+		},
+		{
+			name:    "negative max_concurrent",
+			run:     MCPRunConfig{MaxConcurrent: -1},
+			wantErr: "max_concurrent must not be negative",
+		},
+		{
+			name:    "negative max_queued",
+			run:     MCPRunConfig{MaxQueued: -1},
+			wantErr: "max_queued must not be negative",
+		},
+		{
+			name:    "invalid queue_timeout",
+			run:     MCPRunConfig{QueueTimeout: "soon"},
+			wantErr: "invalid queue_timeout 'soon'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxQueued, queueTimeout, err := tt.run.GetQueueSettings()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if maxQueued != tt.wantMaxQueued || queueTimeout != tt.wantQueueTimeout {
+				t.Errorf("Expected (%d, %s), got (%d, %s)", tt.wantMaxQueued, tt.wantQueueTimeout, maxQueued, queueTimeout)
+			}
+		})
+	}
+}
+
+func TestMCPToolConfig_ParamsEnv(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           MCPToolConfig
+		wantEnv          map[string]string
+		wantInterpolated []string
+		wantErr          string
+	}{
+		{
+			name: "not exported",
+			config: MCPToolConfig{
+				Params: map[string]common.ParamConfig{"namespace": {}},
+				Run:    MCPToolRunConfig{Command: "kubectl get pods -n {{ .namespace }}"},
+			},
+			wantEnv: map[string]string{},
+		},
+		{
+			name: "all the params",
+			config: MCPToolConfig{
+				Params: map[string]common.ParamConfig{"namespace": {}, "pod-name": {}},
+				Run:    MCPToolRunConfig{Command: `kubectl get pod "$MCP_PARAM_pod_name"`, ParamsAsEnv: true},
+			},
+			wantEnv: map[string]string{"namespace": "MCP_PARAM_namespace", "pod-name": "MCP_PARAM_pod_name"},
+		},
+		{
+			name: "explicit names",
+			config: MCPToolConfig{
+				Params: map[string]common.ParamConfig{"namespace": {Env: "NS"}, "pod": {}},
+				Run:    MCPToolRunConfig{Command: `kubectl get pod {{ .pod }} -n "$NS"`},
+			},
+			wantEnv: map[string]string{"namespace": "NS"},
+		},
+		{
+			name: "exported and interpolated",
+			config: MCPToolConfig{
+				Params: map[string]common.ParamConfig{"namespace": {}, "pod": {}},
+				Run: MCPToolRunConfig{
+					Argv:        []string{"kubectl", "get", "pod", "{{ .pod }}"},
+					Env:         []string{"NS={{ .namespace }}"},
+					ParamsAsEnv: true,
+				},
+			},
+			wantEnv:          map[string]string{"namespace": "MCP_PARAM_namespace", "pod": "MCP_PARAM_pod"},
+			wantInterpolated: []string{"namespace", "pod"},
+		},
+		{
+			name: "invalid name",
+			config: MCPToolConfig{
+				Params: map[string]common.ParamConfig{"namespace": {Env: "1NS"}},
+				Run:    MCPToolRunConfig{Command: "true"},
+			},
+			wantErr: "invalid environment variable name",
+		},
+		{
+			name: "duplicated name",
+			config: MCPToolConfig{
+				Params: map[string]common.ParamConfig{"a": {Env: "MCP_PARAM_b"}, "b": {}},
+				Run:    MCPToolRunConfig{Command: "true", ParamsAsEnv: true},
+			},
+			wantErr: "use the same environment variable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interpolated, err := tt.config.CheckParamsEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if env := tt.config.GetParamsEnv(); !reflect.DeepEqual(env, tt.wantEnv) {
+				t.Errorf("Expected env %v, got %v", tt.wantEnv, env)
+			}
+			if !reflect.DeepEqual(interpolated, tt.wantInterpolated) {
+				t.Errorf("Expected interpolated %v, got %v", tt.wantInterpolated, interpolated)
+			}
+		})
+	}
+}
+
+func TestMCPToolConfig_GetUnquotedParams(t *testing.T) {
+	params := map[string]common.ParamConfig{
+		"dir":       {Type: "string"},
+		"file":      {},
+		"count":     {Type: "integer"},
+		"namespace": {Type: "string", Pattern: "^[a-z]+$"},
+		"format":    {Type: "string", Enum: []interface{}{"json", "yaml"}},
+		"pod":       {Type: "string"},
+		"files":     {Type: "array", Items: &common.ParamConfig{Type: "string"}},
+		"ports":     {Type: "array", Items: &common.ParamConfig{Type: "integer"}},
+	}
+
+	tests := []struct {
+		name        string
+		run         MCPToolRunConfig
+		constraints []string
+		expected    []string
+	}{
+		{
+			name:     "unquoted strings",
+			run:      MCPToolRunConfig{Command: "ls {{ .dir }} {{ .file }} | head -n {{ .count }}"},
+			expected: []string{"dir", "file"},
+		},
+		{
+			name: "quoted strings",
+			run:  MCPToolRunConfig{Command: "ls {{ shellquote .dir }} {{ .file | shellquote }}"},
+		},
+		{
+			name: "restricted by validations",
+			run:  MCPToolRunConfig{Command: "kubectl get pods -n {{ .namespace }} -o {{ .format }}"},
+		},
+		{
+			name:        "restricted by constraints",
+			run:         MCPToolRunConfig{Command: "kubectl get pod {{ .pod }} {{ .dir }}"},
+			constraints: []string{"pod.matches('^[a-z-]+$')", "directory != ''"},
+			expected:    []string{"dir"},
+		},
+		{
+			name:        "constraints not restricting the characters",
+			run:         MCPToolRunConfig{Command: "kubectl get pod {{ .pod }}"},
+			constraints: []string{"size(pod) < 10", "!pod.contains(';')"},
+			expected:    []string{"pod"},
+		},
+		{
+			name:     "arrays of strings",
+			run:      MCPToolRunConfig{Command: "ls {{ range .files }}{{ . }} {{ end }} {{ range .ports }}{{ . }}{{ end }}"},
+			expected: []string{"files"},
+		},
+		{
+			name:        "arrays restricted by constraints",
+			run:         MCPToolRunConfig{Command: "ls {{ range .files }}{{ . }} {{ end }}"},
+			constraints: []string{"size(files) < 10", "files.all(f, f.matches('^[a-z.]+$'))"},
+		},
+		{
+			name: "argv",
+			run:  MCPToolRunConfig{Argv: []string{"ls", "{{ .dir }}"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := MCPToolConfig{Params: params, Constraints: tt.constraints, Run: tt.run}
+			unquoted, err := cfg.GetUnquotedParams()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(unquoted, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, unquoted)
+			}
+		})
+	}
+}
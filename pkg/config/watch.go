@@ -0,0 +1,135 @@
+package config
+
+import (
+	"context"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
+	"github.com/inercia/MCPShell/pkg/utils"
+)
+
+// DefaultWatchInterval is the default interval for checking the configuration files for changes
+const DefaultWatchInterval = 2 * time.Second
+
+// fileState is the state of a watched file used for detecting changes
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watcher detects changes in the tools configuration files by polling them.
+//
+// It accepts the same paths as ResolveMultipleConfigPaths: local files
+// (resolved with the same logic as the --tools flag) and directories (where
+// all the YAML files are watched, including new and deleted files). Remote
+// URLs are not watched.
+type Watcher struct {
+	paths    []string
+	interval time.Duration
+	logger   *common.Logger
+}
+
+// NewWatcher creates a new watcher for the given configuration paths
+//
+// Parameters:
+//   - paths: The configuration paths, as provided with the --tools flag
+//   - interval: The interval for checking the files (DefaultWatchInterval if zero)
+//   - logger: Logger for reporting the watched files
+//
+// Returns:
+//   - A new Watcher
+func NewWatcher(paths []string, interval time.Duration, logger *common.Logger) *Watcher {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	for _, p := range paths {
+		if isRemotePath(p) {
+			logger.Info("Remote configuration %s will not be watched for changes", p)
+		}
+	}
+
+	return &Watcher{
+		paths:    paths,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Watch checks the configuration files periodically, calling onChange
+// every time some file is created, modified or removed. It blocks until
+// the context is cancelled.
+func (w *Watcher) Watch(ctx context.Context, onChange func()) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	last := w.snapshot()
+	w.logger.Info("Watching %d configuration file(s) for changes", len(last))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := w.snapshot()
+			if maps.Equal(current, last) {
+				continue
+			}
+			last = current
+
+			w.logger.Info("Configuration files changed")
+			onChange()
+		}
+	}
+}
+
+// snapshot returns the current state of all the watched files
+func (w *Watcher) snapshot() map[string]fileState {
+	states := map[string]fileState{}
+
+	addFile := func(path string) {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			states[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+
+	for _, p := range w.paths {
+		if isRemotePath(p) {
+			continue
+		}
+
+		localPath := strings.TrimPrefix(p, "file://")
+		if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+			// same files as the ones merged in resolveConfigDirectory
+			_ = filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return nil
+				}
+				ext := strings.ToLower(filepath.Ext(path))
+				if ext == ".yaml" || ext == ".yml" {
+					addFile(path)
+				}
+				return nil
+			})
+			continue
+		}
+
+		// deleted files are just missing in the snapshot
+		if resolved, err := utils.ResolveToolsFile(localPath); err == nil {
+			addFile(resolved)
+		}
+	}
+
+	return states
+}
+
+// isRemotePath returns true if the configuration path is a remote URL
+func isRemotePath(path string) bool {
+	parsedURL, err := url.Parse(path)
+	return err == nil && (parsedURL.Scheme == "http" || parsedURL.Scheme == "https")
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+func TestWatcher(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelNone, false)
+
+	tempDir := t.TempDir()
+	first := filepath.Join(tempDir, "first.yaml")
+	if err := os.WriteFile(first, []byte("mcp: {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	watcher := NewWatcher([]string{tempDir, "https://example.com/tools.yaml"}, 10*time.Millisecond, logger)
+	if states := watcher.snapshot(); len(states) != 1 {
+		t.Fatalf("Expected 1 watched file, got %v", states)
+	}
+
+	changes := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Watch(ctx, func() { changes <- struct{}{} })
+
+	waitForChange := func(what string) {
+		select {
+		case <-changes:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No change detected after %s", what)
+		}
+	}
+
+	// give the watcher time to take the initial snapshot
+	time.Sleep(50 * time.Millisecond)
+
+	second := filepath.Join(tempDir, "second.yml")
+	if err := os.WriteFile(second, []byte("mcp: {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	waitForChange("creating a file")
+
+	if err := os.WriteFile(first, []byte("mcp:\n  tools: []\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	waitForChange("modifying a file")
+
+	if err := os.Remove(second); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	waitForChange("removing a file")
+
+	// files that are not YAML are ignored
+	if err := os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	select {
+	case <-changes:
+		t.Error("Unexpected change detected for a non-YAML file")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
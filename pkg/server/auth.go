@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+	"gopkg.in/yaml.v3"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// tokenHashPrefix is the prefix of the token hashes in the tokens file
+const tokenHashPrefix = "sha256:"
+
+// AuthToken is a token accepted by the HTTP server, with the tools it can use.
+type AuthToken struct {
+	// Name identifies the token (ie, the client using it) in logs
+	Name string `yaml:"name"`
+
+	// Hash is the SHA-256 hash of the token, in the "sha256:<hex>" form
+	Hash string `yaml:"hash"`
+
+	// Tools is the list of tools allowed for this token ("*" for all the tools)
+	Tools []string `yaml:"tools,omitempty"`
+
+	// Tags is the list of tags allowed for this token: the tools with any of these tags are allowed
+	Tags []string `yaml:"tags,omitempty"`
+
+	hash []byte // the decoded hash
+}
+
+// AuthConfig is the configuration of the tokens accepted by the HTTP server.
+type AuthConfig struct {
+	Tokens []AuthToken `yaml:"tokens"`
+}
+
+// authTokenContextKey is the context key for the authenticated token
+type authTokenContextKey struct{}
+
+// LoadAuthConfig loads the tokens file and checks all the tokens are valid.
+//
+// Parameters:
+//   - path: Path to the YAML tokens file
+//
+// Returns:
+//   - The tokens configuration
+//   - An error if the file cannot be loaded or some token is not valid
+func LoadAuthConfig(path string) (*AuthConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file %s: %w", path, err)
+	}
+
+	var cfg AuthConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file %s: %w", path, err)
+	}
+
+	if len(cfg.Tokens) == 0 {
+		return nil, fmt.Errorf("no tokens defined in the tokens file %s", path)
+	}
+
+	names := map[string]bool{}
+	for i := range cfg.Tokens {
+		token := &cfg.Tokens[i]
+		if token.Name == "" {
+			return nil, fmt.Errorf("token #%d has no name", i+1)
+		}
+		if names[token.Name] {
+			return nil, fmt.Errorf("duplicate token name '%s'", token.Name)
+		}
+		names[token.Name] = true
+
+		hexHash, found := strings.CutPrefix(token.Hash, tokenHashPrefix)
+		if !found {
+			return nil, fmt.Errorf("invalid hash for token '%s': it must start with '%s'", token.Name, tokenHashPrefix)
+		}
+		token.hash, err = hex.DecodeString(hexHash)
+		if err != nil || len(token.hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid hash for token '%s': it must be a hex-encoded SHA-256 hash", token.Name)
+		}
+
+		if len(token.Tools) == 0 && len(token.Tags) == 0 {
+			return nil, fmt.Errorf("token '%s' does not allow any tool: 'tools' or 'tags' are required", token.Name)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// HashToken returns the hash of a token, in the form used in the tokens file.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// GenerateToken generates a new random token.
+func GenerateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// authenticate returns the token matching the provided secret, or nil if there is none.
+func (c *AuthConfig) authenticate(secret string) *AuthToken {
+	sum := sha256.Sum256([]byte(secret))
+
+	var found *AuthToken
+	for i := range c.Tokens {
+		if subtle.ConstantTimeCompare(sum[:], c.Tokens[i].hash) == 1 {
+			found = &c.Tokens[i]
+		}
+	}
+	return found
+}
+
+// allows checks if the token can use a tool with the given name and tags.
+func (t *AuthToken) allows(toolName string, toolTags []string) bool {
+	if slices.Contains(t.Tools, "*") || slices.Contains(t.Tools, toolName) {
+		return true
+	}
+	for _, tag := range toolTags {
+		if slices.Contains(t.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestSecret returns the secret provided in the request, either as a
+// bearer token in the Authorization header or in the X-API-Key header.
+func requestSecret(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, secret, found := strings.Cut(auth, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(secret)
+		}
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// requireAuth rejects the HTTP requests without a valid token. The token (and
+// the caller identity) are stored in the context of the accepted requests.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := s.auth.authenticate(requestSecret(r))
+		if token == nil {
+			s.logger.Info("Rejected unauthenticated HTTP request from %s: %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcpshell"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		s.logger.Debug("Authenticated HTTP request from %s as '%s'", r.RemoteAddr, token.Name)
+		ctx := context.WithValue(r.Context(), authTokenContextKey{}, token)
+		caller := &common.Caller{Name: token.Name}
+		if certCaller := common.CallerFromContext(r.Context()); certCaller != nil {
+			caller.Subject = certCaller.Subject // keep the identity from the client certificate
+		}
+		ctx = common.WithCaller(ctx, caller)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// filterToolsByToken is a tool filter that only keeps the tools allowed for the
+// token of the request. It is applied both for listing and for calling tools,
+// so calls to tools that are not allowed are rejected before running any command.
+func (s *Server) filterToolsByToken(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+	token, _ := ctx.Value(authTokenContextKey{}).(*AuthToken)
+	if token == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	allowed := make([]mcp.Tool, 0, len(tools))
+	for _, tool := range tools {
+		if token.allows(tool.Name, s.tools[tool.Name].Tags) {
+			allowed = append(allowed, tool)
+		} else {
+			s.logger.Debug("Tool '%s' is not allowed for token '%s'", tool.Name, token.Name)
+		}
+	}
+	return allowed
+}
+
+// resourceTags returns the tags of a registered resource
+func (s *Server) resourceTags(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, resource := range s.resources {
+		if resource.Name == name {
+			return resource.Tags
+		}
+	}
+	return nil
+}
+
+// filterResourcesByToken only keeps the resources allowed for the token of the
+// request in the result of resources/list
+func (s *Server) filterResourcesByToken(ctx context.Context, id any, request *mcp.ListResourcesRequest, result *mcp.ListResourcesResult) {
+	token, _ := ctx.Value(authTokenContextKey{}).(*AuthToken)
+	result.Resources = slices.DeleteFunc(result.Resources, func(resource mcp.Resource) bool {
+		return token == nil || !token.allows(resource.Name, s.resourceTags(resource.Name))
+	})
+}
+
+// filterResourceTemplatesByToken only keeps the resource templates allowed for the
+// token of the request in the result of resources/templates/list
+func (s *Server) filterResourceTemplatesByToken(ctx context.Context, id any, request *mcp.ListResourceTemplatesRequest, result *mcp.ListResourceTemplatesResult) {
+	token, _ := ctx.Value(authTokenContextKey{}).(*AuthToken)
+	result.ResourceTemplates = slices.DeleteFunc(result.ResourceTemplates, func(template mcp.ResourceTemplate) bool {
+		return token == nil || !token.allows(template.Name, s.resourceTags(template.Name))
+	})
+}
+
+// requireResourceToken wraps the handler of a resource for rejecting the reads when
+// the resource is not allowed for the token of the request, before running any command
+func (s *Server) requireResourceToken(name string, tags []string, handler mcpserver.ResourceHandlerFunc) mcpserver.ResourceHandlerFunc {
+	return func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		token, _ := ctx.Value(authTokenContextKey{}).(*AuthToken)
+		if token == nil || !token.allows(name, tags) {
+			tokenName := ""
+			if token != nil {
+				tokenName = token.Name
+			}
+			s.logger.Info("Rejected reading resource '%s' with token '%s'", name, tokenName)
+			return nil, fmt.Errorf("resource '%s' is not allowed", name)
+		}
+		return handler(ctx, request)
+	}
+}
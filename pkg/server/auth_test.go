@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+func TestLoadAuthConfig(t *testing.T) {
+	validHash := HashToken("secret")
+
+	tests := []struct {
+		name    string
+		content string
+		errMsg  string
+	}{
+		{
+			name: "valid tokens",
+			content: `tokens:
+  - name: admin
+    hash: "` + validHash + `"
+    tools: ["*"]
+  - name: reader
+    hash: "` + HashToken("other") + `"
+    tags: ["read-only"]
+`,
+		},
+		{
+			name:    "no tokens",
+			content: "tokens: []\n",
+			errMsg:  "no tokens defined",
+		},
+		{
+			name: "plain text secret",
+			content: `tokens:
+  - name: admin
+    hash: "secret"
+    tools: ["*"]
+`,
+			errMsg: "must start with 'sha256:'",
+		},
+		{
+			name: "short hash",
+			content: `tokens:
+  - name: admin
+    hash: "sha256:abcd"
+    tools: ["*"]
+`,
+			errMsg: "hex-encoded SHA-256 hash",
+		},
+		{
+			name: "duplicate names",
+			content: `tokens:
+  - name: admin
+    hash: "` + validHash + `"
+    tools: ["*"]
+  - name: admin
+    hash: "` + validHash + `"
+    tools: ["*"]
+`,
+			errMsg: "duplicate token name 'admin'",
+		},
+		{
+			name: "no tools allowed",
+			content: `tokens:
+  - name: admin
+    hash: "` + validHash + `"
+`,
+			errMsg: "does not allow any tool",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("Failed to write tokens file: %v", err)
+			}
+
+			cfg, err := LoadAuthConfig(path)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("LoadAuthConfig() error = %v, expected error containing %q", err, tt.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadAuthConfig() unexpected error: %v", err)
+			}
+			if token := cfg.authenticate("secret"); token == nil || token.Name != "admin" {
+				t.Errorf("Expected 'secret' to authenticate as 'admin', got %v", token)
+			}
+			if token := cfg.authenticate("wrong"); token != nil {
+				t.Errorf("Expected 'wrong' not to authenticate, got %v", token.Name)
+			}
+		})
+	}
+}
+
+func TestServer_HTTPAuthentication(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.yaml")
+	configContent := `mcp:
+  tools:
+    - name: "list_files"
+      description: "List files"
+      tags: ["read-only"]
+      run:
+        command: "echo files"
+    - name: "delete_files"
+      description: "Delete files"
+      run:
+        command: "echo deleted"
+  resources:
+    - uri: "files://status"
+      name: "files_status"
+      tags: ["read-only"]
+      run:
+        command: "echo status"
+    - uri: "files://secrets"
+      name: "secrets"
+      run:
+        command: "echo secret"
+    - uri_template: "files://content/{path}"
+      name: "file_content"
+      run:
+        command: "echo content"
+`
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	tokensFile := filepath.Join(tempDir, "tokens.yaml")
+	tokensContent := `tokens:
+  - name: reader
+    hash: "` + HashToken("reader-secret") + `"
+    tags: ["read-only"]
+  - name: admin
+    hash: "` + HashToken("admin-secret") + `"
+    tools: ["*"]
+`
+	if err := os.WriteFile(tokensFile, []byte(tokensContent), 0600); err != nil {
+		t.Fatalf("Failed to write tokens file: %v", err)
+	}
+
+	srv := New(Config{ConfigFile: configFile, Logger: logger, Version: "test"})
+	srv.auth, err = LoadAuthConfig(tokensFile)
+	if err != nil {
+		t.Fatalf("Failed to load tokens: %v", err)
+	}
+	if err := srv.CreateServer(); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	httpServer := httptest.NewServer(srv.newHTTPHandler(HTTPConfig{}))
+	defer httpServer.Close()
+
+	// requests without a valid token are rejected
+	for _, header := range []string{"", "Bearer wrong", "Basic YWRtaW46c2VjcmV0"} {
+		req, _ := http.NewRequest(http.MethodPost, httpServer.URL+DefaultHTTPPath, strings.NewReader("{}"))
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for Authorization %q, got %d", header, resp.StatusCode)
+		}
+	}
+
+	connect := func(t *testing.T, secret string) (*client.Client, context.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		t.Cleanup(cancel)
+
+		c, err := client.NewStreamableHttpClient(httpServer.URL+DefaultHTTPPath,
+			transport.WithHTTPHeaders(map[string]string{"Authorization": "Bearer " + secret}))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+
+		initRequest := mcp.InitializeRequest{}
+		initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+		initRequest.Params.ClientInfo = mcp.Implementation{Name: "test", Version: "1.0"}
+		if _, err := c.Initialize(ctx, initRequest); err != nil {
+			t.Fatalf("Failed to initialize: %v", err)
+		}
+		return c, ctx
+	}
+
+	callTool := func(ctx context.Context, c *client.Client, name string) error {
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		_, err := c.CallTool(ctx, request)
+		return err
+	}
+
+	t.Run("tools are filtered by tags", func(t *testing.T) {
+		c, ctx := connect(t, "reader-secret")
+
+		tools, err := c.ListTools(ctx, mcp.ListToolsRequest{})
+		if err != nil {
+			t.Fatalf("Failed to list tools: %v", err)
+		}
+		if len(tools.Tools) != 1 || tools.Tools[0].Name != "list_files" {
+			t.Errorf("Expected only 'list_files', got %v", tools.Tools)
+		}
+
+		if err := callTool(ctx, c, "list_files"); err != nil {
+			t.Errorf("Unexpected error calling an allowed tool: %v", err)
+		}
+		if err := callTool(ctx, c, "delete_files"); err == nil {
+			t.Error("Expected an error calling a tool that is not allowed")
+		}
+	})
+
+	readResource := func(ctx context.Context, c *client.Client, uri string) (*mcp.ReadResourceResult, error) {
+		request := mcp.ReadResourceRequest{}
+		request.Params.URI = uri
+		return c.ReadResource(ctx, request)
+	}
+
+	t.Run("resources are filtered by tags", func(t *testing.T) {
+		c, ctx := connect(t, "reader-secret")
+
+		resources, err := c.ListResources(ctx, mcp.ListResourcesRequest{})
+		if err != nil {
+			t.Fatalf("Failed to list resources: %v", err)
+		}
+		if len(resources.Resources) != 1 || resources.Resources[0].Name != "files_status" {
+			t.Errorf("Expected only 'files_status', got %v", resources.Resources)
+		}
+
+		templates, err := c.ListResourceTemplates(ctx, mcp.ListResourceTemplatesRequest{})
+		if err != nil {
+			t.Fatalf("Failed to list resource templates: %v", err)
+		}
+		if len(templates.ResourceTemplates) != 0 {
+			t.Errorf("Expected no resource templates, got %v", templates.ResourceTemplates)
+		}
+
+		if _, err := readResource(ctx, c, "files://status"); err != nil {
+			t.Errorf("Unexpected error reading an allowed resource: %v", err)
+		}
+		for _, uri := range []string{"files://secrets", "files://content/etc"} {
+			if _, err := readResource(ctx, c, uri); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+				t.Errorf("Expected an error reading %s, got %v", uri, err)
+			}
+		}
+	})
+
+	t.Run("all the tools are allowed with a wildcard", func(t *testing.T) {
+		c, ctx := connect(t, "admin-secret")
+
+		tools, err := c.ListTools(ctx, mcp.ListToolsRequest{})
+		if err != nil {
+			t.Fatalf("Failed to list tools: %v", err)
+		}
+		if len(tools.Tools) != 2 {
+			t.Errorf("Expected 2 tools, got %v", tools.Tools)
+		}
+		if err := callTool(ctx, c, "delete_files"); err != nil {
+			t.Errorf("Unexpected error calling an allowed tool: %v", err)
+		}
+		if _, err := readResource(ctx, c, "files://content/etc"); err != nil {
+			t.Errorf("Unexpected error reading an allowed resource: %v", err)
+		}
+	})
+}
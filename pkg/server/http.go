@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	mcpserver "github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// DefaultHTTPPath is the default path of the Streamable HTTP endpoint
+	DefaultHTTPPath = "/mcp"
+
+	// DefaultKeepAliveInterval is the default interval between keep-alive pings
+	DefaultKeepAliveInterval = 30 * time.Second
+
+	// sseEndpoint and sseMessageEndpoint are the endpoints of the legacy SSE transport
+	sseEndpoint        = "/sse"
+	sseMessageEndpoint = "/message"
+)
+
+// HTTPConfig contains the configuration of the HTTP transports
+type HTTPConfig struct {
+	Address   string        // Address to bind to (empty for all the interfaces)
+	Port      int           // Port to listen on
+	Path      string        // Path of the Streamable HTTP endpoint (DefaultHTTPPath if empty)
+	KeepAlive time.Duration // Interval between keep-alive pings (0 disables them)
+
+	AuthTokensFile string // Path to the file with the accepted tokens (no authentication if empty)
+
+	TLSCertFile     string // Path to the server certificate (plain HTTP if empty)
+	TLSKeyFile      string // Path to the server private key
+	TLSClientCAFile string // Path to the CAs for verifying client certificates (optional, requires TLS)
+}
+
+// addr returns the address to listen on, in the host:port form
+func (c HTTPConfig) addr() string {
+	return net.JoinHostPort(c.Address, strconv.Itoa(c.Port))
+}
+
+// path returns the path of the Streamable HTTP endpoint, starting with a slash
+func (c HTTPConfig) path() string {
+	if c.Path == "" {
+		return DefaultHTTPPath
+	}
+	return "/" + strings.TrimPrefix(c.Path, "/")
+}
+
+// StartHTTP initializes the MCP server and serves it over HTTP (or HTTPS), using both
+// the Streamable HTTP transport (at the configured path) and the legacy SSE transport
+// (at /sse, with messages posted to /message) for older clients.
+//
+// Parameters:
+//   - httpCfg: The configuration of the HTTP transports
+//
+// Returns:
+//   - An error if server initialization fails or the HTTP server stops
+func (s *Server) StartHTTP(httpCfg HTTPConfig) error {
+	s.logger.Info("Initializing MCP HTTP server on %s", httpCfg.addr())
+
+	// Load the tokens before creating the server, as tools are filtered by token
+	if httpCfg.AuthTokensFile != "" {
+		auth, err := LoadAuthConfig(httpCfg.AuthTokensFile)
+		if err != nil {
+			s.logger.Error("Failed to load authentication tokens: %v", err)
+			return fmt.Errorf("failed to load authentication tokens: %w", err)
+		}
+		s.auth = auth
+		s.logger.Info("Authentication enabled with %d tokens", len(auth.Tokens))
+	} else {
+		s.logger.Info("Authentication disabled: anyone with access to the HTTP server can run the tools")
+	}
+
+	if err := s.CreateServer(); err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{
+		Addr:              httpCfg.addr(),
+		Handler:           s.newHTTPHandler(httpCfg),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	scheme := "http"
+	if httpCfg.TLSCertFile != "" {
+		reloader, err := newTLSReloader(httpCfg.TLSCertFile, httpCfg.TLSKeyFile, httpCfg.TLSClientCAFile, s.logger)
+		if err != nil {
+			s.logger.Error("Failed to load TLS configuration: %v", err)
+			return fmt.Errorf("failed to load TLS configuration: %w", err)
+		}
+		httpServer.TLSConfig = reloader.tlsConfig()
+		scheme = "https"
+
+		if httpCfg.TLSClientCAFile != "" {
+			s.logger.Info("Client certificates are required (mutual TLS)")
+		}
+	}
+
+	s.logger.Info("MCP Streamable HTTP endpoint: %s://%s%s", scheme, httpCfg.addr(), httpCfg.path())
+	s.logger.Info("MCP SSE endpoint (legacy): %s://%s%s", scheme, httpCfg.addr(), sseEndpoint)
+
+	var err error
+	if httpServer.TLSConfig != nil {
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		s.logger.Error("HTTP server error: %v", err)
+		return fmt.Errorf("HTTP server error: %w", err)
+	}
+
+	return nil
+}
+
+// newHTTPHandler creates the HTTP handler serving the Streamable HTTP and SSE transports
+func (s *Server) newHTTPHandler(httpCfg HTTPConfig) http.Handler {
+	streamableOpts := []mcpserver.StreamableHTTPOption{
+		mcpserver.WithEndpointPath(httpCfg.path()),
+	}
+	sseOpts := []mcpserver.SSEOption{
+		mcpserver.WithSSEEndpoint(sseEndpoint),
+		mcpserver.WithMessageEndpoint(sseMessageEndpoint),
+	}
+	if httpCfg.KeepAlive > 0 {
+		streamableOpts = append(streamableOpts, mcpserver.WithHeartbeatInterval(httpCfg.KeepAlive))
+		sseOpts = append(sseOpts, mcpserver.WithKeepAliveInterval(httpCfg.KeepAlive))
+	}
+
+	streamableServer := mcpserver.NewStreamableHTTPServer(s.mcpServer, streamableOpts...)
+	sseServer := mcpserver.NewSSEServer(s.mcpServer, sseOpts...)
+
+	mux := http.NewServeMux()
+	mux.Handle(httpCfg.path(), streamableServer)
+	mux.Handle(sseEndpoint, sseServer.SSEHandler())
+	mux.Handle(sseMessageEndpoint, sseServer.MessageHandler())
+
+	var handler http.Handler = mux
+	if s.auth != nil {
+		handler = s.requireAuth(handler)
+	}
+	handler = s.identifyClientCertificate(handler)
+
+	return s.logHTTPRequests(handler)
+}
+
+// logHTTPRequests logs all the HTTP requests received
+func (s *Server) logHTTPRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Debug("HTTP request from %s: %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+func TestServer_HTTPTransports(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `mcp:
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      params:
+        name:
+          type: string
+          description: "Name"
+          required: true
+      run:
+        command: "echo hello {{ .name }}"
+`
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	srv := New(Config{ConfigFile: configFile, Logger: logger, Version: "test"})
+	if err := srv.CreateServer(); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	httpServer := httptest.NewServer(srv.newHTTPHandler(HTTPConfig{KeepAlive: time.Second}))
+	defer httpServer.Close()
+
+	tests := []struct {
+		name      string
+		newClient func() (*client.Client, error)
+	}{
+		{
+			name: "Streamable HTTP",
+			newClient: func() (*client.Client, error) {
+				return client.NewStreamableHttpClient(httpServer.URL + DefaultHTTPPath)
+			},
+		},
+		{
+			name: "SSE",
+			newClient: func() (*client.Client, error) {
+				return client.NewSSEMCPClient(httpServer.URL + sseEndpoint)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			c, err := tt.newClient()
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			defer func() { _ = c.Close() }()
+
+			if err := c.Start(ctx); err != nil {
+				t.Fatalf("Failed to start client: %v", err)
+			}
+
+			initRequest := mcp.InitializeRequest{}
+			initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+			initRequest.Params.ClientInfo = mcp.Implementation{Name: "test", Version: "1.0"}
+			if _, err := c.Initialize(ctx, initRequest); err != nil {
+				t.Fatalf("Failed to initialize: %v", err)
+			}
+
+			tools, err := c.ListTools(ctx, mcp.ListToolsRequest{})
+			if err != nil {
+				t.Fatalf("Failed to list tools: %v", err)
+			}
+			if len(tools.Tools) != 1 || tools.Tools[0].Name != "hello" {
+				t.Fatalf("Expected tool 'hello', got %v", tools.Tools)
+			}
+
+			callRequest := mcp.CallToolRequest{}
+			callRequest.Params.Name = "hello"
+			callRequest.Params.Arguments = map[string]interface{}{"name": "world"}
+			result, err := c.CallTool(ctx, callRequest)
+			if err != nil {
+				t.Fatalf("Failed to call tool: %v", err)
+			}
+			if result.IsError || len(result.Content) != 1 {
+				t.Fatalf("Unexpected result: %+v", result)
+			}
+			if text, ok := result.Content[0].(mcp.TextContent); !ok || text.Text != "hello world" {
+				t.Errorf("Unexpected tool output: %+v", result.Content[0])
+			}
+		})
+	}
+}
+
+func TestHTTPConfig_path(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"", DefaultHTTPPath},
+		{"/api/mcp", "/api/mcp"},
+		{"mcp", "/mcp"},
+	}
+	for _, tt := range tests {
+		if got := (HTTPConfig{Path: tt.path}).path(); got != tt.expected {
+			t.Errorf("path() for %q = %q, expected %q", tt.path, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/inercia/MCPShell/pkg/command"
+	"github.com/inercia/MCPShell/pkg/config"
+)
+
+// Scopes of the limiters of the server
+const (
+	serverScope      = "in the server" // the concurrency limiter
+	usageServerScope = "the server"    // the rate limit and the quota
+)
+
+// limiterSet holds the limiters built while loading a configuration. They only replace
+// the limiters of the server (see setLimiters) once the configuration has been loaded
+// successfully, so a configuration rejected does not change the limits in use.
+type limiterSet struct {
+	concurrency map[string]*command.ConcurrencyLimiter // the concurrency limiters, indexed by scope
+	usage       map[string]*command.UsageLimiter       // the rate limits and quotas, indexed by scope
+}
+
+// newLimiterSet creates an empty set of limiters
+func newLimiterSet() *limiterSet {
+	return &limiterSet{
+		concurrency: map[string]*command.ConcurrencyLimiter{},
+		usage:       map[string]*command.UsageLimiter{},
+	}
+}
+
+// setLimiters replaces the limiters of the server with the limiters of a configuration
+// that has been loaded successfully
+func (s *Server) setLimiters(limiters *limiterSet) {
+	s.limitersMu.Lock()
+	defer s.limitersMu.Unlock()
+
+	s.limiters = limiters.concurrency
+	s.usage = limiters.usage
+}
+
+// concurrencyLimiters returns the concurrency limiters for the handler of a tool (or
+// a resource): the limiter of the tool and the limiter of the server, when they are
+// configured. Limiters are reused while their limits do not change, so the commands
+// still running are counted after the configuration is reloaded.
+//
+// Parameters:
+//   - limiters: The limiters of the configuration being loaded, where new limiters are added
+//   - run: The run configuration of the server
+//   - scope: The scope of the limiter of the tool (ie, "of tool 'x'")
+//   - maxConcurrent: The maximum number of commands of the tool running at the same time
+//
+// Returns:
+//   - The limiters, in the order they must be acquired
+func (s *Server) concurrencyLimiters(limiters *limiterSet, run config.MCPRunConfig, scope string, maxConcurrent int) []*command.ConcurrencyLimiter {
+	maxQueued, queueTimeout, err := run.GetQueueSettings()
+	if err != nil {
+		// the configuration has been validated before
+		s.logger.Error("Invalid run configuration, using the default queue settings: %v", err)
+		maxQueued, queueTimeout = config.DefaultMaxQueued, config.DefaultQueueTimeout
+	}
+
+	s.limitersMu.Lock()
+	defer s.limitersMu.Unlock()
+
+	limiter := func(scope string, maxConcurrent int) *command.ConcurrencyLimiter {
+		if maxConcurrent <= 0 {
+			return nil
+		}
+		if existing := limiters.concurrency[scope]; existing != nil {
+			return existing
+		}
+		if existing := s.limiters[scope]; existing.Matches(maxConcurrent, maxQueued, queueTimeout) {
+			limiters.concurrency[scope] = existing
+			return existing
+		}
+		s.logger.Debug("Limiting the commands running %s to %d", scope, maxConcurrent)
+		limiters.concurrency[scope] = command.NewConcurrencyLimiter(scope, maxConcurrent, maxQueued, queueTimeout, s.logger)
+		return limiters.concurrency[scope]
+	}
+
+	var result []*command.ConcurrencyLimiter
+	if l := limiter(scope, maxConcurrent); l != nil {
+		result = append(result, l)
+	}
+	if l := limiter(serverScope, run.MaxConcurrent); l != nil {
+		result = append(result, l)
+	}
+	return result
+}
+
+// usageLimiters returns the rate limits and quotas for the handler of a tool (or a
+// resource): the limiter of the tool and the limiter of the server, when they are
+// configured. Like the concurrency limiters, they are reused while their limits do
+// not change, so the usage is kept when the configuration is reloaded.
+//
+// Parameters:
+//   - limiters: The limiters of the configuration being loaded, where new limiters are added
+//   - run: The run configuration of the server
+//   - scope: The scope of the limiter of the tool (ie, "tool 'x'")
+//   - toolRun: The run configuration of the tool
+//
+// Returns:
+//   - The limiters
+//   - An error if some rate limit or quota is not valid
+func (s *Server) usageLimiters(limiters *limiterSet, run config.MCPRunConfig, scope string, toolRun config.MCPToolRunConfig) ([]*command.UsageLimiter, error) {
+	s.limitersMu.Lock()
+	defer s.limitersMu.Unlock()
+
+	limiter := func(scope string, rateLimit string, quota config.MCPQuotaConfig) (*command.UsageLimiter, error) {
+		if rateLimit == "" && quota.IsZero() {
+			return nil, nil
+		}
+		if existing := limiters.usage[scope]; existing != nil {
+			return existing, nil
+		}
+		if existing := s.usage[scope]; existing.Matches(rateLimit, quota) {
+			limiters.usage[scope] = existing
+			return existing, nil
+		}
+		l, err := command.NewUsageLimiter(scope, rateLimit, quota, s.logger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limits of %s: %w", scope, err)
+		}
+		limiters.usage[scope] = l
+		return l, nil
+	}
+
+	var result []*command.UsageLimiter
+	for _, limit := range []struct {
+		scope     string
+		rateLimit string
+		quota     config.MCPQuotaConfig
+	}{
+		{scope, toolRun.RateLimit, toolRun.Quota},
+		{usageServerScope, run.RateLimit, run.Quota},
+	} {
+		l, err := limiter(limit.scope, limit.rateLimit, limit.quota)
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+// forgetSession forgets the rate limits and quotas of a session that has been closed
+func (s *Server) forgetSession(session string) {
+	s.limitersMu.Lock()
+	defer s.limitersMu.Unlock()
+
+	for _, limiter := range s.usage {
+		limiter.Forget(session)
+	}
+}
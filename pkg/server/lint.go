@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/inercia/MCPShell/pkg/common"
+	"github.com/inercia/MCPShell/pkg/config"
+)
+
+// checkParamsEnv checks the environment variables where the arguments of a tool (or
+// resource) are exported, warning about the parameters that are also interpolated in
+// the command (as exporting them is usually done for avoiding the interpolation).
+func (s *Server) checkParamsEnv(cfg config.MCPToolConfig, kind string) error {
+	interpolated, err := cfg.CheckParamsEnv()
+	if err != nil {
+		s.logger.Error("Invalid environment variables for %s '%s': %v", kind, cfg.Name, err)
+		return fmt.Errorf("invalid environment variables for %s '%s': %w", kind, cfg.Name, err)
+	}
+	for _, name := range interpolated {
+		s.logger.Warn("Parameter '%s' of %s '%s' is exported as an environment variable but also interpolated in the command",
+			name, kind, cfg.Name)
+	}
+	return nil
+}
+
+// checkReservedParams checks the parameters of a tool (or resource) do not use the
+// names reserved for the arguments added by MCPShell (ie, `_nocache` in cached tools).
+func (s *Server) checkReservedParams(cfg config.MCPToolConfig, kind string) error {
+	if _, exists := cfg.Params[common.NoCacheArgument]; exists && cfg.Run.Cache != nil {
+		s.logger.Error("Invalid parameters for %s '%s': parameter name '%s' is reserved", kind, cfg.Name, common.NoCacheArgument)
+		return fmt.Errorf("parameter name '%s' is reserved in tools with a cache (%s '%s')", common.NoCacheArgument, kind, cfg.Name)
+	}
+	return nil
+}
+
+// checkShellQuoting warns about the string parameters of a tool (or resource) that
+// are interpolated in the command without a shell quoting function and that are
+// not restricted by any validation or constraint, as they could inject shell code.
+func (s *Server) checkShellQuoting(cfg config.MCPToolConfig, kind string) {
+	unquoted, err := cfg.GetUnquotedParams()
+	if err != nil {
+		s.logger.Warn("Cannot check the quoting in the command template of %s '%s': %v", kind, cfg.Name, err)
+		return
+	}
+	for _, name := range unquoted {
+		s.logger.Warn("Parameter '%s' of %s '%s' is interpolated in the command without quoting and without "+
+			"any restriction: use '{{ shellquote .%s }}' or add a constraint", name, kind, cfg.Name, name)
+	}
+}
+
+// checkTemplateFunctions checks that the templates of a tool (or resource) do not
+// use restricted functions (ie, `env`) that have not been allowed in the configuration.
+func (s *Server) checkTemplateFunctions(cfg config.MCPToolConfig, kind string, allowed []string) error {
+	for _, text := range cfg.GetTemplates() {
+		disallowed, err := common.DisallowedTemplateFunctions(text, allowed)
+		if err != nil {
+			// invalid templates are reported when they are processed
+			s.logger.Debug("Cannot check the functions in a template of %s '%s': %v", kind, cfg.Name, err)
+			continue
+		}
+		if len(disallowed) > 0 {
+			s.logger.Error("The templates of %s '%s' use restricted functions: %s", kind, cfg.Name, strings.Join(disallowed, ", "))
+			return fmt.Errorf("%s '%s' uses restricted template functions: %s (they must be allowed in mcp.run.template_functions)",
+				kind, cfg.Name, strings.Join(disallowed, ", "))
+		}
+	}
+	return nil
+}
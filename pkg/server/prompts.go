@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// createPrompts creates the MCP prompts for all the prompts in the configuration:
+// the default prompt (from the top-level system and user prompts) and the named prompts.
+func (s *Server) createPrompts(prompts common.PromptsConfig) []mcpserver.ServerPrompt {
+	var serverPrompts []mcpserver.ServerPrompt
+
+	for _, prompt := range prompts.GetPrompts() {
+		opts := []mcp.PromptOption{mcp.WithPromptDescription(prompt.Description)}
+		for _, arg := range prompt.Arguments {
+			argOpts := []mcp.ArgumentOption{mcp.ArgumentDescription(arg.Description)}
+			if arg.Required {
+				argOpts = append(argOpts, mcp.RequiredArgument())
+			}
+			opts = append(opts, mcp.WithArgument(arg.Name, argOpts...))
+		}
+
+		serverPrompts = append(serverPrompts, mcpserver.ServerPrompt{
+			Prompt:  mcp.NewPrompt(prompt.Name, opts...),
+			Handler: s.promptHandler(prompt),
+		})
+		s.logger.Info("Registered prompt: '%s'", prompt.Name)
+	}
+
+	return serverPrompts
+}
+
+// promptHandler returns the handler for getting a prompt.
+//
+// MCP prompts only have user and assistant messages, so the system prompts
+// are returned in a first user message, followed by the user prompts.
+func (s *Server) promptHandler(prompt common.PromptConfig) mcpserver.PromptHandlerFunc {
+	return func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		s.logger.Debug("Prompt '%s' requested with arguments: %v", prompt.Name, request.Params.Arguments)
+
+		system, user, err := prompt.Render(request.Params.Arguments)
+		if err != nil {
+			s.logger.Error("Failed to render prompt '%s': %v", prompt.Name, err)
+			return nil, err
+		}
+
+		var messages []mcp.PromptMessage
+		if system != "" {
+			messages = append(messages, mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(system)))
+		}
+		if user != "" {
+			messages = append(messages, mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(user)))
+		}
+
+		return mcp.NewGetPromptResult(prompt.Description, messages), nil
+	}
+}
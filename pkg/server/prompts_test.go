@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+func TestServer_Prompts(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `prompts:
+  system:
+    - "You are a helpful assistant."
+  named:
+    - name: "debug_pod"
+      description: "Debug a failing pod"
+      arguments:
+        - name: "pod"
+          description: "Name of the pod"
+          required: true
+      system:
+        - "You are a Kubernetes expert."
+      user:
+        - "Find out why the pod {{ .pod }} is failing."
+mcp:
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+`
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	srv := New(Config{ConfigFile: configFile, Logger: logger})
+	if err := srv.CreateServer(); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	prompts := srv.mcpServer.ListPrompts()
+	if len(prompts) != 2 || prompts[common.DefaultPromptName] == nil || prompts["debug_pod"] == nil {
+		t.Fatalf("Expected prompts 'default' and 'debug_pod', got %v", prompts)
+	}
+
+	debugPod := prompts["debug_pod"]
+	if len(debugPod.Prompt.Arguments) != 1 || !debugPod.Prompt.Arguments[0].Required {
+		t.Errorf("Expected a required 'pod' argument, got %v", debugPod.Prompt.Arguments)
+	}
+
+	request := mcp.GetPromptRequest{}
+	request.Params.Name = "debug_pod"
+	request.Params.Arguments = map[string]string{"pod": "web-1"}
+	result, err := debugPod.Handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Failed to get prompt: %v", err)
+	}
+	if result.Description != "Debug a failing pod" {
+		t.Errorf("Expected the prompt description, got %q", result.Description)
+	}
+	want := []string{"You are a Kubernetes expert.", "Find out why the pod web-1 is failing."}
+	if len(result.Messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %d", len(want), len(result.Messages))
+	}
+	for i, message := range result.Messages {
+		text, ok := message.Content.(mcp.TextContent)
+		if !ok || text.Text != want[i] || message.Role != mcp.RoleUser {
+			t.Errorf("Message %d = %+v, want user message %q", i, message, want[i])
+		}
+	}
+
+	request.Params.Arguments = map[string]string{}
+	if _, err := debugPod.Handler(context.Background(), request); err == nil {
+		t.Error("Expected an error when a required argument is missing")
+	}
+
+	// prompts are updated on reload
+	updated := filepath.Join(t.TempDir(), "updated.yaml")
+	if err := os.WriteFile(updated, []byte(`mcp:
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+`), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if err := srv.Reload(updated); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if prompts := srv.mcpServer.ListPrompts(); len(prompts) != 0 {
+		t.Errorf("Expected no prompts after reload, got %v", prompts)
+	}
+}
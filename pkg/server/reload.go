@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	mcpserver "github.com/mark3labs/mcp-go/server"
+
+	"github.com/inercia/MCPShell/pkg/config"
+)
+
+// Reload loads the configuration file again and updates the tools, prompts and resources
+// of the running server. Tools that have been removed from the configuration are removed
+// from the server, and new or modified tools are (re)registered. Clients are notified of
+// the changes with list_changed notifications.
+//
+// When the new configuration is not valid, the error is returned and the server keeps
+// the previous tools.
+//
+// Parameters:
+//   - configFile: Path to the new configuration file
+//
+// Returns:
+//   - An error if the configuration could not be loaded or is not valid
+func (s *Server) Reload(configFile string) error {
+	s.mu.Lock()
+	initialized := s.mcpServer != nil
+	s.mu.Unlock()
+	if !initialized {
+		return fmt.Errorf("server not initialized")
+	}
+
+	s.logger.Info("Reloading configuration file: %s", configFile)
+
+	cfg, err := config.NewConfigFromFile(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if err := s.validateTools(cfg); err != nil {
+		return err
+	}
+
+	if err := cfg.Prompts.Validate(); err != nil {
+		return fmt.Errorf("invalid prompts: %w", err)
+	}
+
+	// the limiters only replace the current ones when the new configuration is valid
+	limiters := newLimiterSet()
+	serverTools, toolConfigs, err := s.createTools(cfg, limiters)
+	if err != nil {
+		return err
+	}
+
+	resources, templates, err := s.createResources(cfg, limiters)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed []string
+	for name := range s.tools {
+		if _, exists := toolConfigs[name]; !exists {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+
+	// changes in the run configuration (ie, the template functions or the concurrency
+	// limits) affect the handlers of all the tools and resources
+	runChanged := !reflect.DeepEqual(s.run, cfg.MCP.Run)
+
+	var changed []mcpserver.ServerTool
+	for _, tool := range serverTools {
+		previous, exists := s.tools[tool.Tool.Name]
+		if !exists || runChanged || !reflect.DeepEqual(previous, toolConfigs[tool.Tool.Name]) {
+			changed = append(changed, tool)
+		}
+	}
+
+	if len(removed) > 0 {
+		s.logger.Info("Removing tools: %v", removed)
+		s.mcpServer.DeleteTools(removed...)
+	}
+	if len(changed) > 0 {
+		for _, tool := range changed {
+			s.logger.Info("Updating tool: '%s'", tool.Tool.Name)
+		}
+		s.mcpServer.AddTools(changed...)
+	}
+
+	if runChanged || !reflect.DeepEqual(s.resources, cfg.MCP.Resources) {
+		s.logger.Info("Updating resources")
+		s.mcpServer.SetResources(resources...)
+		s.mcpServer.SetResourceTemplates(templates...)
+		s.resources = cfg.MCP.Resources
+	}
+
+	if !reflect.DeepEqual(s.prompts, cfg.Prompts) {
+		s.logger.Info("Updating prompts")
+		s.mcpServer.SetPrompts(s.createPrompts(cfg.Prompts)...)
+		s.prompts = cfg.Prompts
+	}
+
+	s.tools = toolConfigs
+	s.run = cfg.MCP.Run
+	s.configFile = configFile
+	s.setLimiters(limiters)
+
+	s.logger.Info("Configuration reloaded: %d tools removed, %d tools added or updated", len(removed), len(changed))
+	return nil
+}
+
+// Watch watches the configuration paths (as provided with the --tools flag) and
+// reloads the server every time they change. The paths are resolved again on every
+// change, so new files in configuration directories are also loaded. Invalid
+// configurations are logged and ignored. It blocks until the context is cancelled.
+//
+// Parameters:
+//   - ctx: Context for stopping the watcher
+//   - paths: The configuration paths (files, directories or URLs)
+//   - interval: The interval for checking the files for changes
+func (s *Server) Watch(ctx context.Context, paths []string, interval time.Duration) {
+	// cleanup of the configuration resolved in the last successful reload (the
+	// initial configuration is owned by the caller)
+	cleanup := func() {}
+	defer func() { cleanup() }()
+
+	watcher := config.NewWatcher(paths, interval, s.logger)
+	watcher.Watch(ctx, func() {
+		configFile, newCleanup, err := config.ResolveMultipleConfigPaths(paths, s.logger)
+		if err != nil {
+			s.logger.Error("Failed to resolve the new configuration, keeping the previous one: %v", err)
+			return
+		}
+
+		if err := s.Reload(configFile); err != nil {
+			s.logger.Error("Invalid configuration, keeping the previous one: %v", err)
+			newCleanup()
+			return
+		}
+
+		cleanup()
+		cleanup = newCleanup
+	})
+}
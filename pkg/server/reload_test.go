@@ -0,0 +1,253 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/inercia/MCPShell/pkg/common"
+	"github.com/inercia/MCPShell/pkg/config"
+)
+
+func TestServer_Reload(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	writeConfig := func(name string, content string) string {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		return path
+	}
+
+	initial := writeConfig("initial.yaml", `mcp:
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+    - name: "bye"
+      description: "Say bye"
+      run:
+        command: "echo bye"
+`)
+
+	srv := New(Config{ConfigFile: initial, Logger: logger})
+	if err := srv.Reload(initial); err == nil {
+		t.Error("Expected an error when reloading a server that has not been created")
+	}
+	if err := srv.CreateServer(); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	updated := writeConfig("updated.yaml", `mcp:
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+    - name: "greet"
+      description: "Greet someone"
+      params:
+        name:
+          type: string
+          description: "Name"
+      run:
+        command: "echo hi {{ .name }}"
+`)
+	if err := srv.Reload(updated); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+
+	tools := srv.mcpServer.ListTools()
+	if len(tools) != 2 || tools["hello"] == nil || tools["greet"] == nil {
+		t.Errorf("Expected tools 'hello' and 'greet' after reload, got %v", tools)
+	}
+
+	// invalid configurations must keep the previous tools
+	invalid := writeConfig("invalid.yaml", `mcp:
+  tools:
+    - name: "greet"
+      description: "Greet someone"
+      constraints:
+        - "name.invalid()"
+      params:
+        name:
+          type: string
+          description: "Name"
+      run:
+        command: "echo hi {{ .name }}"
+`)
+	if err := srv.Reload(invalid); err == nil {
+		t.Fatal("Expected an error when reloading an invalid configuration")
+	}
+	if tools := srv.mcpServer.ListTools(); len(tools) != 2 || tools["hello"] == nil {
+		t.Errorf("Expected the previous tools to be kept, got %v", tools)
+	}
+	if srv.configFile != updated {
+		t.Errorf("Expected config file to be %s, got %s", updated, srv.configFile)
+	}
+}
+
+func TestServer_ReloadConcurrencyLimiters(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	writeConfig := func(name string, content string) string {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		return path
+	}
+	configWithLimits := func(maxConcurrent int) string {
+		return fmt.Sprintf(`mcp:
+  run:
+    max_concurrent: %d
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+        max_concurrent: 1
+`, maxConcurrent)
+	}
+
+	initial := writeConfig("initial.yaml", configWithLimits(4))
+	srv := New(Config{ConfigFile: initial, Logger: logger})
+	if err := srv.CreateServer(); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverLimiter, toolLimiter := srv.limiters[serverScope], srv.limiters["of tool 'hello'"]
+	if serverLimiter == nil || toolLimiter == nil {
+		t.Fatalf("Expected limiters for the server and the tool, got %v", srv.limiters)
+	}
+
+	// the limiters are kept while their limits do not change
+	if err := srv.Reload(writeConfig("same.yaml", configWithLimits(4))); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if srv.limiters[serverScope] != serverLimiter || srv.limiters["of tool 'hello'"] != toolLimiter {
+		t.Error("Expected the limiters to be reused when the limits do not change")
+	}
+
+	if err := srv.Reload(writeConfig("updated.yaml", configWithLimits(2))); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if !srv.limiters[serverScope].Matches(2, config.DefaultMaxQueued, config.DefaultQueueTimeout) {
+		t.Error("Expected a new limiter for the server with the new limits")
+	}
+	if srv.run.MaxConcurrent != 2 {
+		t.Errorf("Expected the new run configuration, got %+v", srv.run)
+	}
+
+	// invalid queue settings are rejected
+	invalid := writeConfig("invalid.yaml", `mcp:
+  run:
+    queue_timeout: "soon"
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+`)
+	if err := srv.Reload(invalid); err == nil {
+		t.Error("Expected an error for an invalid queue_timeout")
+	}
+
+	// the limiters are not changed when a resource makes the reload fail
+	limiters, usage := maps.Clone(srv.limiters), maps.Clone(srv.usage)
+	badResource := writeConfig("bad-resource.yaml", `mcp:
+  run:
+    max_concurrent: 8
+    rate_limit: "10/min"
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+        max_concurrent: 3
+  resources:
+    - uri: "hello://world"
+      name: "world"
+      run:
+        command: "echo world"
+      output:
+        keep: "middle"
+`)
+	if err := srv.Reload(badResource); err == nil {
+		t.Fatal("Expected an error for an invalid resource")
+	}
+	if !maps.Equal(srv.limiters, limiters) || !maps.Equal(srv.usage, usage) {
+		t.Errorf("Expected the limiters not to change, got %v and %v", srv.limiters, srv.usage)
+	}
+	if !srv.limiters[serverScope].Matches(2, config.DefaultMaxQueued, config.DefaultQueueTimeout) {
+		t.Error("Expected the limiter of the server to keep the previous limits")
+	}
+}
+
+func TestServer_ReloadUsageLimiters(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	writeConfig := func(name string, content string) string {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		return path
+	}
+	configWithLimits := func(rateLimit string) string {
+		return fmt.Sprintf(`mcp:
+  run:
+    rate_limit: %q
+    quota:
+      max_calls: 1000
+      per: day
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+        quota:
+          max_runtime: "10m"
+`, rateLimit)
+	}
+
+	initial := writeConfig("initial.yaml", configWithLimits("10/min"))
+	srv := New(Config{ConfigFile: initial, Logger: logger})
+	if err := srv.CreateServer(); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverLimiter, toolLimiter := srv.usage[usageServerScope], srv.usage["tool 'hello'"]
+	if serverLimiter == nil || toolLimiter == nil {
+		t.Fatalf("Expected limiters for the server and the tool, got %v", srv.usage)
+	}
+
+	// the usage is kept while the limits do not change
+	if err := srv.Reload(writeConfig("updated.yaml", configWithLimits("20/min"))); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if srv.usage[usageServerScope] == serverLimiter {
+		t.Error("Expected a new limiter for the server with the new rate limit")
+	}
+	if srv.usage["tool 'hello'"] != toolLimiter {
+		t.Error("Expected the limiter of the tool to be reused")
+	}
+
+	if err := srv.Reload(writeConfig("invalid.yaml", configWithLimits("20 per minute"))); err == nil {
+		t.Error("Expected an error for an invalid rate_limit")
+	}
+}
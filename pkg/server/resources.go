@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+
+	"github.com/inercia/MCPShell/pkg/command"
+	"github.com/inercia/MCPShell/pkg/common"
+	"github.com/inercia/MCPShell/pkg/config"
+)
+
+// validateResources validates the resources in the configuration: their URIs,
+// parameters, constraints and commands.
+func (s *Server) validateResources(cfg *config.ToolsConfig) error {
+	uris := map[string]bool{}
+	for _, resource := range cfg.MCP.Resources {
+		if err := resource.Validate(); err != nil {
+			s.logger.Error("Invalid resource: %v", err)
+			return err
+		}
+		if uris[resource.GetURI()] {
+			return fmt.Errorf("duplicate resource '%s'", resource.GetURI())
+		}
+		uris[resource.GetURI()] = true
+
+		params := resource.GetParams()
+		if len(resource.Constraints) > 0 {
+			if _, err := common.NewCompiledConstraints(resource.Constraints, params, s.logger); err != nil {
+				s.logger.Error("Failed to compile constraints for resource '%s': %v", resource.Name, err)
+				return fmt.Errorf("constraint compilation error for resource '%s': %w", resource.Name, err)
+			}
+		}
+		if _, err := common.NewParamValidator(params); err != nil {
+			s.logger.Error("Invalid parameters for resource '%s': %v", resource.Name, err)
+			return fmt.Errorf("parameter validation error for resource '%s': %w", resource.Name, err)
+		}
+
+		if err := resource.Output.Validate(); err != nil {
+			s.logger.Error("Invalid output configuration for resource '%s': %v", resource.Name, err)
+			return fmt.Errorf("invalid output configuration for resource '%s': %w", resource.Name, err)
+		}
+
+		tool, _ := resource.GetTool()
+		if err := s.checkReservedParams(tool.Config, "resource"); err != nil {
+			return err
+		}
+		if err := s.checkParamsEnv(tool.Config, "resource"); err != nil {
+			return err
+		}
+		if err := s.checkTemplateFunctions(tool.Config, "resource", cfg.MCP.Run.TemplateFunctions); err != nil {
+			return err
+		}
+		s.checkShellQuoting(tool.Config, "resource")
+
+		s.logger.Info("Validated resource: '%s' (%s)", resource.Name, resource.GetURI())
+	}
+
+	return nil
+}
+
+// createResources creates the MCP resources and resource templates for the resources
+// in the configuration. Resources without a runner meeting its prerequisites are skipped.
+//
+// Parameters:
+//   - cfg: The configuration
+//   - limiters: The limiters of the configuration, where the limiters of the resources are added
+//
+// Returns:
+//   - The resources with a fixed URI
+//   - The resource templates
+//   - An error if the resources are not valid
+func (s *Server) createResources(cfg *config.ToolsConfig, limiters *limiterSet) ([]mcpserver.ServerResource, []mcpserver.ServerResourceTemplate, error) {
+	if err := s.validateResources(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	var resources []mcpserver.ServerResource
+	var templates []mcpserver.ServerResourceTemplate
+
+	for _, resource := range cfg.MCP.Resources {
+		tool, ok := resource.GetTool()
+		if !ok {
+			s.logger.Info("Resource '%s' was skipped due to unmet prerequisites", resource.Name)
+			continue
+		}
+
+		cmdHandler, err := command.NewCommandHandler(tool, tool.Config.Params, s.shell, s.logger)
+		if err != nil {
+			s.logger.Error("Failed to create handler for resource '%s': %v", resource.Name, err)
+			return nil, nil, fmt.Errorf("failed to create handler for resource '%s': %w", resource.Name, err)
+		}
+		cmdHandler.SetAuditLogger(s.audit)
+		cmdHandler.SetTemplateFunctions(cfg.MCP.Run.TemplateFunctions)
+		cmdHandler.SetConcurrencyLimiters(s.concurrencyLimiters(limiters, cfg.MCP.Run, fmt.Sprintf("of resource '%s'", resource.Name), tool.Config.Run.MaxConcurrent)...)
+		cmdHandler.SetLockManager(s.locks)
+		usageLimiters, err := s.usageLimiters(limiters, cfg.MCP.Run, fmt.Sprintf("resource '%s'", resource.Name), tool.Config.Run)
+		if err != nil {
+			s.logger.Error("Failed to create handler for resource '%s': %v", resource.Name, err)
+			return nil, nil, err
+		}
+		cmdHandler.SetUsageLimiters(usageLimiters...)
+		handler := s.wrapResourceHandlerWithPanicRecovery(cmdHandler.GetMCPResourceHandler(resource.MIMEType))
+		if s.auth != nil {
+			handler = s.requireResourceToken(resource.Name, resource.Tags, handler)
+		}
+
+		if resource.IsTemplate() {
+			var opts []mcp.ResourceTemplateOption
+			if resource.Description != "" {
+				opts = append(opts, mcp.WithTemplateDescription(resource.Description))
+			}
+			if resource.MIMEType != "" {
+				opts = append(opts, mcp.WithTemplateMIMEType(resource.MIMEType))
+			}
+			templates = append(templates, mcpserver.ServerResourceTemplate{
+				Template: mcp.NewResourceTemplate(resource.URITemplate, resource.Name, opts...),
+				Handler:  mcpserver.ResourceTemplateHandlerFunc(handler),
+			})
+		} else {
+			var opts []mcp.ResourceOption
+			if resource.Description != "" {
+				opts = append(opts, mcp.WithResourceDescription(resource.Description))
+			}
+			if resource.MIMEType != "" {
+				opts = append(opts, mcp.WithMIMEType(resource.MIMEType))
+			}
+			resources = append(resources, mcpserver.ServerResource{
+				Resource: mcp.NewResource(resource.URI, resource.Name, opts...),
+				Handler:  handler,
+			})
+		}
+
+		s.logger.Info("Registered resource: '%s' (%s)", resource.Name, resource.GetURI())
+	}
+
+	return resources, templates, nil
+}
+
+// wrapResourceHandlerWithPanicRecovery adds panic recovery to a resource handler
+func (s *Server) wrapResourceHandlerWithPanicRecovery(handler mcpserver.ResourceHandlerFunc) mcpserver.ResourceHandlerFunc {
+	return func(ctx context.Context, request mcp.ReadResourceRequest) (contents []mcp.ResourceContents, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				common.RecoverPanic()
+				err = fmt.Errorf("resource read failed: internal server error")
+			}
+		}()
+
+		return handler(ctx, request)
+	}
+}
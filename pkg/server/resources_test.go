@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+func TestServer_Resources(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `mcp:
+  tools:
+    - name: "hello"
+      description: "Say hello"
+      run:
+        command: "echo hello"
+  resources:
+    - uri: "test://status"
+      name: "status"
+      description: "Current status"
+      mime_type: "text/plain"
+      run:
+        command: "echo all good"
+    - uri_template: "test://greetings/{name}"
+      name: "greeting"
+      description: "Greeting for someone"
+      params:
+        name:
+          type: string
+          pattern: "^[a-z]+$"
+      constraints:
+        - "name != 'root'"
+      run:
+        command: "echo hello {{ .name }}"
+`
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	srv := New(Config{ConfigFile: configFile, Logger: logger})
+	if err := srv.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+	if err := srv.CreateServer(); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if resources := srv.mcpServer.ListResources(); len(resources) != 1 || resources["test://status"] == nil {
+		t.Errorf("Expected resource 'test://status', got %v", resources)
+	}
+
+	// send a request to the server, returning the result (or the error message)
+	send := func(method string, params interface{}) (json.RawMessage, string) {
+		message, err := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"method":  method,
+			"params":  params,
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %v", err)
+		}
+		response, err := json.Marshal(srv.mcpServer.HandleMessage(context.Background(), message))
+		if err != nil {
+			t.Fatalf("Failed to marshal response: %v", err)
+		}
+		var decoded struct {
+			Result json.RawMessage `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(response, &decoded); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if decoded.Error != nil {
+			return nil, decoded.Error.Message
+		}
+		return decoded.Result, ""
+	}
+
+	result, errMsg := send(string(mcp.MethodResourcesTemplatesList), map[string]interface{}{})
+	if errMsg != "" || !strings.Contains(string(result), `"uriTemplate":"test://greetings/{name}"`) {
+		t.Errorf("Expected the resource template to be listed, got %s (%s)", result, errMsg)
+	}
+
+	tests := []struct {
+		name     string
+		uri      string
+		wantText string
+		wantErr  string
+	}{
+		{name: "fixed uri", uri: "test://status", wantText: "all good"},
+		{name: "uri template", uri: "test://greetings/alice", wantText: "hello alice"},
+		{name: "invalid argument", uri: "test://greetings/Alice", wantErr: "does not match the pattern"},
+		{name: "constraint failed", uri: "test://greetings/root", wantErr: "blocked by constraints"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, errMsg := send(string(mcp.MethodResourcesRead), map[string]interface{}{"uri": tt.uri})
+			if tt.wantErr != "" {
+				if !strings.Contains(errMsg, tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %q (result %s)", tt.wantErr, errMsg, result)
+				}
+				return
+			}
+			if errMsg != "" {
+				t.Fatalf("Unexpected error: %s", errMsg)
+			}
+
+			var read struct {
+				Contents []struct {
+					URI  string `json:"uri"`
+					Text string `json:"text"`
+				} `json:"contents"`
+			}
+			if err := json.Unmarshal(result, &read); err != nil {
+				t.Fatalf("Failed to decode result: %v", err)
+			}
+			if len(read.Contents) != 1 || read.Contents[0].URI != tt.uri || read.Contents[0].Text != tt.wantText {
+				t.Errorf("Expected contents %q for %s, got %+v", tt.wantText, tt.uri, read.Contents)
+			}
+		})
+	}
+}
@@ -6,14 +6,13 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"sync"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	mcpserver "github.com/mark3labs/mcp-go/server"
 
+	"github.com/inercia/MCPShell/pkg/audit"
 	"github.com/inercia/MCPShell/pkg/command"
 	"github.com/inercia/MCPShell/pkg/common"
 	"github.com/inercia/MCPShell/pkg/config"
@@ -29,6 +28,21 @@ type Server struct {
 
 	mcpServer *mcpserver.MCPServer // MCP server instance
 
+	mu    sync.Mutex                      // protects the tools and the config file on reloads
+	tools map[string]config.MCPToolConfig // configuration of the registered tools, indexed by name
+
+	prompts   common.PromptsConfig       // the registered prompts
+	resources []config.MCPResourceConfig // the registered resources (and resource templates)
+	run       config.MCPRunConfig        // the run configuration of the registered tools and resources
+
+	limitersMu sync.Mutex                             // protects the limiters
+	limiters   map[string]*command.ConcurrencyLimiter // the concurrency limiters, indexed by scope
+	usage      map[string]*command.UsageLimiter       // the rate limits and quotas, indexed by scope
+	locks      *command.LockManager                   // the locks held while the commands run, shared by all the tools
+
+	auth *AuthConfig // tokens accepted in HTTP mode (nil when authentication is disabled)
+
+	audit  *audit.Logger // audit log of the tool invocations (nil when disabled)
 	logger *common.Logger
 }
 
@@ -41,6 +55,7 @@ type Config struct {
 	Descriptions        []string       // Descriptions shown to AI clients (can be specified multiple times)
 	DescriptionFiles    []string       // Paths to files containing descriptions (can be specified multiple times)
 	DescriptionOverride bool           // Whether to override the description in the config file
+	AuditLogger         *audit.Logger  // Audit log for recording all the tool invocations (optional)
 }
 
 // New creates a new Server instance with the provided configuration
@@ -73,6 +88,8 @@ func New(cfg Config) *Server {
 		logger:      cfg.Logger,
 		version:     cfg.Version,
 		description: finalDescription,
+		audit:       cfg.AuditLogger,
+		locks:       command.NewLockManager(cfg.Logger),
 	}
 }
 
@@ -92,6 +109,26 @@ func (s *Server) Validate() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	if err := s.validateTools(cfg); err != nil {
+		return err
+	}
+
+	if err := cfg.Prompts.Validate(); err != nil {
+		s.logger.Error("Invalid prompts: %v", err)
+		return fmt.Errorf("invalid prompts: %w", err)
+	}
+
+	if err := s.validateResources(cfg); err != nil {
+		return err
+	}
+
+	s.logger.Info("Configuration validation successful")
+	return nil
+}
+
+// validateTools checks the tools in a configuration: constraints, parameter
+// validations and command templates.
+func (s *Server) validateTools(cfg *config.ToolsConfig) error {
 	// Check if there are any tools defined
 	if len(cfg.MCP.Tools) == 0 {
 		s.logger.Error("No tools defined in the configuration file")
@@ -100,6 +137,18 @@ func (s *Server) Validate() error {
 
 	s.logger.Info("Found %d tools in configuration", len(cfg.MCP.Tools))
 
+	// Check the restricted template functions allowed
+	if err := common.ValidateTemplateFunctions(cfg.MCP.Run.TemplateFunctions); err != nil {
+		s.logger.Error("Invalid template functions: %v", err)
+		return fmt.Errorf("invalid template_functions: %w", err)
+	}
+
+	// Check the concurrency limits, the rate limit and the quota of the server
+	if err := cfg.MCP.Run.Validate(); err != nil {
+		s.logger.Error("Invalid run configuration: %v", err)
+		return fmt.Errorf("invalid run configuration: %w", err)
+	}
+
 	// Use shell from config if present and no shell is explicitly set
 	shell := s.shell
 	if shell == "" && cfg.MCP.Run.Shell != "" {
@@ -156,12 +205,42 @@ func (s *Server) Validate() error {
 			s.logger.Debug("All constraints for tool '%s' compiled successfully", toolDef.MCPTool.Name)
 		}
 
-		// Validate command template
-		if toolDef.Config.Run.Command == "" {
-			s.logger.Error("Empty command template for tool '%s'", toolDef.MCPTool.Name)
-			return fmt.Errorf("empty command template for tool '%s'", toolDef.MCPTool.Name)
+		// Validate the declarative parameter validations (ie, patterns)
+		if _, err := common.NewParamValidator(paramTypes); err != nil {
+			s.logger.Error("Invalid parameters for tool '%s': %v", toolDef.MCPTool.Name, err)
+			return fmt.Errorf("parameter validation error for tool '%s': %w", toolDef.MCPTool.Name, err)
+		}
+
+		// Validate the output configuration
+		if err := toolDef.Config.Output.Validate(); err != nil {
+			s.logger.Error("Invalid output configuration for tool '%s': %v", toolDef.MCPTool.Name, err)
+			return fmt.Errorf("invalid output configuration for tool '%s': %w", toolDef.MCPTool.Name, err)
 		}
 
+		// Validate the command (or argv) template
+		if err := toolDef.Config.Run.Validate(); err != nil {
+			s.logger.Error("Invalid run configuration for tool '%s': %v", toolDef.MCPTool.Name, err)
+			return fmt.Errorf("%w for tool '%s'", err, toolDef.MCPTool.Name)
+		}
+
+		// Check the parameters do not use reserved names
+		if err := s.checkReservedParams(toolDef.Config, "tool"); err != nil {
+			return err
+		}
+
+		// Check the parameters exported as environment variables
+		if err := s.checkParamsEnv(toolDef.Config, "tool"); err != nil {
+			return err
+		}
+
+		// Check the templates do not use restricted functions
+		if err := s.checkTemplateFunctions(toolDef.Config, "tool", cfg.MCP.Run.TemplateFunctions); err != nil {
+			return err
+		}
+
+		// Check the parameters interpolated in the command without quoting
+		s.checkShellQuoting(toolDef.Config, "tool")
+
 		// Format constraint information for display
 		var constraintInfo string
 		if len(toolDef.Config.Constraints) > 0 {
@@ -173,7 +252,6 @@ func (s *Server) Validate() error {
 		s.logger.Info("Validated tool: '%s'%s", toolDef.MCPTool.Name, constraintInfo)
 	}
 
-	s.logger.Info("Configuration validation successful")
 	return nil
 }
 
@@ -193,7 +271,7 @@ func (s *Server) Start() error {
 	s.logger.Info("Starting MCP server with stdio handler")
 
 	// Start the stdio server
-	if err := mcpserver.ServeStdio(s.mcpServer); err != nil {
+	if err := s.serveStdio(); err != nil {
 		s.logger.Error("Server error: %v", err)
 		return fmt.Errorf("server error: %v", err)
 	}
@@ -226,24 +304,92 @@ func (s *Server) CreateServer() error {
 		options = append(options, mcpserver.WithInstructions(s.description))
 	}
 
+	// Tools can change on configuration reloads, so clients are notified with tools/list_changed
+	options = append(options, mcpserver.WithToolCapabilities(true))
+	options = append(options, mcpserver.WithPromptCapabilities(true))
+	options = append(options, mcpserver.WithResourceCapabilities(false, true))
+
+	// Forget the rate limits and quotas of the sessions closed
+	hooks := &mcpserver.Hooks{}
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session mcpserver.ClientSession) {
+		s.forgetSession(session.SessionID())
+	})
+
+	// Only show (and allow calling) the tools and resources allowed for the token of each request
+	if s.auth != nil {
+		options = append(options, mcpserver.WithToolFilter(s.filterToolsByToken))
+		hooks.AddAfterListResources(s.filterResourcesByToken)
+		hooks.AddAfterListResourceTemplates(s.filterResourceTemplatesByToken)
+	}
+	options = append(options, mcpserver.WithHooks(hooks))
+
 	// Initialize the MCP server BEFORE loading tools
+	s.mu.Lock()
 	s.mcpServer = mcpserver.NewMCPServer(serverName, s.version, options...)
+	s.mu.Unlock()
 
 	// Now load tools after the server is initialized
-	if err := s.loadTools(cfg); err != nil {
+	limiters := newLimiterSet()
+	if err := s.loadTools(cfg, limiters); err != nil {
 		s.logger.Error("Failed to load tools: %v", err)
 		return err
 	}
 
+	// Load the prompts
+	if err := cfg.Prompts.Validate(); err != nil {
+		s.logger.Error("Invalid prompts: %v", err)
+		return fmt.Errorf("invalid prompts: %w", err)
+	}
+	s.mcpServer.AddPrompts(s.createPrompts(cfg.Prompts)...)
+	s.prompts = cfg.Prompts
+
+	// Load the resources and resource templates
+	resources, templates, err := s.createResources(cfg, limiters)
+	if err != nil {
+		s.logger.Error("Failed to load resources: %v", err)
+		return err
+	}
+	s.mcpServer.AddResources(resources...)
+	s.mcpServer.AddResourceTemplates(templates...)
+	s.resources = cfg.MCP.Resources
+	s.setLimiters(limiters)
+
 	return nil
 }
 
 // loadTools loads tools from the configuration and registers them with the server
-func (s *Server) loadTools(cfg *config.ToolsConfig) error {
+func (s *Server) loadTools(cfg *config.ToolsConfig, limiters *limiterSet) error {
+	serverTools, toolConfigs, err := s.createTools(cfg, limiters)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mcpServer.AddTools(serverTools...)
+	s.tools = toolConfigs
+	s.run = cfg.MCP.Run
+
+	return nil
+}
+
+// createTools creates the MCP tools (and their handlers) for all the tools in the configuration
+// that have their prerequisites met.
+//
+// Parameters:
+//   - cfg: The configuration
+//   - limiters: The limiters of the configuration, where the limiters of the tools are added
+//
+// Returns:
+//   - The tools to register in the MCP server
+//   - The configuration of these tools, indexed by name
+//   - An error if there are no tools or some tool cannot be created
+func (s *Server) createTools(cfg *config.ToolsConfig, limiters *limiterSet) ([]mcpserver.ServerTool, map[string]config.MCPToolConfig, error) {
 	// Check if there are any tools defined
 	if len(cfg.MCP.Tools) == 0 {
 		s.logger.Error("No tools defined in the configuration file")
-		return fmt.Errorf("no tools defined in the configuration file")
+		return nil, nil, fmt.Errorf("no tools defined in the configuration file")
 	}
 
 	s.logger.Info("Found %d tools in configuration", len(cfg.MCP.Tools))
@@ -274,24 +420,43 @@ func (s *Server) loadTools(cfg *config.ToolsConfig) error {
 
 	s.logger.Info("Registering %d tools after checking prerequisites", len(toolDefs))
 
+	serverTools := make([]mcpserver.ServerTool, 0, len(toolDefs))
+	toolConfigs := make(map[string]config.MCPToolConfig, len(toolDefs))
+
 	for _, toolDef := range toolDefs {
 		s.logger.Debug("Registering tool '%s'", toolDef.MCPTool.Name)
 
 		// Get the parameter types for this tool
 		params := cfg.MCP.Tools[s.findToolByName(cfg.MCP.Tools, toolDef.MCPTool.Name)].Params
 
+		if err := toolDef.Config.Run.Validate(); err != nil {
+			s.logger.Error("Invalid run configuration for tool '%s': %v", toolDef.MCPTool.Name, err)
+			return nil, nil, fmt.Errorf("%w for tool '%s'", err, toolDef.MCPTool.Name)
+		}
+
 		// Create a new command handler instance
 		cmdHandler, err := command.NewCommandHandler(toolDef, params, s.shell, s.logger)
 		if err != nil {
 			s.logger.Error("Failed to create handler for tool '%s': %v", toolDef.MCPTool.Name, err)
-			return fmt.Errorf("failed to create handler for tool '%s': %w", toolDef.MCPTool.Name, err)
+			return nil, nil, fmt.Errorf("failed to create handler for tool '%s': %w", toolDef.MCPTool.Name, err)
+		}
+		cmdHandler.SetAuditLogger(s.audit)
+		cmdHandler.SetTemplateFunctions(cfg.MCP.Run.TemplateFunctions)
+		cmdHandler.SetConcurrencyLimiters(s.concurrencyLimiters(limiters, cfg.MCP.Run, fmt.Sprintf("of tool '%s'", toolDef.MCPTool.Name), toolDef.Config.Run.MaxConcurrent)...)
+		cmdHandler.SetLockManager(s.locks)
+
+		usageLimiters, err := s.usageLimiters(limiters, cfg.MCP.Run, fmt.Sprintf("tool '%s'", toolDef.MCPTool.Name), toolDef.Config.Run)
+		if err != nil {
+			s.logger.Error("Failed to create handler for tool '%s': %v", toolDef.MCPTool.Name, err)
+			return nil, nil, err
 		}
+		cmdHandler.SetUsageLimiters(usageLimiters...)
 
 		// Get the MCP handler and wrap it with panic recovery
 		safeHandler := s.wrapHandlerWithPanicRecovery(cmdHandler.GetMCPHandler())
 
-		// Add the tool to the server
-		s.mcpServer.AddTool(toolDef.MCPTool, safeHandler)
+		serverTools = append(serverTools, mcpserver.ServerTool{Tool: toolDef.MCPTool, Handler: safeHandler})
+		toolConfigs[toolDef.MCPTool.Name] = toolDef.Config
 
 		// Print whether constraints are enabled
 		if len(toolDef.Config.Constraints) > 0 {
@@ -303,7 +468,7 @@ func (s *Server) loadTools(cfg *config.ToolsConfig) error {
 		}
 	}
 
-	return nil
+	return serverTools, toolConfigs, nil
 }
 
 // wrapHandlerWithPanicRecovery adds panic recovery to a tool handler
@@ -351,7 +516,11 @@ func (s *Server) GetTools() ([]mcp.Tool, error) {
 	// Create a slice to store the tools
 	// Since we don't have direct access to all tools, we'll need to extract them
 	// from the original configuration
-	cfg, err := config.NewConfigFromFile(s.configFile)
+	s.mu.Lock()
+	configFile := s.configFile
+	s.mu.Unlock()
+
+	cfg, err := config.NewConfigFromFile(configFile)
 	if err != nil {
 		s.logger.Error("Failed to load config: %v", err)
 		return nil, fmt.Errorf("failed to load config: %w", err)
@@ -366,127 +535,3 @@ func (s *Server) GetTools() ([]mcp.Tool, error) {
 
 	return tools, nil
 }
-
-// StartHTTP initializes the MCP server and starts an HTTP server for MCP protocol over HTTP/SSE
-func (s *Server) StartHTTP(port int) error {
-	s.logger.Info("Initializing MCP HTTP server on port %d", port)
-	if err := s.CreateServer(); err != nil {
-		return err
-	}
-	http.HandleFunc("/sse", s.handleMCPHTTP)
-	addr := fmt.Sprintf(":%d", port)
-	s.logger.Info("MCP HTTP server listening on http://localhost%s/sse", addr)
-	return http.ListenAndServe(addr, nil)
-}
-
-// handleMCPHTTP handles HTTP POST requests for MCP protocol
-func (s *Server) handleMCPHTTP(w http.ResponseWriter, r *http.Request) {
-	s.logger.Info("New HTTP connection from %s %s %s", r.RemoteAddr, r.Method, r.URL.Path)
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
-		s.logger.Error("Failed to read request body from %s: %v", r.RemoteAddr, err)
-		return
-	}
-
-	s.logger.Info("Request body: %s", string(body))
-
-	// Parse the JSON-RPC request
-	var req map[string]interface{}
-	if err := json.Unmarshal(body, &req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		s.logger.Error("Invalid JSON from %s: %v", r.RemoteAddr, err)
-		return
-	}
-
-	// Intercept "initialize" method
-	if method, ok := req["method"].(string); ok && method == "initialize" {
-		id := req["id"]
-		s.logger.Info("Received 'initialize' from %s (id=%v)", r.RemoteAddr, id)
-
-		// Extract protocolVersion from params
-		protocolVersion := ""
-		if params, ok := req["params"].(map[string]interface{}); ok {
-			if pv, ok := params["protocolVersion"].(string); ok {
-				protocolVersion = pv
-			}
-		}
-		if protocolVersion == "" {
-			protocolVersion = "2025-03-26" // fallback, should always be present
-		}
-
-		resp := map[string]interface{}{
-			"jsonrpc": "2.0",
-			"id":      id,
-			"result": map[string]interface{}{
-				"serverInfo": map[string]interface{}{
-					"name":    "MCPShell",
-					"version": s.version,
-				},
-				"capabilities": map[string]interface{}{
-					"tools": map[string]interface{}{
-						"allowedTools": s.getAllowedToolNames(),
-					},
-				},
-				"sessionId":       "local",
-				"protocolVersion": protocolVersion,
-			},
-		}
-		respBytes, err := json.Marshal(resp)
-		if err != nil {
-			s.logger.Error("Failed to marshal response: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		s.logger.Info("Response: %s", string(respBytes))
-		if _, err := w.Write(respBytes); err != nil {
-			s.logger.Error("Failed to write response: %v", err)
-		}
-		return
-	}
-
-	// Fallback to normal MCP handling
-	s.logger.Info("Received MCP request from %s: method=%v id=%v", r.RemoteAddr, req["method"], req["id"])
-	ctx := r.Context()
-	resp := s.mcpServer.HandleMessage(ctx, body)
-	var respBytes []byte
-	switch v := resp.(type) {
-	case []byte:
-		respBytes = v
-	case string:
-		respBytes = []byte(v)
-	default:
-		b, err := json.Marshal(v)
-		if err != nil {
-			s.logger.Error("Failed to marshal MCP response: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		respBytes = b
-	}
-	s.logger.Info("Response: %s", string(respBytes))
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(respBytes); err != nil {
-		s.logger.Error("Failed to write response: %v", err)
-	}
-}
-
-// Helper to get tool names
-func (s *Server) getAllowedToolNames() []string {
-	tools, err := s.GetTools()
-	if err != nil {
-		return []string{}
-	}
-	names := make([]string, 0, len(tools))
-	for _, t := range tools {
-		names = append(names, t.Name)
-	}
-	return names
-}
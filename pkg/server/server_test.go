@@ -3,6 +3,7 @@ package server
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/inercia/MCPShell/pkg/common"
@@ -135,3 +136,82 @@ func TestServer_loadTools(t *testing.T) {
 	// Skip actually loading the tools to avoid running commands
 	t.Skip("loadTools() is tested in integration tests")
 }
+
+func TestServer_TemplateFunctions(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	tool := `
+  tools:
+    - name: "home"
+      description: "Show the home directory"
+      run:
+        command: "echo {{ env \"HOME\" }}"
+`
+	tests := []struct {
+		name    string
+		run     string
+		wantErr bool
+	}{
+		{"not allowed", "", true},
+		{"allowed", "\n  run:\n    template_functions: [env]", false},
+		{"unknown function", "\n  run:\n    template_functions: [env, nonexistent]", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configFile, []byte("mcp:"+tt.run+tool), 0644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			srv := New(Config{ConfigFile: configFile, Logger: logger})
+			err := srv.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_CreateServerRunValidation(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		run     string
+		wantErr string
+	}{
+		{"command", `command: "echo test"`, ""},
+		{"argv", `argv: ["echo", "test"]`, ""},
+		{"command and argv", "command: \"echo test\"\n        argv: [\"echo\", \"test\"]", "command and argv cannot be used together"},
+		{"argv with an unknown runner", "argv: [\"echo\", \"test\"]\n        runners:\n          - name: chroot", "runner 'chroot' does not support argv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config.yaml")
+			content := "mcp:\n  tools:\n    - name: \"test_tool\"\n      description: \"Test tool\"\n      run:\n        " + tt.run + "\n"
+			if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			srv := New(Config{ConfigFile: configFile, Logger: logger})
+			err := srv.CreateServer()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CreateServer() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CreateServer() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	mcpserver "github.com/mark3labs/mcp-go/server"
+)
+
+// serveStdio serves the MCP server on stdin/stdout until the client closes stdin
+// or the process receives SIGTERM or SIGINT. In both cases, the tool calls still
+// running are cancelled (terminating their commands) before returning.
+func (s *Server) serveStdio() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stdin := &cancelOnEOFReader{reader: os.Stdin, cancel: func() {
+		s.logger.Info("Client disconnected, cancelling the running tools")
+		cancel()
+	}}
+
+	err := mcpserver.NewStdioServer(s.mcpServer).Listen(ctx, stdin, os.Stdout)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// cancelOnEOFReader is a reader that calls cancel when the reader returns an
+// error (ie, io.EOF when the client exits)
+type cancelOnEOFReader struct {
+	reader io.Reader
+	cancel func()
+}
+
+// Read reads from the reader, calling cancel on errors
+func (r *cancelOnEOFReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil {
+		r.cancel()
+	}
+	return n, err
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// tlsReloadInterval is the minimum interval between checks of the certificate files
+const tlsReloadInterval = 10 * time.Second
+
+// tlsReloader provides the TLS configuration of the HTTP server, loading the
+// certificate files again when they change (ie, when certificates are renewed).
+// Files are checked on new connections, at most once every checkInterval.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	checkInterval time.Duration
+	logger        *common.Logger
+
+	mu        sync.Mutex
+	config    *tls.Config          // the current configuration
+	modTimes  map[string]time.Time // modification times of the files when they were loaded
+	lastCheck time.Time
+}
+
+// newTLSReloader creates a reloader for the given certificate files, loading them.
+//
+// Parameters:
+//   - certFile: Path to the server certificate (PEM)
+//   - keyFile: Path to the server private key (PEM)
+//   - clientCAFile: Path to the CAs for verifying client certificates (PEM, optional).
+//     When provided, clients must present a certificate signed by one of these CAs.
+//   - logger: Logger for reporting reloads
+//
+// Returns:
+//   - A new tlsReloader
+//   - An error if the files cannot be loaded
+func newTLSReloader(certFile, keyFile, clientCAFile string, logger *common.Logger) (*tlsReloader, error) {
+	r := &tlsReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		clientCAFile:  clientCAFile,
+		checkInterval: tlsReloadInterval,
+		logger:        logger,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.lastCheck = time.Now()
+	return r, nil
+}
+
+// files returns the list of files used for the TLS configuration
+func (r *tlsReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// load loads the certificate files and builds a new TLS configuration
+func (r *tlsReloader) load() error {
+	modTimes := map[string]time.Time{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to access %s: %w", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   httpNextProtos, // it replaces the config of the server, where HTTP/2 is enabled
+	}
+
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificates found in client CA file %s", r.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.mu.Lock()
+	r.config = config
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	return nil
+}
+
+// maybeReload loads the files again if they have changed since the last time
+// they were loaded. Invalid files are logged and the previous configuration is kept.
+func (r *tlsReloader) maybeReload() {
+	r.mu.Lock()
+	if time.Since(r.lastCheck) < r.checkInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastCheck = time.Now()
+
+	changed := false
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(r.modTimes[file]) {
+			changed = true
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		r.logger.Error("Failed to reload TLS certificates, keeping the previous ones: %v", err)
+		return
+	}
+	r.logger.Info("Reloaded TLS certificates")
+}
+
+// httpNextProtos are the protocols negotiated with ALPN by the HTTP server: HTTP/2 and HTTP/1.1
+var httpNextProtos = []string{"h2", "http/1.1"}
+
+// tlsConfig returns the TLS configuration for the HTTP server
+func (r *tlsReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: httpNextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.maybeReload()
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return r.config, nil
+		},
+	}
+}
+
+// identifyClientCertificate uses the subject of the verified client certificate
+// (if any) as the identity of the caller of the HTTP requests.
+func (s *Server) identifyClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			subject := r.TLS.VerifiedChains[0][0].Subject
+			caller := &common.Caller{Name: subject.CommonName, Subject: subject.String()}
+			s.logger.Debug("HTTP request from %s with client certificate '%s'", r.RemoteAddr, caller.Subject)
+			r = r.WithContext(common.WithCaller(r.Context(), caller))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/inercia/MCPShell/pkg/common"
+)
+
+// testCert is a certificate (and its key) generated for the tests
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by the parent (self-signed if nil)
+func newTestCert(t *testing.T, commonName string, serial int64, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"MCPShell"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signerCert, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// tlsClientConfig returns a client configuration trusting the CA and using the client certificate (if any)
+func (c *testCert) tlsClientConfig(t *testing.T, clientCert *testCert) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	config := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	if clientCert != nil {
+		pair, err := tls.X509KeyPair(clientCert.certPEM, clientCert.keyPEM)
+		if err != nil {
+			t.Fatalf("Failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	logger, err := common.NewLogger("", "", common.LogLevelNone, false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	ca := newTestCert(t, "Test CA", 1, nil)
+	serverCert := newTestCert(t, "localhost", 2, ca)
+	aliceCert := newTestCert(t, "alice", 3, ca)
+	bobCert := newTestCert(t, "bob", 4, ca)
+
+	certFile := writeFile("server.crt", serverCert.certPEM)
+	keyFile := writeFile("server.key", serverCert.keyPEM)
+	caFile := writeFile("ca.crt", ca.certPEM)
+
+	configFile := writeFile("config.yaml", []byte(`mcp:
+  tools:
+    - name: "whoami"
+      description: "Only for alice"
+      constraints:
+        - "_caller.name == 'alice'"
+        - "_caller.subject.contains('O=MCPShell')"
+      run:
+        command: "echo hello alice"
+`))
+
+	srv := New(Config{ConfigFile: configFile, Logger: logger, Version: "test"})
+	if err := srv.CreateServer(); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	reloader, err := newTLSReloader(certFile, keyFile, caFile, logger)
+	if err != nil {
+		t.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+	reloader.checkInterval = 0
+
+	httpServer := httptest.NewUnstartedServer(srv.newHTTPHandler(HTTPConfig{}))
+	httpServer.TLS = reloader.tlsConfig()
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	callTool := func(clientCert *testCert) (*mcp.CallToolResult, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: ca.tlsClientConfig(t, clientCert)}}
+		c, err := client.NewStreamableHttpClient(httpServer.URL+DefaultHTTPPath, transport.WithHTTPBasicClient(httpClient))
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = c.Close() }()
+
+		initRequest := mcp.InitializeRequest{}
+		initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+		initRequest.Params.ClientInfo = mcp.Implementation{Name: "test", Version: "1.0"}
+		if _, err := c.Initialize(ctx, initRequest); err != nil {
+			return nil, err
+		}
+
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "whoami"
+		return c.CallTool(ctx, request)
+	}
+
+	if _, err := callTool(nil); err == nil {
+		t.Error("Expected an error connecting without a client certificate")
+	}
+
+	result, err := callTool(aliceCert)
+	if err != nil {
+		t.Fatalf("Unexpected error with a valid client certificate: %v", err)
+	}
+	if result.IsError {
+		t.Errorf("Expected the constraints to pass for alice, got %+v", result.Content)
+	}
+
+	result, err = callTool(bobCert)
+	if err != nil {
+		t.Fatalf("Unexpected error with a valid client certificate: %v", err)
+	}
+	if !result.IsError {
+		t.Error("Expected the constraints to block bob")
+	}
+
+	// certificates are reloaded when the files change
+	newServerCert := newTestCert(t, "localhost", 5, ca)
+	writeFile("server.crt", newServerCert.certPEM)
+	writeFile("server.key", newServerCert.keyPEM)
+	future := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, future, future); err != nil {
+			t.Fatalf("Failed to change modification time: %v", err)
+		}
+	}
+
+	clientConfig := ca.tlsClientConfig(t, aliceCert)
+	clientConfig.NextProtos = []string{"h2", "http/1.1"}
+	conn, err := tls.Dial("tcp", httpServer.Listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Failed to connect after reloading: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if serial := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 5 {
+		t.Errorf("Expected the reloaded certificate (serial 5), got serial %d", serial)
+	}
+	if protocol := conn.ConnectionState().NegotiatedProtocol; protocol != "h2" {
+		t.Errorf("Expected HTTP/2 to be negotiated, got %q", protocol)
+	}
+
+	// invalid files are ignored, keeping the previous certificate
+	writeFile("server.key", []byte("invalid"))
+	if err := os.Chtimes(keyFile, future.Add(time.Minute), future.Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to change modification time: %v", err)
+	}
+	conn2, err := tls.Dial("tcp", httpServer.Listener.Addr().String(), ca.tlsClientConfig(t, aliceCert))
+	if err != nil {
+		t.Fatalf("Failed to connect after an invalid reload: %v", err)
+	}
+	defer func() { _ = conn2.Close() }()
+	if serial := conn2.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 5 {
+		t.Errorf("Expected the previous certificate (serial 5), got serial %d", serial)
+	}
+}
+
+func TestNewTLSReloaderErrors(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelNone, false)
+	tempDir := t.TempDir()
+
+	ca := newTestCert(t, "Test CA", 1, nil)
+	certFile := filepath.Join(tempDir, "server.crt")
+	keyFile := filepath.Join(tempDir, "server.key")
+	badCA := filepath.Join(tempDir, "bad-ca.crt")
+	_ = os.WriteFile(certFile, ca.certPEM, 0600)
+	_ = os.WriteFile(keyFile, ca.keyPEM, 0600)
+	_ = os.WriteFile(badCA, []byte("not a certificate"), 0600)
+
+	if _, err := newTLSReloader(certFile, filepath.Join(tempDir, "missing.key"), "", logger); err == nil {
+		t.Error("Expected an error for a missing key")
+	}
+	if _, err := newTLSReloader(certFile, keyFile, badCA, logger); err == nil {
+		t.Error("Expected an error for an invalid client CA file")
+	}
+	if _, err := newTLSReloader(certFile, keyFile, "", logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}